@@ -0,0 +1,194 @@
+// Copyright 2021-present Kuei-chun Chen. All rights reserved.
+
+package keyhole
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const defaultShortLivedSeconds = 10
+
+// ConnectionChurn stores connections accepted and ended over time
+type ConnectionChurn struct {
+	Clients           []ClientChurn `bson:"clients"`
+	Filename          string        `bson:"filename"`
+	PeakConnections   int           `bson:"peakConnections"`
+	PeakTime          string        `bson:"peakTime"`
+	ShortLived        int           `bson:"shortLived"`
+	ShortLivedSeconds int           `bson:"shortLivedSeconds"`
+	Timeline          []ChurnPoint  `bson:"timeline"`
+
+	clients map[string]*ClientChurn
+	conns   map[int]openConnection
+}
+
+// ClientChurn stores connections accepted and ended of a client
+type ClientChurn struct {
+	Accepted      int          `bson:"accepted"`
+	Client        string       `bson:"client"`
+	Ended         int          `bson:"ended"`
+	ShortLived    int          `bson:"shortLived"`
+	TotalLifetime float64      `bson:"totalLifetime"` // seconds of ended connections
+	Timeline      []ChurnPoint `bson:"timeline"`
+}
+
+// ChurnPoint stores per minute connections accepted and ended
+type ChurnPoint struct {
+	Accepted       int    `bson:"accepted"`
+	Ended          int    `bson:"ended"`
+	MaxConnections int    `bson:"maxConnections,omitempty"`
+	UTC            string `bson:"utc"`
+}
+
+type openConnection struct {
+	client string
+	t      time.Time
+}
+
+// NewConnectionChurn returns ConnectionChurn
+func NewConnectionChurn(filename string, shortLivedSeconds int) *ConnectionChurn {
+	if shortLivedSeconds <= 0 {
+		shortLivedSeconds = defaultShortLivedSeconds
+	}
+	return &ConnectionChurn{Filename: filename, ShortLivedSeconds: shortLivedSeconds,
+		clients: map[string]*ClientChurn{}, conns: map[int]openConnection{}}
+}
+
+// Add adds a connection accepted or ended log entry
+func (cc *ConnectionChurn) Add(doc Logv2Network) {
+	isAccepted := doc.Message == "Connection accepted"
+	if !isAccepted && doc.Message != "Connection ended" {
+		return
+	}
+	timestamp := doc.Timestamp["$date"]
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return
+	}
+	utc := t.UTC().Format("2006-01-02T15:04:00Z")
+	client, _, err := net.SplitHostPort(doc.Attributes.Remote) // e.g. 10.0.0.1:53124 or [::1]:53124
+	if err != nil {
+		client = doc.Attributes.Remote
+	}
+	cchurn := cc.clients[client]
+	if cchurn == nil {
+		cchurn = &ClientChurn{Client: client}
+		cc.clients[client] = cchurn
+	}
+	point := ChurnPoint{UTC: utc, MaxConnections: doc.Attributes.ConnectionCount}
+	if isAccepted {
+		cchurn.Accepted++
+		point.Accepted = 1
+		cc.conns[doc.Attributes.ConnectionID] = openConnection{client: client, t: t}
+	} else {
+		cchurn.Ended++
+		point.Ended = 1
+		if conn, ok := cc.conns[doc.Attributes.ConnectionID]; ok {
+			lifetime := t.Sub(conn.t).Seconds()
+			cchurn.TotalLifetime += lifetime
+			if lifetime < float64(cc.ShortLivedSeconds) {
+				cchurn.ShortLived++
+				cc.ShortLived++
+			}
+			delete(cc.conns, doc.Attributes.ConnectionID)
+		}
+	}
+	if doc.Attributes.ConnectionCount > cc.PeakConnections {
+		cc.PeakConnections = doc.Attributes.ConnectionCount
+		cc.PeakTime = timestamp
+	}
+	cc.Timeline = addChurnPoint(cc.Timeline, point)
+	cchurn.Timeline = addChurnPoint(cchurn.Timeline, ChurnPoint{Accepted: point.Accepted, Ended: point.Ended, UTC: utc})
+}
+
+// addChurnPoint adds a point to a per minute timeline
+func addChurnPoint(timeline []ChurnPoint, point ChurnPoint) []ChurnPoint {
+	for i := len(timeline) - 1; i >= 0; i-- {
+		if timeline[i].UTC == point.UTC {
+			timeline[i].Accepted += point.Accepted
+			timeline[i].Ended += point.Ended
+			if point.MaxConnections > timeline[i].MaxConnections {
+				timeline[i].MaxConnections = point.MaxConnections
+			}
+			return timeline
+		} else if timeline[i].UTC < point.UTC {
+			break
+		}
+	}
+	timeline = append(timeline, point)
+	if n := len(timeline); n > 1 && timeline[n-2].UTC > point.UTC { // out of order, e.g. of merged logs
+		sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].UTC < timeline[j].UTC })
+	}
+	return timeline
+}
+
+// Finalize sorts clients by connections accepted
+func (cc *ConnectionChurn) Finalize() {
+	cc.Clients = []ClientChurn{}
+	for _, client := range cc.clients {
+		cc.Clients = append(cc.Clients, *client)
+	}
+	sort.Slice(cc.Clients, func(i, j int) bool {
+		if cc.Clients[i].Accepted != cc.Clients[j].Accepted {
+			return cc.Clients[i].Accepted > cc.Clients[j].Accepted
+		}
+		return cc.Clients[i].Client < cc.Clients[j].Client
+	})
+}
+
+// GetPeakAcceptRate returns the minute with the most connections accepted
+func (cc *ConnectionChurn) GetPeakAcceptRate() ChurnPoint {
+	peak := ChurnPoint{}
+	for _, point := range cc.Timeline {
+		if point.Accepted > peak.Accepted {
+			peak = point
+		}
+	}
+	return peak
+}
+
+// Print prints connection churn summary
+func (cc *ConnectionChurn) Print() {
+	peak := cc.GetPeakAcceptRate()
+	fmt.Println(" - peak connections", cc.PeakConnections, "at", cc.PeakTime)
+	fmt.Printf(" - peak accept rate %v/min at %v\n", peak.Accepted, peak.UTC)
+	fmt.Printf(" - connections shorter than %vs: %v\n", cc.ShortLivedSeconds, cc.ShortLived)
+	for _, client := range cc.Clients {
+		avg := 0.0
+		if client.Ended > 0 {
+			avg = client.TotalLifetime / float64(client.Ended)
+		}
+		maxRate := 0
+		for _, point := range client.Timeline {
+			if point.Accepted > maxRate {
+				maxRate = point.Accepted
+			}
+		}
+		fmt.Printf(" - %v had %v accepted, %v ended, %v short-lived, avg lifetime %.1fs, max %v/min accepted\n",
+			client.Client, client.Accepted, client.Ended, client.ShortLived, avg, maxRate)
+	}
+}
+
+// OutputJSON writes connection churn to a JSON file
+func (cc *ConnectionChurn) OutputJSON() (string, error) {
+	var err error
+	var data []byte
+	if data, err = bson.MarshalExtJSON(cc, false, false); err != nil {
+		return "", err
+	}
+	os.Mkdir(outdir, 0755)
+	basename := strings.TrimSuffix(filepath.Base(cc.Filename), ".gz")
+	ofile := fmt.Sprintf(`%v/%v-connections.json`, outdir, basename)
+	if err = os.WriteFile(ofile, data, 0644); err != nil {
+		return "", err
+	}
+	return ofile, err
+}
@@ -0,0 +1,47 @@
+// Copyright 2021-present Kuei-chun Chen. All rights reserved.
+
+package keyhole
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConnectionChurn(t *testing.T) {
+	logs := []string{
+		`{"t":{"$date":"2021-03-01T10:00:00.100+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"10.0.0.5:50100","connectionId":1,"connectionCount":1}}`,
+		`{"t":{"$date":"2021-03-01T10:00:00.200+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"10.0.0.5:50101","connectionId":2,"connectionCount":2}}`,
+		`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"NETWORK","id":22944,"ctx":"conn2","msg":"Connection ended","attr":{"remote":"10.0.0.5:50101","connectionId":2,"connectionCount":1}}`,
+		`{"t":{"$date":"2021-03-01T10:01:00.000+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"10.0.0.6:50200","connectionId":3,"connectionCount":2}}`,
+		`{"t":{"$date":"2021-03-01T10:02:00.100+00:00"},"s":"I","c":"NETWORK","id":22944,"ctx":"conn1","msg":"Connection ended","attr":{"remote":"10.0.0.5:50100","connectionId":1,"connectionCount":1}}`,
+		`{"t":{"$date":"2021-03-01T10:03:00.000+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"[::1]:53124","connectionId":4,"connectionCount":2}}`,
+		`{"t":{"$date":"2021-03-01T10:03:00.100+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"[fe80::2]:53125","connectionId":5,"connectionCount":2}}`,
+		`{"t":{"$date":"2021-03-01T09:59:00.000+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"10.0.0.7:50300","connectionId":6,"connectionCount":1}}`,
+	}
+	churn := NewConnectionChurn("mongod.log", 0)
+	for _, str := range logs {
+		var doc Logv2Network
+		if err := json.Unmarshal([]byte(str), &doc); err != nil {
+			t.Fatal(err)
+		}
+		churn.Add(doc)
+	}
+	churn.Finalize()
+	if churn.PeakConnections != 2 || churn.PeakTime != "2021-03-01T10:00:00.200+00:00" {
+		t.Fatal("unexpected peak", churn.PeakConnections, churn.PeakTime)
+	}
+	if churn.ShortLived != 1 {
+		t.Fatal("expected 1 short-lived connection, but got", churn.ShortLived)
+	}
+	if len(churn.Timeline) != 5 || churn.Timeline[0].UTC != "2021-03-01T09:59:00Z" ||
+		churn.Timeline[1].Accepted != 2 || churn.Timeline[1].Ended != 1 {
+		t.Fatal("unexpected timeline", churn.Timeline)
+	}
+	if len(churn.Clients) != 5 || churn.Clients[0].Client != "10.0.0.5" || churn.Clients[0].Accepted != 2 ||
+		churn.Clients[1].Client != "10.0.0.6" || churn.Clients[3].Client != "::1" || churn.Clients[4].Client != "fe80::2" {
+		t.Fatal("unexpected clients", churn.Clients)
+	}
+	if peak := churn.GetPeakAcceptRate(); peak.UTC != "2021-03-01T10:00:00Z" {
+		t.Fatal("unexpected peak accept rate", peak)
+	}
+}
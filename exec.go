@@ -24,6 +24,8 @@ type Config struct {
 	URI       string `bson:"uri,omitempty"`
 	Verbose   bool   `bson:"verbose,omitempty"`
 
+	ShortLivedSeconds int `bson:"short_lived_seconds,omitempty"`
+
 	IsDeepCompare bool     `bson:"deep_compare,omitempty"`
 	Filters       []Filter `bson:"filters,omitempty"`
 	SampleSize    int      `bson:"sample_size,omitempty"`
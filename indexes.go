@@ -3,6 +3,9 @@
 package keyhole
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/simagix/keyhole/mdb"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -37,5 +40,20 @@ func CollectIndexStats(index *mdb.IndexStats, client *mongo.Client, maobiURL str
 	if ofile, data, err = index.OutputBSON(); err != nil {
 		return err
 	}
+	if err = OutputIndexCleanup(index); err != nil {
+		return err
+	}
 	return GenerateMaobiReport(maobiURL, data, ofile)
 }
+
+// OutputIndexCleanup prints unused, redundant and duplicate indexes and writes a script to hide them before dropping
+func OutputIndexCleanup(index *mdb.IndexStats) error {
+	reviews := index.GetIndexReviews()
+	index.PrintIndexReviews(reviews)
+	ofiles, err := index.OutputIndexCleanup(reviews)
+	if err != nil {
+		return err
+	}
+	fmt.Println("index cleanup review and script written to", strings.Join(ofiles, " and "))
+	return nil
+}
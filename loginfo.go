@@ -29,3 +29,19 @@ func AnalyzeMongoLogs(loginfo *mdb.LogInfo, filenames []string, maobiURL string)
 	}
 	return err
 }
+
+// AnalyzeMergedMongoLogs a helper function to analyze logs of all members and
+// output a merged report
+func AnalyzeMergedMongoLogs(loginfo *mdb.LogInfo, filenames []string, maobiURL string) error {
+	var err error
+	var data []byte
+	var ofile string
+	if err = loginfo.AnalyzeFiles(filenames); err != nil {
+		return err
+	}
+	loginfo.Print()
+	if ofile, data, err = loginfo.OutputBSON(); err != nil {
+		return err
+	}
+	return GenerateMaobiReport(maobiURL, data, ofile)
+}
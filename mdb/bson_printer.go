@@ -74,6 +74,12 @@ func (p *BSONPrinter) Print(filename string) error {
 		if err = ix.OutputJSON(); err != nil {
 			return err
 		}
+		ix.SetFilename(filepath.Base(filename))
+		reviews := ix.GetIndexReviews()
+		ix.PrintIndexReviews(reviews)
+		if _, err = ix.OutputIndexCleanup(reviews); err != nil {
+			return err
+		}
 	} else if strings.HasSuffix(filename, ".bson.gz") {
 		if strings.HasSuffix(filename, "-perf.bson.gz") {
 			type Perf struct {
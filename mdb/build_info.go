@@ -18,7 +18,11 @@ type BuildInfo struct {
 
 // GetBuildInfo returns MongoDB build information
 func GetBuildInfo(client *mongo.Client) (BuildInfo, error) {
-	ctx := context.Background()
+	return GetBuildInfoWithContext(context.Background(), client)
+}
+
+// GetBuildInfoWithContext returns build information within a context
+func GetBuildInfoWithContext(ctx context.Context, client *mongo.Client) (BuildInfo, error) {
 	var buildInfo BuildInfo
 	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo)
 	return buildInfo, err
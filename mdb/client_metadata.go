@@ -0,0 +1,162 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const logIDClientMetadata = 51800
+const logIDConnectionEnded = 22944
+
+// ClientInfo stores client metadata of connections
+type ClientInfo struct {
+	App           string `bson:"app"`
+	Connections   int    `bson:"connections"`
+	Driver        string `bson:"driver"`
+	DriverVersion string `bson:"driverVersion"`
+	OS            string `bson:"os"`
+}
+
+// OpApp stores performance data of an op pattern from an application
+type OpApp struct {
+	App        string `bson:"app"`
+	Count      int    `bson:"count"`
+	MaxMilli   int    `bson:"maxmilli"`
+	TotalMilli int64  `bson:"totalmilli"`
+}
+
+// Logv2ClientMetadata stores logv2 client metadata info
+type Logv2ClientMetadata struct {
+	Attributes struct {
+		Doc struct {
+			Application struct {
+				Name string `json:"name"`
+			} `json:"application"`
+			Driver struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"driver"`
+			OS struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"os"`
+		} `json:"doc"`
+		Remote string `json:"remote"`
+	} `json:"attr"`
+	Context string `json:"ctx"`
+	ID      int    `json:"id"`
+	Message string `json:"msg"`
+}
+
+// ParseClientMetadata returns connection context (e.g. conn123), remote
+// address and client info from a logv2 client metadata entry
+func ParseClientMetadata(str string) (string, string, ClientInfo, error) {
+	var doc Logv2ClientMetadata
+	var client ClientInfo
+	if !strings.Contains(str, `"client metadata"`) {
+		return "", "", client, errors.New("not client metadata")
+	}
+	if err := json.Unmarshal([]byte(str), &doc); err != nil {
+		return "", "", client, err
+	}
+	if doc.ID != logIDClientMetadata && doc.Message != "client metadata" {
+		return "", "", client, errors.New("not client metadata")
+	}
+	meta := doc.Attributes.Doc
+	client = ClientInfo{App: meta.Application.Name, Connections: 1, Driver: meta.Driver.Name,
+		DriverVersion: meta.Driver.Version, OS: meta.OS.Type}
+	if client.OS == "" {
+		client.OS = meta.OS.Name
+	}
+	return doc.Context, doc.Attributes.Remote, client, nil
+}
+
+// key returns key of a client info
+func (ci ClientInfo) key() string {
+	return ci.App + "/" + ci.Driver + "/" + ci.DriverVersion + "/" + ci.OS
+}
+
+// addClientMetadata keeps client info of a connection
+func (li *LogInfo) addClientMetadata(doc Logv2, str string) {
+	if doc.ID == logIDConnectionEnded {
+		delete(li.clients, doc.Context)
+		return
+	}
+	if doc.ID != logIDClientMetadata {
+		return
+	}
+	conn, _, client, err := ParseClientMetadata(str)
+	if err != nil {
+		return
+	}
+	if li.clients == nil {
+		li.clients = map[string]ClientInfo{}
+	}
+	li.clients[conn] = client
+	li.Clients = MergeClientInfos(li.Clients, []ClientInfo{client})
+}
+
+// MergeClientInfos merges connection counts of client infos
+func MergeClientInfos(clients []ClientInfo, others []ClientInfo) []ClientInfo {
+	for _, other := range others {
+		found := false
+		for i := range clients {
+			if clients[i].key() == other.key() {
+				clients[i].Connections += other.Connections
+				found = true
+				break
+			}
+		}
+		if !found {
+			clients = append(clients, other)
+		}
+	}
+	return clients
+}
+
+// mergeOpApps merges per application breakdowns
+func mergeOpApps(apps []OpApp, others []OpApp) []OpApp {
+	for _, other := range others {
+		found := false
+		for i := range apps {
+			if apps[i].App == other.App {
+				apps[i].Count += other.Count
+				apps[i].TotalMilli += other.TotalMilli
+				if other.MaxMilli > apps[i].MaxMilli {
+					apps[i].MaxMilli = other.MaxMilli
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			apps = append(apps, other)
+		}
+	}
+	return apps
+}
+
+// getApps returns slow ops by applications of all op patterns
+func (li *LogInfo) getApps() []OpApp {
+	apps := []OpApp{}
+	for _, op := range li.OpPatterns {
+		apps = mergeOpApps(apps, op.Apps)
+	}
+	return apps
+}
+
+// getTopApps returns top n applications by total time
+func getTopApps(apps []OpApp, n int) []OpApp {
+	apps = append([]OpApp(nil), apps...)
+	sort.SliceStable(apps, func(i, j int) bool {
+		return apps[i].TotalMilli > apps[j].TotalMilli
+	})
+	if len(apps) > n {
+		apps = apps[:n]
+	}
+	return apps
+}
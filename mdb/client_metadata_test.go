@@ -0,0 +1,59 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+var clientLogs = []string{
+	`{"t":{"$date":"2021-03-01T10:00:00.100+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"10.0.0.5:50100","connectionId":101,"connectionCount":3}}`,
+	`{"t":{"$date":"2021-03-01T10:00:00.200+00:00"},"s":"I","c":"NETWORK","id":51800,"ctx":"conn101","msg":"client metadata","attr":{"remote":"10.0.0.5:50100","client":"conn101","doc":{"driver":{"name":"nodejs","version":"3.6.6"},"os":{"type":"Linux","name":"linux","architecture":"x64"},"platform":"Node.js v14.16.0","application":{"name":"orders-svc"}}}}`,
+	`{"t":{"$date":"2021-03-01T10:00:00.300+00:00"},"s":"I","c":"NETWORK","id":51800,"ctx":"conn102","msg":"client metadata","attr":{"remote":"10.0.0.6:50200","client":"conn102","doc":{"driver":{"name":"mongo-java-driver|sync","version":"4.2.0"},"os":{"type":"Linux","name":"Linux"},"platform":"Java/Oracle/11"}}}`,
+	`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn101","msg":"Slow query","attr":{"type":"command","ns":"keyhole.orders","command":{"find":"orders","filter":{"status":"new"}},"planSummary":"COLLSCAN","docsExamined":5000,"durationMillis":300}}`,
+	`{"t":{"$date":"2021-03-01T10:00:02.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn102","msg":"Slow query","attr":{"type":"command","ns":"keyhole.orders","appName":"billing","command":{"find":"orders","filter":{"status":"paid"}},"planSummary":"COLLSCAN","docsExamined":5000,"durationMillis":200}}`,
+	`{"t":{"$date":"2021-03-01T10:00:03.000+00:00"},"s":"I","c":"NETWORK","id":22944,"ctx":"conn101","msg":"Connection ended","attr":{"remote":"10.0.0.5:50100","connectionId":101,"connectionCount":2}}`,
+	`{"t":{"$date":"2021-03-01T10:00:04.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn101","msg":"Slow query","attr":{"type":"command","ns":"keyhole.orders","command":{"find":"orders","filter":{"status":"old"}},"planSummary":"COLLSCAN","docsExamined":5000,"durationMillis":100}}`,
+}
+
+func TestParseClientMetadata(t *testing.T) {
+	conn, remote, client, err := ParseClientMetadata(clientLogs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != "conn101" || remote != "10.0.0.5:50100" {
+		t.Fatal("unexpected connection", conn, remote)
+	}
+	if client.App != "orders-svc" || client.Driver != "nodejs" || client.DriverVersion != "3.6.6" || client.OS != "Linux" {
+		t.Fatal("unexpected client", client)
+	}
+	if _, _, _, err = ParseClientMetadata(clientLogs[0]); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLogInfoApps(t *testing.T) {
+	li := NewLogInfo("utest-xxxxxx")
+	li.SetSilent(true)
+	if err := li.Parse(bufio.NewReader(strings.NewReader(strings.Join(clientLogs, "\n")))); err != nil {
+		t.Fatal(err)
+	}
+	if len(li.Clients) != 2 {
+		t.Fatal("expected 2 clients, but got", li.Clients)
+	}
+	apps := map[string]int{}
+	for _, op := range li.OpPatterns {
+		for _, app := range op.Apps {
+			apps[app.App] += app.Count
+		}
+	}
+	// the last op came after its connection ended and cannot be attributed
+	if len(apps) != 2 || apps["orders-svc"] != 1 || apps["billing"] != 1 {
+		t.Fatal("unexpected apps", apps)
+	}
+	if top := getTopApps(li.getApps(), 1); len(top) != 1 || top[0].App != "orders-svc" {
+		t.Fatal("unexpected top apps", top)
+	}
+}
@@ -3,10 +3,13 @@
 package mdb
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"strings"
@@ -31,34 +34,51 @@ const Standalone = "standalone"
 
 // ClusterStats keeps slow ops struct
 type ClusterStats struct {
-	BuildInfo        BuildInfo        `bson:"buildInfo"`
-	CmdLineOpts      CmdLineOpts      `bson:"getCmdLineOpts"`
-	Cluster          string           `bson:"cluster"`
-	Databases        *[]Database      `bson:"databases"`
-	Host             string           `bson:"host"`
-	HostInfo         HostInfo         `bson:"hostInfo"`
-	Logger           *gox.Logger      `bson:"keyhole"`
-	OplogStats       OplogStats       `bson:"oplog"`
-	Process          string           `bson:"process"`
-	ReplSetGetStatus ReplSetGetStatus `bson:"replSetGetStatus"`
-	ServerStatus     ServerStatus     `bson:"serverStatus"`
-	Shards           []Shard          `bson:"shards"`
-	Version          string           `bson:"version"`
-
-	dbNames   []string
-	fastMode  bool
-	redact    bool
-	signature string
-	verbose   bool
-	html      bool
+	BuildInfo        BuildInfo         `bson:"buildInfo"`
+	CmdLineOpts      CmdLineOpts       `bson:"getCmdLineOpts"`
+	Cluster          string            `bson:"cluster"`
+	Databases        *[]Database       `bson:"databases"`
+	Findings         []Finding         `bson:"findings,omitempty"`
+	Host             string            `bson:"host"`
+	HostInfo         HostInfo          `bson:"hostInfo"`
+	Incomplete       []NamespaceStatus `bson:"incomplete,omitempty"` // namespaces skipped or failed
+	Interrupted      bool              `bson:"interrupted,omitempty"`
+	Logger           *gox.Logger       `bson:"keyhole"`
+	OplogStats       OplogStats        `bson:"oplog"`
+	Process          string            `bson:"process"`
+	ReplSetGetStatus ReplSetGetStatus  `bson:"replSetGetStatus"`
+	ServerStatus     ServerStatus      `bson:"serverStatus"`
+	Shards           []Shard           `bson:"shards"`
+	Version          string            `bson:"version"`
+
+	collWorkers int
+	dbNames     []string
+	dbWorkers   int
+	fastMode    bool
+	healthCheck *HealthCheck
+	redact      bool
+	signature   string
+	timeout     time.Duration
+	verbose     bool
+	html        bool
 }
 
 // NewClusterStats returns *ClusterStats
 func NewClusterStats(signature string) *ClusterStats {
-	s := ClusterStats{signature: signature}
+	s := ClusterStats{healthCheck: NewHealthCheck(), signature: signature}
 	return &s
 }
 
+// SetCollectionWorkers sets # of collections of a database collected in parallel
+func (p *ClusterStats) SetCollectionWorkers(n int) {
+	p.collWorkers = n
+}
+
+// SetDatabaseWorkers sets # of databases collected in parallel
+func (p *ClusterStats) SetDatabaseWorkers(n int) {
+	p.dbWorkers = n
+}
+
 // SetDBNames sets redact
 func (p *ClusterStats) SetDBNames(dbNames []string) {
 	p.dbNames = dbNames
@@ -74,6 +94,16 @@ func (p *ClusterStats) SetRedaction(redact bool) {
 	p.redact = redact
 }
 
+// SetRulesFile merges health check rules from a JSON file
+func (p *ClusterStats) SetRulesFile(filename string) error {
+	return p.healthCheck.ReadRulesFile(filename)
+}
+
+// SetTimeout sets timeout of a command collecting database stats, 0 for no timeout
+func (p *ClusterStats) SetTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
 // SetVerbose sets verbose mode
 func (p *ClusterStats) SetVerbose(verbose bool) {
 	p.verbose = verbose
@@ -86,28 +116,43 @@ func (p *ClusterStats) SetHTML(html bool) {
 
 // GetClusterStats collects cluster stats
 func (p *ClusterStats) GetClusterStats(client *mongo.Client, connString connstring.ConnString) error {
+	return p.GetClusterStatsWithContext(context.Background(), client, connString)
+}
+
+// GetClusterStatsWithContext collects cluster stats, stats collected before the context is canceled
+// are kept and namespaces not collected are recorded
+func (p *ClusterStats) GetClusterStatsWithContext(ctx context.Context, client *mongo.Client, connString connstring.ConnString) error {
 	var err error
 	p.Logger = gox.GetLogger(p.signature)
 	p.Logger.Info("GetClusterStats() begins")
-	if err = p.GetClusterStatsSummary(client); err != nil {
+	if err = p.GetClusterStatsSummaryWithContext(ctx, client); err != nil {
 		return err
 	}
-	if p.CmdLineOpts, err = GetCmdLineOpts(client); err != nil {
+	if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+		p.CmdLineOpts, err = GetCmdLineOptsWithContext(ctx, client)
+		return err
+	}); err != nil {
 		p.Logger.Info(fmt.Sprintf(`GetCmdLineOpts(): %v`, err))
 	}
 	if p.Cluster == Sharded { //collects from the primary of each shard
-		if p.Shards, err = GetShards(client); err != nil {
+		if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+			p.Shards, err = GetShardsWithContext(ctx, client)
+			return err
+		}); err != nil {
 			p.Logger.Infof(`GetShards(): %v`, err)
 		}
 		p.Logger.Infof("%v shards detected, collecting from all servers", len(p.Shards))
-		if p.Shards, err = p.GetServersStatsSummary(p.Shards, connString); err != nil {
+		if p.Shards, err = p.GetServersStatsSummaryWithContext(ctx, p.Shards, connString); err != nil {
 			p.Logger.Error(err)
 		}
 		p.Logger.Info("end collecting from all servers")
 	} else if p.Cluster == Replica && p.Process == "mongod" { //collects replica info
 		message := "replica detected, collecting from all servers"
 		p.Logger.Info(message)
-		if p.ReplSetGetStatus, err = GetReplSetGetStatus(client); err != nil {
+		if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+			p.ReplSetGetStatus, err = GetReplSetGetStatusWithContext(ctx, client)
+			return err
+		}); err != nil {
 			p.Logger.Info(fmt.Sprintf(`GetReplSetGetStatus(): %v`, err))
 		}
 
@@ -118,58 +163,109 @@ func (p *ClusterStats) GetClusterStats(client *mongo.Client, connString connstri
 		}
 		s := fmt.Sprintf(`%v/%v`, setName, strings.Join(hosts, ","))
 		oneShard := []Shard{{ID: setName, State: 1, Host: s}}
-		if p.Shards, err = p.GetServersStatsSummary(oneShard, connString); err != nil {
+		if p.Shards, err = p.GetServersStatsSummaryWithContext(ctx, oneShard, connString); err != nil {
 			p.Logger.Error(err)
 		}
 		p.Logger.Info("end collecting from all servers")
 	}
 	db := NewDatabaseStats(p.Logger.AppName)
+	db.SetCollectionWorkers(p.collWorkers)
+	db.SetDatabaseWorkers(p.dbWorkers)
 	db.SetNumberShards(len(p.Shards))
 	db.SetRedaction(p.redact)
+	db.SetTimeout(p.timeout)
 	db.SetVerbose(p.verbose)
 	db.SetFastMode(p.fastMode)
 	var databases []Database
-	if databases, err = db.GetAllDatabasesStats(client, p.dbNames); err != nil {
-		p.Logger.Info(fmt.Sprintf(`GetAllDatabasesStats(): %v`, err))
+	if databases, err = db.GetAllDatabasesStatsWithContext(ctx, client, p.dbNames); err != nil {
+		p.Logger.Errorf(`GetAllDatabasesStats(): %v`, err)
 	}
 	p.Databases = &databases
+	p.Incomplete = append(p.Incomplete, db.NamespaceStatuses...)
+	if ctx.Err() != nil {
+		p.Interrupted = true
+		p.Logger.Warnf(`interrupted, %d namespaces or servers skipped or failed, stats collected are kept`, len(p.Incomplete))
+	}
+	if p.healthCheck == nil {
+		p.healthCheck = NewHealthCheck()
+	}
+	p.Findings = p.healthCheck.Evaluate(p)
 	return nil
 }
 
 // GetClusterStatsSummary collects cluster stats
 func (p *ClusterStats) GetClusterStatsSummary(client *mongo.Client) error {
+	return p.GetClusterStatsSummaryWithContext(context.Background(), client)
+}
+
+// GetClusterStatsSummaryWithContext collects cluster stats, each command within the timeout
+func (p *ClusterStats) GetClusterStatsSummaryWithContext(ctx context.Context, client *mongo.Client) error {
 	var err error
 	p.Logger = gox.GetLogger(p.signature)
-	if p.BuildInfo, err = GetBuildInfo(client); err != nil {
+	if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+		p.BuildInfo, err = GetBuildInfoWithContext(ctx, client)
+		return err
+	}); err != nil {
 		return err
 	}
 	p.Version = p.BuildInfo.Version
-	if p.HostInfo, err = GetHostInfo(client); err != nil {
+	if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+		p.HostInfo, err = GetHostInfoWithContext(ctx, client)
+		return err
+	}); err != nil {
 		return err
 	}
-	if p.ServerStatus, err = GetServerStatus(client); err != nil {
+	if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+		p.ServerStatus, err = GetServerStatusWithContext(ctx, client)
+		return err
+	}); err != nil {
 		return err
 	}
 	p.Host = p.ServerStatus.Host
 	p.Process = p.ServerStatus.Process
 	p.Cluster = GetClusterType(p.ServerStatus)
 	if p.Cluster == Replica && p.Process == "mongod" { //collects replica info
-		if p.OplogStats, err = GetOplogStats(client); err != nil {
+		if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+			p.OplogStats, err = GetOplogStatsWithContext(ctx, client)
+			return err
+		}); err != nil {
 			return err
 		}
-		if p.ReplSetGetStatus, err = GetReplSetGetStatus(client); err != nil {
+		if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+			p.ReplSetGetStatus, err = GetReplSetGetStatusWithContext(ctx, client)
+			return err
+		}); err != nil {
 			return err
 		}
 	} else if p.Cluster == Sharded {
-		if p.Shards, err = GetShards(client); err != nil {
+		if err = p.runWithTimeout(ctx, func(ctx context.Context) (err error) {
+			p.Shards, err = GetShardsWithContext(ctx, client)
+			return err
+		}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// runWithTimeout runs a command within the timeout
+func (p *ClusterStats) runWithTimeout(ctx context.Context, command func(ctx context.Context) error) error {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	return command(ctx)
+}
+
 // GetServersStatsSummary returns cluster stats from all shards
 func (p *ClusterStats) GetServersStatsSummary(shards []Shard, connString connstring.ConnString) ([]Shard, error) {
+	return p.GetServersStatsSummaryWithContext(context.Background(), shards, connString)
+}
+
+// GetServersStatsSummaryWithContext returns cluster stats from all shards, servers unreachable or
+// not collected before the context is canceled are recorded as incomplete
+func (p *ClusterStats) GetServersStatsSummaryWithContext(ctx context.Context, shards []Shard, connString connstring.ConnString) ([]Shard, error) {
 	var err error
 	var uris []string
 	var smap = map[string]Shard{}
@@ -184,35 +280,53 @@ func (p *ClusterStats) GetServersStatsSummary(shards []Shard, connString connstr
 	wg := gox.NewWaitGroup(6)
 	var mu sync.Mutex
 	var e error
+	addServerStatus := func(host string, err error) {
+		status := NamespaceFailed
+		if ctx.Err() != nil {
+			status = NamespaceSkipped
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		p.Incomplete = append(p.Incomplete, NamespaceStatus{Error: err.Error(), Namespace: host, Status: status})
+		e = err
+	}
 	for i, uri := range uris {
 		s := uri
 		cs, _ := connstring.Parse(s)
 		if cs.Password != "" {
 			s = strings.Replace(s, url.QueryEscape(cs.Password), "xxxxxx", 1)
 		}
+		host := strings.Join(cs.Hosts, ",")
 		p.Logger.Infof(`[t-%d] collect from %v`, i, s)
 		wg.Add(1)
 		go func(uri string, n int, logger *gox.Logger) {
 			defer wg.Done()
 			var sclient *mongo.Client
 			var err error
+			if ctx.Err() != nil {
+				addServerStatus(host, ctx.Err())
+				return
+			}
 			if sclient, err = NewMongoClient(uri); err != nil {
 				logger.Errorf(`[t-%d] error: %v`, n, err)
-				mu.Lock()
-				e = err
-				mu.Unlock()
+				addServerStatus(host, err)
 				return
 			}
+			defer sclient.Disconnect(context.Background())
 			tm := time.Now()
-			sclient.Ping(context.Background(), nil)
+			if err = p.runWithTimeout(ctx, func(ctx context.Context) error {
+				return sclient.Ping(ctx, nil)
+			}); err != nil {
+				logger.Errorf(`[t-%d] error: %v`, n, err)
+				addServerStatus(host, err)
+				return
+			}
 			logger.Infof(`[t-%d] ping: %v`, n, time.Since(tm))
-			defer sclient.Disconnect(context.Background())
 			server := NewClusterStats(p.Logger.AppName)
-			if err = server.GetClusterStatsSummary(sclient); err != nil {
+			server.SetTimeout(p.timeout)
+			if err = server.GetClusterStatsSummaryWithContext(ctx, sclient); err != nil {
 				logger.Errorf(`[t-%d] error: %v`, n, err)
-				mu.Lock()
-				e = err
-				mu.Unlock()
+				addServerStatus(host, err)
 				return
 			}
 			mu.Lock()
@@ -224,14 +338,11 @@ func (p *ClusterStats) GetServersStatsSummary(shards []Shard, connString connstr
 		}(uri, i, p.Logger)
 	}
 	wg.Wait()
-	if e != nil {
-		return shards, e
-	}
 	shards = []Shard{}
 	for _, v := range smap {
 		shards = append(shards, v)
 	}
-	return shards, nil
+	return shards, e
 }
 
 // GetClusterShortSummary returns one line summary
@@ -259,9 +370,21 @@ func (p *ClusterStats) GetShortSummary() string {
 	return result
 }
 
-// Print prints a cluster short summary
+// Print prints a cluster short summary and findings of health check
 func (p *ClusterStats) Print() {
 	fmt.Println(p.GetShortSummary())
+	if p.Findings != nil {
+		fmt.Print(GetFindingsSummary(p.Findings))
+	}
+	if p.Interrupted {
+		fmt.Println("Collection interrupted, stats are partial")
+	}
+	if len(p.Incomplete) > 0 {
+		fmt.Printf("%d namespaces or servers skipped or failed:\n", len(p.Incomplete))
+		for _, ns := range p.Incomplete {
+			fmt.Printf("  [%v] %v: %v\n", ns.Status, ns.Namespace, ns.Error)
+		}
+	}
 }
 
 // OutputBSON writes bson data to a file
@@ -349,3 +472,50 @@ func (p *ClusterStats) OutputBSON() (string, []byte, error) {
 
 	return ofile, buffer.Bytes(), err
 }
+
+// ReadClusterStatsFile reads a -stats.bson.gz file of OutputBSON, a ClusterStats document followed
+// by a document of each collection, and attaches collections to their databases
+func ReadClusterStatsFile(filename string) (ClusterStats, error) {
+	var err error
+	var data []byte
+	var stats ClusterStats
+	var reader *bufio.Reader
+	if reader, err = gox.NewFileReader(filename); err != nil {
+		return stats, err
+	}
+	if data, err = io.ReadAll(reader); err != nil {
+		return stats, err
+	}
+	docs := []bson.Raw{}
+	for len(data) >= 4 {
+		size := int(binary.LittleEndian.Uint32(data[:4]))
+		if size < 5 || size > len(data) {
+			return stats, errors.New("invalid bson document")
+		}
+		docs = append(docs, bson.Raw(data[:size]))
+		data = data[size:]
+	}
+	if len(docs) == 0 {
+		return stats, errors.New("no cluster stats found")
+	}
+	if err = bson.Unmarshal(docs[0], &stats); err != nil {
+		return stats, err
+	}
+	if stats.Databases == nil {
+		stats.Databases = &[]Database{}
+	}
+	databases := *stats.Databases
+	for _, doc := range docs[1:] {
+		var coll Collection
+		if err = bson.Unmarshal(doc, &coll); err != nil {
+			return stats, err
+		}
+		dbName, _ := SplitNamespace(coll.NS)
+		for i := range databases {
+			if databases[i].Name == dbName {
+				databases[i].Collections = append(databases[i].Collections, coll)
+			}
+		}
+	}
+	return stats, nil
+}
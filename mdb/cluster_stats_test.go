@@ -3,6 +3,7 @@
 package mdb
 
 import (
+	"context"
 	"log"
 	"os"
 	"testing"
@@ -41,3 +42,20 @@ func TestOutputBSON(t *testing.T) {
 		log.Fatalf("failed to output bson file\n%v", err)
 	}
 }
+
+func TestGetServersStatsSummaryCanceled(t *testing.T) {
+	connString, err := ParseURI("mongodb://localhost:1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stats := NewClusterStats("utest-xxxxxx")
+	shards := []Shard{{ID: "rs0", State: 1, Host: "rs0/localhost:1,localhost:2"}}
+	if shards, err = stats.GetServersStatsSummaryWithContext(ctx, shards, connString); err == nil {
+		t.Fatal("expected error of canceled context")
+	}
+	if len(shards) != 1 || len(stats.Incomplete) != 2 || stats.Incomplete[0].Status != NamespaceSkipped {
+		t.Fatal("expected servers skipped", shards, stats.Incomplete)
+	}
+}
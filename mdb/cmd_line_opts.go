@@ -17,7 +17,11 @@ type CmdLineOpts struct {
 
 // GetCmdLineOpts returns MongoDB build information
 func GetCmdLineOpts(client *mongo.Client) (CmdLineOpts, error) {
-	ctx := context.Background()
+	return GetCmdLineOptsWithContext(context.Background(), client)
+}
+
+// GetCmdLineOptsWithContext returns command line options within a context
+func GetCmdLineOptsWithContext(ctx context.Context, client *mongo.Client) (CmdLineOpts, error) {
 	var cmdLineOpts CmdLineOpts
 	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "getCmdLineOpts", Value: 1}}).Decode(&cmdLineOpts)
 	return cmdLineOpts, err
@@ -0,0 +1,188 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collStatsPipelines get storage, latency with histograms, query exec stats (4.4+), and count of a
+// collection, the latter is for 3.6 to 4.2
+var collStatsPipelines = []string{
+	`{"$collStats": {"storageStats": {}, "latencyStats": {"histograms": true}, "queryExecStats": {}, "count": {}}}`,
+	`{"$collStats": {"storageStats": {}, "latencyStats": {"histograms": true}}}`,
+}
+
+// LatencyStats stores latencyStats of $collStats
+type LatencyStats struct {
+	Commands     OpLatency `bson:"commands"`
+	Reads        OpLatency `bson:"reads"`
+	Transactions OpLatency `bson:"transactions"`
+	Writes       OpLatency `bson:"writes"`
+}
+
+// OpLatency stores latency in microseconds and ops of an operation type
+type OpLatency struct {
+	Histogram []LatencyBucket `bson:"histogram,omitempty"`
+	Latency   int64           `bson:"latency,truncate"`
+	Ops       int64           `bson:"ops,truncate"`
+}
+
+// LatencyBucket stores count of ops of latency from micros to the next bucket
+type LatencyBucket struct {
+	Count  int64 `bson:"count,truncate"`
+	Micros int64 `bson:"micros,truncate"`
+}
+
+// QueryExecStats stores queryExecStats of $collStats
+type QueryExecStats struct {
+	CollectionScans struct {
+		NonTailable int64 `bson:"nonTailable,truncate"`
+		Total       int64 `bson:"total,truncate"`
+	} `bson:"collectionScans"`
+}
+
+// ShardCollStats stores $collStats of a collection of a shard
+type ShardCollStats struct {
+	Count          int64          `bson:"count,truncate"`
+	Host           string         `bson:"host"`
+	LatencyStats   LatencyStats   `bson:"latencyStats"`
+	QueryExecStats QueryExecStats `bson:"queryExecStats"`
+	Shard          string         `bson:"shard"`
+	Size           int64          `bson:"size,truncate"`
+	StorageSize    int64          `bson:"storageSize,truncate"`
+	TotalIndexSize int64          `bson:"totalIndexSize,truncate"`
+}
+
+// add sums latency and histograms of another
+func (o *OpLatency) add(other OpLatency) {
+	o.Latency += other.Latency
+	o.Ops += other.Ops
+	for _, b := range other.Histogram {
+		found := false
+		for i := range o.Histogram {
+			if o.Histogram[i].Micros == b.Micros {
+				o.Histogram[i].Count += b.Count
+				found = true
+			}
+		}
+		if !found {
+			o.Histogram = append(o.Histogram, b)
+		}
+	}
+	sort.Slice(o.Histogram, func(i, j int) bool { return o.Histogram[i].Micros < o.Histogram[j].Micros })
+}
+
+// add sums latency stats of another
+func (ls *LatencyStats) add(other LatencyStats) {
+	ls.Commands.add(other.Commands)
+	ls.Reads.add(other.Reads)
+	ls.Transactions.add(other.Transactions)
+	ls.Writes.add(other.Writes)
+}
+
+// GetCollStats returns stats of a collection in the format of the collStats command from the
+// $collStats stage, or from the deprecated collStats command of servers before 3.6
+func GetCollStats(ctx context.Context, client *mongo.Client, dbName string, collName string) (bson.M, error) {
+	var err error
+	var cur *mongo.Cursor
+	collection := client.Database(dbName).Collection(collName)
+	for _, pipeline := range collStatsPipelines {
+		if cur, err = collection.Aggregate(ctx, MongoPipeline(pipeline)); err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		var stats bson.M // fallback
+		err = client.Database(dbName).RunCommand(ctx, bson.D{{Key: "collStats", Value: collName}}).Decode(&stats)
+		return stats, err
+	}
+	defer cur.Close(ctx)
+	docs := []bson.M{}
+	if err = cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	stats := getCollStatsOf(docs)
+	if stats["shards"] != nil { // on mongos
+		ns := dbName + "." + collName
+		filter := bson.D{{Key: "_id", Value: ns}, {Key: "dropped", Value: bson.M{"$ne": true}}}
+		n, _ := client.Database("config").Collection("collections").CountDocuments(ctx, filter)
+		stats["sharded"] = n > 0
+	}
+	return stats, nil
+}
+
+// getCollStatsOf merges $collStats documents of shards into the format of the collStats command,
+// storage stats of each shard are under shards and latency and query exec stats are summed
+func getCollStatsOf(docs []bson.M) bson.M {
+	sort.Slice(docs, func(i, j int) bool { return fmt.Sprint(docs[i]["shard"]) < fmt.Sprint(docs[j]["shard"]) })
+	stats := bson.M{}
+	shards := bson.M{}
+	shardStats := []ShardCollStats{}
+	indexSizes := bson.M{}
+	var latency LatencyStats
+	var queryExec QueryExecStats
+	var count, size, storageSize, totalIndexSize int64
+	for _, doc := range docs {
+		storage, _ := doc["storageStats"].(bson.M)
+		if storage == nil {
+			storage = bson.M{}
+		}
+		var s ShardCollStats
+		if data, err := bson.Marshal(doc); err == nil {
+			bson.Unmarshal(data, &s)
+		}
+		if data, err := bson.Marshal(storage); err == nil {
+			bson.Unmarshal(data, &s)
+		}
+		if doc["count"] != nil { // count of metadata
+			s.Count = toInt64(doc["count"])
+			storage["count"] = s.Count
+		}
+		latency.add(s.LatencyStats)
+		queryExec.CollectionScans.NonTailable += s.QueryExecStats.CollectionScans.NonTailable
+		queryExec.CollectionScans.Total += s.QueryExecStats.CollectionScans.Total
+		count += s.Count
+		size += s.Size
+		storageSize += s.StorageSize
+		totalIndexSize += s.TotalIndexSize
+		if m, ok := storage["indexSizes"].(bson.M); ok {
+			for k, v := range m {
+				indexSizes[k] = toInt64(indexSizes[k]) + toInt64(v)
+			}
+		}
+		if len(stats) == 0 { // keeps fields of the first, e.g. capped and wiredTiger
+			for k, v := range storage {
+				stats[k] = v
+			}
+		}
+		if shard, ok := doc["shard"].(string); ok {
+			storage["latencyStats"], storage["queryExecStats"] = doc["latencyStats"], doc["queryExecStats"]
+			shards[shard] = storage
+			shardStats = append(shardStats, s)
+		}
+	}
+	if len(docs) > 0 {
+		stats["ns"] = docs[0]["ns"]
+	}
+	stats["count"], stats["size"], stats["storageSize"] = count, size, storageSize
+	stats["totalIndexSize"], stats["indexSizes"] = totalIndexSize, indexSizes
+	if count > 0 {
+		stats["avgObjSize"] = float64(size) / float64(count)
+	}
+	stats["latencyStats"], stats["queryExecStats"] = latency, queryExec
+	if len(shards) > 0 {
+		delete(stats, "indexDetails") // per shard
+		delete(stats, "wiredTiger")
+		stats["shards"], stats["shardStats"], stats["sharded"] = shards, shardStats, false
+	}
+	return stats
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetCollStatsOf(t *testing.T) {
+	str := `[
+		{"ns": "keyhole.cars", "shard": "shard1", "host": "host2:27017", "count": 300,
+			"storageStats": {"count": 300, "size": 3000, "storageSize": 4096, "totalIndexSize": 2048,
+				"indexSizes": {"_id_": 1024, "color_1": 1024}, "capped": false, "nindexes": 2,
+				"indexDetails": {"_id_": {}}, "wiredTiger": {}},
+			"latencyStats": {"reads": {"latency": 5000, "ops": 10, "histogram": [{"micros": 256, "count": 6}, {"micros": 1024, "count": 4}]},
+				"writes": {"latency": 2000, "ops": 2}, "commands": {"latency": 0, "ops": 0}, "transactions": {"latency": 0, "ops": 0}},
+			"queryExecStats": {"collectionScans": {"total": 3, "nonTailable": 3}}},
+		{"ns": "keyhole.cars", "shard": "shard0", "host": "host1:27017", "count": 100,
+			"storageStats": {"count": 100, "size": 1000, "storageSize": 4096, "totalIndexSize": 2048,
+				"indexSizes": {"_id_": 1024, "color_1": 1024}, "capped": false, "nindexes": 2},
+			"latencyStats": {"reads": {"latency": 1000, "ops": 5, "histogram": [{"micros": 256, "count": 5}]},
+				"writes": {"latency": 0, "ops": 0}, "commands": {"latency": 0, "ops": 0}, "transactions": {"latency": 0, "ops": 0}},
+			"queryExecStats": {"collectionScans": {"total": 1, "nonTailable": 1}}}]`
+	var doc struct {
+		Docs []bson.M `bson:"docs"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(`{"docs": `+str+`}`), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	stats := getCollStatsOf(doc.Docs)
+	var coll Collection
+	data, _ := bson.Marshal(stats)
+	if err := bson.Unmarshal(data, &coll.Stats); err != nil {
+		t.Fatal(err)
+	}
+	if coll.Stats.Count != 400 || coll.Stats.Size != 4000 || coll.Stats.StorageSize != 8192 ||
+		coll.Stats.AvgObjSize != 10 || toInt64(coll.Stats.IndexSizes["color_1"]) != 2048 {
+		t.Fatal("unexpected storage stats", coll.Stats)
+	}
+	reads := coll.Stats.LatencyStats.Reads
+	if reads.Ops != 15 || reads.Latency != 6000 || len(reads.Histogram) != 2 || reads.Histogram[0].Count != 11 ||
+		coll.Stats.QueryExecStats.CollectionScans.Total != 4 {
+		t.Fatal("unexpected latency or query exec stats", coll.Stats.LatencyStats, coll.Stats.QueryExecStats)
+	}
+	if len(coll.Stats.ShardStats) != 2 || coll.Stats.ShardStats[0].Shard != "shard0" ||
+		coll.Stats.ShardStats[1].LatencyStats.Reads.Ops != 10 || coll.Stats.IndexDetails != nil {
+		t.Fatal("unexpected shard stats", coll.Stats.ShardStats)
+	}
+	shard, ok := coll.Stats.Shards["shard1"].(bson.M)
+	if !ok || toInt64(shard["count"]) != 300 || toInt64(shard["size"]) != 3000 || shard["indexDetails"] == nil {
+		t.Fatal("unexpected shards", coll.Stats.Shards)
+	}
+}
+
+func TestGetCollStatsOfReplica(t *testing.T) {
+	str := `{"docs": [{"ns": "local.oplog.rs", "count": 50,
+		"storageStats": {"count": 50, "size": 5000, "maxSize": 1048576, "capped": true, "storageSize": 8192},
+		"latencyStats": {"reads": {"latency": 0, "ops": 0}, "writes": {"latency": 0, "ops": 0},
+			"commands": {"latency": 0, "ops": 0}, "transactions": {"latency": 0, "ops": 0}}}]}`
+	var doc struct {
+		Docs []bson.M `bson:"docs"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(str), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	stats := getCollStatsOf(doc.Docs)
+	var oplog OplogStats
+	data, _ := bson.Marshal(stats)
+	if err := bson.Unmarshal(data, &oplog); err != nil {
+		t.Fatal(err)
+	}
+	if oplog.Count != 50 || oplog.Size != 5000 || oplog.MaxSize != 1048576 || stats["shards"] != nil {
+		t.Fatal("unexpected oplog stats", oplog, stats)
+	}
+}
@@ -4,6 +4,7 @@ package mdb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -24,14 +25,32 @@ const (
 
 // DatabaseStats stores struct
 type DatabaseStats struct {
-	Logger *gox.Logger
-
-	fastMode  bool
-	numShards int
-	redaction bool
-	threads   int
-	verbose   bool
-	version   string
+	Logger            *gox.Logger
+	NamespaceStatuses []NamespaceStatus // namespaces skipped or failed
+
+	collWorkers int
+	dbWorkers   int
+	fastMode    bool
+	mutex       sync.Mutex
+	numShards   int
+	redaction   bool
+	threads     int
+	timeout     time.Duration
+	verbose     bool
+	version     string
+}
+
+// status of a namespace not fully collected
+const (
+	NamespaceFailed  = "failed"  // collected partially of failed or timed out commands
+	NamespaceSkipped = "skipped" // not collected because of cancellation
+)
+
+// NamespaceStatus stores a namespace skipped or failed
+type NamespaceStatus struct {
+	Error     string `bson:"error"`
+	Namespace string `bson:"ns"`
+	Status    string `bson:"status"`
 }
 
 // ListDatabases stores listDatabases
@@ -59,6 +78,8 @@ type Database struct {
 		DataSize    int64  `bson:"dataSize,truncate"`
 		StorageSize int64  `bson:"storageSize,truncate"`
 		NumExtents  int64  `bson:"numExtents,truncate"`
+		FsTotalSize int64  `bson:"fsTotalSize,truncate"`
+		FsUsedSize  int64  `bson:"fsUsedSize,truncate"`
 	}
 }
 
@@ -69,7 +90,11 @@ type Collection struct {
 	Indexes  []Index `bson:"indexes,truncate"`
 	Name     string  `bson:"name,truncate"`
 	NS       string  `bson:"namespace,truncate"`
-	Stats    struct {
+
+	Options   bson.D     `bson:"options,truncate,omitempty"` // options of listCollections, e.g. timeseries
+	PlanCache *PlanCache `bson:"planCache,truncate,omitempty"`
+	Type      string     `bson:"type,truncate,omitempty"`
+	Stats     struct {
 		Count          int64   `bson:"count,truncate"`
 		IndexDetails   bson.M  `bson:"indexDetails,truncate"`
 		AvgObjSize     float64 `bson:"avgObjSize,truncate"`
@@ -85,6 +110,10 @@ type Collection struct {
 		Sharded        bool    `bson:"sharded,truncate"`
 		Size           int64   `bson:"size,truncate"`
 		StorageSize    int64   `bson:"storageSize,truncate"`
+
+		LatencyStats   LatencyStats     `bson:"latencyStats,truncate,omitempty"` // summed of shards
+		QueryExecStats QueryExecStats   `bson:"queryExecStats,truncate,omitempty"`
+		ShardStats     []ShardCollStats `bson:"shardStats,truncate,omitempty"`
 	} `bson:"stats"`
 }
 
@@ -100,7 +129,21 @@ type Chunk struct {
 
 // NewDatabaseStats returns DatabaseStats
 func NewDatabaseStats(version string) *DatabaseStats {
-	return &DatabaseStats{Logger: gox.GetLogger(version), threads: 16, version: version}
+	return &DatabaseStats{Logger: gox.GetLogger(version), collWorkers: 4, dbWorkers: 1, threads: 16, version: version}
+}
+
+// SetCollectionWorkers sets # of collections of a database collected in parallel
+func (p *DatabaseStats) SetCollectionWorkers(n int) {
+	if n > 0 {
+		p.collWorkers = n
+	}
+}
+
+// SetDatabaseWorkers sets # of databases collected in parallel
+func (p *DatabaseStats) SetDatabaseWorkers(n int) {
+	if n > 0 {
+		p.dbWorkers = n
+	}
 }
 
 // SetFastMode sets fastMode mode
@@ -123,6 +166,11 @@ func (p *DatabaseStats) SetRedaction(redaction bool) {
 	p.redaction = redaction
 }
 
+// SetTimeout sets timeout of a command, 0 for no timeout
+func (p *DatabaseStats) SetTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
 // SetVerbose sets verbosity
 func (p *DatabaseStats) SetVerbose(verbose bool) {
 	p.verbose = verbose
@@ -133,15 +181,26 @@ func (p *DatabaseStats) SetVerbose(verbose bool) {
 
 // GetAllDatabasesStats gets all db info
 func (p *DatabaseStats) GetAllDatabasesStats(client *mongo.Client, dbNames []string) ([]Database, error) {
+	return p.GetAllDatabasesStatsWithContext(context.Background(), client, dbNames)
+}
+
+// GetAllDatabasesStatsWithContext gets all db info, namespaces not collected before the context is
+// canceled are skipped and stats collected are returned, an error is returned if listDatabases fails
+func (p *DatabaseStats) GetAllDatabasesStatsWithContext(ctx context.Context, client *mongo.Client, dbNames []string) ([]Database, error) {
 	var err error
-	var cur *mongo.Cursor
-	var ctx = context.Background()
 	var listdb ListDatabases
 	var databases []Database
 	t := time.Now()
 	p.Logger.Debug("GetAllDatabasesStats")
-	if err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "listDatabases", Value: 1}}).Decode(&listdb); err != nil {
-		return listdb.Databases, nil
+	cctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	if err = client.Database("admin").RunCommand(cctx, bson.D{{Key: "listDatabases", Value: 1}}).Decode(&listdb); err != nil {
+		status := NamespaceFailed
+		if ctx.Err() != nil {
+			status = NamespaceSkipped
+		}
+		p.addNamespaceStatus("admin", status, fmt.Errorf("listDatabases: %v", err))
+		return listdb.Databases, err
 	}
 	if len(dbNames) > 0 {
 		dict := map[string]bool{}
@@ -159,142 +218,228 @@ func (p *DatabaseStats) GetAllDatabasesStats(client *mongo.Client, dbNames []str
 	sort.Slice(listdb.Databases, func(i, j int) bool {
 		return listdb.Databases[i].Name < listdb.Databases[j].Name
 	})
-	for _, db := range listdb.Databases {
+	results := make([]*Database, len(listdb.Databases))
+	var wg = gox.NewWaitGroup(p.dbWorkers) // databases in parallel
+	for i, db := range listdb.Databases {
 		if db.Name == "admin" || db.Name == "config" || db.Name == "local" {
 			continue
 		}
-		if cur, err = client.Database(db.Name).ListCollections(ctx, bson.D{{}}); err != nil {
-			return listdb.Databases, err
+		if ctx.Err() != nil {
+			p.addNamespaceStatus(db.Name, NamespaceSkipped, ctx.Err())
+			continue
 		}
-		var collections = []Collection{}
-		ir := NewIndexStats(p.version)
-		ir.SetVerbose(p.verbose)
-		ir.SetFastMode(p.fastMode)
-		collectionNames := []string{}
-
-		for cur.Next(ctx) {
-			var elem = bson.M{}
-			if err = cur.Decode(&elem); err != nil {
-				continue
-			}
-			coll := fmt.Sprintf("%v", elem["name"])
-			collType := fmt.Sprintf("%v", elem["type"])
-			if collType != "timeseries" && collType != "collection" {
-				p.Logger.Debugf(`skip %v %v`, collType, coll)
-				continue
-			}
-			collectionNames = append(collectionNames, coll)
+		wg.Add(1)
+		go func(i int, db Database) {
+			defer wg.Done()
+			results[i] = p.getDatabaseStats(ctx, client, db)
+		}(i, db)
+	}
+	wg.Wait()
+	for _, db := range results {
+		if db != nil {
+			databases = append(databases, *db)
 		}
-		cur.Close(ctx)
+	}
+	sort.Slice(p.NamespaceStatuses, func(i, j int) bool {
+		return p.NamespaceStatuses[i].Namespace < p.NamespaceStatuses[j].Namespace
+	})
+	p.Logger.Debugf("GetAllDatabasesStats took %v", time.Since(t))
+	return databases, nil
+}
 
-		sort.Strings(collectionNames)
-		var wg = gox.NewWaitGroup(4) // runs in parallel
-		var mu sync.Mutex
-		for _, collectionName := range collectionNames {
-			wg.Add(1)
-			go func(client *mongo.Client, collectionName string) {
-				defer wg.Done()
-				ns := db.Name + "." + collectionName
-				p.Logger.Debugf(`collecting from %v`, ns)
-				collection := client.Database(db.Name).Collection(collectionName)
-
-				var cursor *mongo.Cursor
-				var sampleDoc bson.M
-				opts := options.Find()
-				opts.SetLimit(5) // get 5 samples and choose the max_size()
-				opts.SetHint(bson.D{{Key: "$natural", Value: 1}})
-
-				if !strings.HasPrefix(collectionName, "system.") {
-					if cursor, err = collection.Find(ctx, bson.D{{}}, opts); err != nil {
-						p.Logger.Error(err.Error())
-						return
-					}
+// getDatabaseStats returns stats of a database and its collections, nil if skipped
+func (p *DatabaseStats) getDatabaseStats(ctx context.Context, client *mongo.Client, db Database) *Database {
+	var err error
+	var cur *mongo.Cursor
+	cctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	if cur, err = client.Database(db.Name).ListCollections(cctx, bson.D{{}}); err != nil {
+		p.Logger.Errorf(`db %v error %v`, db.Name, err)
+		p.addNamespaceStatus(db.Name, NamespaceFailed, fmt.Errorf("listCollections: %v", err))
+		return nil
+	}
+	var collections = []Collection{}
+	ir := NewIndexStats(p.version)
+	ir.SetVerbose(p.verbose)
+	ir.SetFastMode(p.fastMode)
+	collectionNames := []string{}
+	collectionTypes := map[string]collectionInfo{}
 
-					dsize := 0
-					for cursor.Next(ctx) {
-						var v bson.M
-						cursor.Decode(&v)
-						if buf, err := bson.Marshal(v); err != nil {
-							p.Logger.Error(err.Error())
-							continue
-						} else if len(buf) > dsize && len(buf) < sampleDocSizeLimit {
-							sampleDoc = v
-							dsize = len(buf)
-						} else if len(buf) > sampleDocSizeLimit {
-							sampleDoc = bson.M{"warning": "sample doc collecting skipped because doc size exceeds 32KB"}
-							dsize = len(buf)
-						}
-					}
+	for cur.Next(cctx) {
+		var elem collectionInfo
+		if err = cur.Decode(&elem); err != nil {
+			continue
+		}
+		if elem.Type != "timeseries" && elem.Type != "collection" {
+			p.Logger.Debugf(`skip %v %v`, elem.Type, elem.Name)
+			continue
+		}
+		collectionNames = append(collectionNames, elem.Name)
+		collectionTypes[elem.Name] = elem
+	}
+	cur.Close(cctx)
 
-					if sampleDoc == nil {
-						p.Logger.Debug("no sample doc available")
-					}
-				} else {
-					p.Logger.Debug("skip ", collectionName)
+	sort.Strings(collectionNames)
+	var wg = gox.NewWaitGroup(p.collWorkers) // runs in parallel
+	var mu sync.Mutex
+	for _, collectionName := range collectionNames {
+		ns := db.Name + "." + collectionName
+		if ctx.Err() != nil {
+			p.addNamespaceStatus(ns, NamespaceSkipped, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(client *mongo.Client, collectionName string) {
+			defer wg.Done()
+			if ctx.Err() != nil { // canceled while waiting
+				p.addNamespaceStatus(ns, NamespaceSkipped, ctx.Err())
+				return
+			}
+			p.Logger.Debugf(`collecting from %v`, ns)
+			collection := client.Database(db.Name).Collection(collectionName)
+			errs := []string{}
+
+			var sampleDoc bson.M
+			if !strings.HasPrefix(collectionName, "system.") {
+				var err error
+				if sampleDoc, err = p.getSampleDoc(ctx, collection); err != nil {
+					p.Logger.Errorf(`ns %v error %v`, ns, err)
+					errs = append(errs, fmt.Sprintf("find: %v", err))
+				}
+				if sampleDoc == nil {
+					p.Logger.Debug("no sample doc available")
 				}
-				if p.redaction {
-					redact := NewRedactor()
-					walker := gox.NewMapWalker(redact.callback)
-					buf, _ := bson.Marshal(walker.Walk(sampleDoc))
-					bson.Unmarshal(buf, &sampleDoc)
+			} else {
+				p.Logger.Debug("skip ", collectionName)
+			}
+			if p.redaction {
+				redact := NewRedactor()
+				walker := gox.NewMapWalker(redact.callback)
+				buf, _ := bson.Marshal(walker.Walk(sampleDoc))
+				bson.Unmarshal(buf, &sampleDoc)
+			}
+			cctx, cancel := p.withTimeout(ctx)
+			indexes, err := ir.GetIndexesFromCollectionWithContext(cctx, client, collection)
+			cancel()
+			if err != nil {
+				p.Logger.Error(err)
+				errs = append(errs, fmt.Sprintf("listIndexes: %v", err))
+			}
+			var stats bson.M
+			var planCache *PlanCache
+			chunks := []Chunk{}
+			if !p.fastMode {
+				cctx, cancel := p.withTimeout(ctx)
+				if planCache, err = ir.GetPlanCacheFromCollectionWithContext(cctx, collection); err != nil {
+					p.Logger.Debugf(`ns %v plan cache error %v`, ns, err)
 				}
-				indexes, err := ir.GetIndexesFromCollection(client, collection)
+				cancel()
+				// stats
+				cctx, cancel = p.withTimeout(ctx)
+				stats, err = GetCollStats(cctx, client, db.Name, collectionName)
+				cancel()
 				if err != nil {
-					p.Logger.Error(err)
+					p.Logger.Errorf(`ns %v error %v`, ns, err)
+					errs = append(errs, fmt.Sprintf("collStats: %v", err))
 				}
-				var stats bson.M
-				chunks := []Chunk{}
-				if !p.fastMode {
-					// stats
-					client.Database(db.Name).RunCommand(ctx, bson.D{{Key: "collStats", Value: collectionName}}).Decode(&stats)
-					if stats["shards"] != nil {
-						shardNames := []string{}
-						for shard := range stats["shards"].(primitive.M) {
-							shardNames = append(shardNames, shard)
-						}
-						sort.Strings(shardNames)
-						for _, k := range shardNames {
-							m := (stats["shards"].(primitive.M)[k]).(primitive.M)
-							delete(m, "$clusterTime")
-							delete(m, "$gleStats")
-							if chunk, cerr := p.collectChunksDistribution(client, k, ns); cerr != nil {
-								p.Logger.Errorf(`ns %v error %v`, ns, cerr)
-							} else {
-								chunk.Objects = toInt64(m["count"])
-								chunk.Size = toInt64(m["size"])
-								chunks = append(chunks, chunk)
-							}
+				if stats["shards"] != nil {
+					shardNames := []string{}
+					for shard := range stats["shards"].(primitive.M) {
+						shardNames = append(shardNames, shard)
+					}
+					sort.Strings(shardNames)
+					for _, k := range shardNames {
+						m := (stats["shards"].(primitive.M)[k]).(primitive.M)
+						delete(m, "$clusterTime")
+						delete(m, "$gleStats")
+						if chunk, cerr := p.collectChunksDistribution(ctx, client, k, ns); cerr != nil {
+							p.Logger.Errorf(`ns %v error %v`, ns, cerr)
+							errs = append(errs, fmt.Sprintf("chunks of %v: %v", k, cerr))
+						} else {
+							chunk.Objects = toInt64(m["count"])
+							chunk.Size = toInt64(m["size"])
+							chunks = append(chunks, chunk)
 						}
 					}
 				}
-				mu.Lock()
-				collstats := Collection{NS: ns, Name: collectionName, Chunks: chunks, Document: sampleDoc,
-					Indexes: indexes}
-				data, _ := bson.Marshal(stats)
-				bson.Unmarshal(data, &collstats.Stats)
-				collections = append(collections, collstats)
-				mu.Unlock()
-			}(client, collectionName)
-		}
-		wg.Wait()
-		sort.Slice(collections, func(i, j int) bool {
-			return collections[i].Name < collections[j].Name
-		})
-		if err = client.Database(db.Name).RunCommand(ctx, bson.D{{Key: "dbStats", Value: 1}}).Decode(&db.Stats); err != nil {
+			}
+			if len(errs) > 0 {
+				p.addNamespaceStatus(ns, NamespaceFailed, errors.New(strings.Join(errs, "; ")))
+			}
+			mu.Lock()
+			collstats := Collection{NS: ns, Name: collectionName, Chunks: chunks, Document: sampleDoc,
+				Indexes: indexes, Options: collectionTypes[collectionName].Options, PlanCache: planCache,
+				Type: collectionTypes[collectionName].Type}
+			data, _ := bson.Marshal(stats)
+			bson.Unmarshal(data, &collstats.Stats)
+			collections = append(collections, collstats)
+			mu.Unlock()
+		}(client, collectionName)
+	}
+	wg.Wait()
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+	cctx, cancel = p.withTimeout(ctx)
+	defer cancel()
+	if err = client.Database(db.Name).RunCommand(cctx, bson.D{{Key: "dbStats", Value: 1}}).Decode(&db.Stats); err != nil {
+		p.Logger.Error(err.Error())
+		p.addNamespaceStatus(db.Name, NamespaceFailed, fmt.Errorf("dbStats: %v", err))
+	}
+	db.Collections = collections
+	return &db
+}
+
+// getSampleDoc returns the largest of 5 documents in natural order
+func (p *DatabaseStats) getSampleDoc(ctx context.Context, collection *mongo.Collection) (bson.M, error) {
+	var err error
+	var cursor *mongo.Cursor
+	var sampleDoc bson.M
+	cctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	opts := options.Find()
+	opts.SetLimit(5) // get 5 samples and choose the max_size()
+	opts.SetHint(bson.D{{Key: "$natural", Value: 1}})
+	if cursor, err = collection.Find(cctx, bson.D{{}}, opts); err != nil {
+		return nil, err
+	}
+	defer cursor.Close(cctx)
+	dsize := 0
+	for cursor.Next(cctx) {
+		var v bson.M
+		cursor.Decode(&v)
+		if buf, err := bson.Marshal(v); err != nil {
 			p.Logger.Error(err.Error())
 			continue
+		} else if len(buf) > dsize && len(buf) < sampleDocSizeLimit {
+			sampleDoc = v
+			dsize = len(buf)
+		} else if len(buf) > sampleDocSizeLimit {
+			sampleDoc = bson.M{"warning": "sample doc collecting skipped because doc size exceeds 32KB"}
+			dsize = len(buf)
 		}
-		db.Collections = collections
-		databases = append(databases, db)
 	}
-	p.Logger.Debugf("GetAllDatabasesStats took %v", time.Since(t))
-	return databases, nil
+	return sampleDoc, cursor.Err()
+}
+
+// withTimeout returns a context of the command timeout
+func (p *DatabaseStats) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.timeout)
+}
+
+// addNamespaceStatus records a namespace skipped or failed
+func (p *DatabaseStats) addNamespaceStatus(ns string, status string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.NamespaceStatuses = append(p.NamespaceStatuses, NamespaceStatus{Error: err.Error(), Namespace: ns, Status: status})
 }
 
-func (p *DatabaseStats) collectChunksDistribution(client *mongo.Client, shard string, ns string) (Chunk, error) {
+func (p *DatabaseStats) collectChunksDistribution(ctx context.Context, client *mongo.Client, shard string, ns string) (Chunk, error) {
 	var batchSize = 5
 	var count int64
-	var ctx = context.Background()
 	var cur *mongo.Cursor
 	var doc bson.D
 	var emptyCounts int64
@@ -303,8 +448,10 @@ func (p *DatabaseStats) collectChunksDistribution(client *mongo.Client, shard st
 	var key bson.D
 	var chunk Chunk
 	var mu sync.Mutex
+	cctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 	coll := client.Database("config").Collection("collections")
-	if err = coll.FindOne(ctx, bson.D{{Key: "_id", Value: ns}, {Key: "dropped", Value: bson.M{"$ne": true}}}).Decode(&doc); err != nil {
+	if err = coll.FindOne(cctx, bson.D{{Key: "_id", Value: ns}, {Key: "dropped", Value: bson.M{"$ne": true}}}).Decode(&doc); err != nil {
 		return chunk, nil
 	}
 	for _, v := range doc {
@@ -316,11 +463,11 @@ func (p *DatabaseStats) collectChunksDistribution(client *mongo.Client, shard st
 	coll = client.Database("config").Collection("chunks")
 	if p.verbose {
 		p.Logger.Info(fmt.Sprintf(`collectChunksDistribution on %v %v ...`, shard, ns))
-		if cur, err = coll.Find(ctx, bson.M{"ns": ns, "shard": shard}); err != nil {
+		if cur, err = coll.Find(cctx, bson.M{"ns": ns, "shard": shard}); err != nil {
 			return chunk, nil
 		}
 		chunks := []bson.M{}
-		for cur.Next(ctx) {
+		for cur.Next(cctx) {
 			var chunk bson.M
 			cur.Decode(&chunk)
 			chunks = append(chunks, chunk)
@@ -344,7 +491,9 @@ func (p *DatabaseStats) collectChunksDistribution(client *mongo.Client, shard st
 					cmd := bson.D{{Key: "datasize", Value: ns}, {Key: "keyPattern", Value: key},
 						{Key: "min", Value: chunk["min"]}, {Key: "max", Value: chunk["max"]},
 						{Key: "estimate", Value: true}}
-					client.Database("admin").RunCommand(ctx, cmd).Decode(&chunk)
+					dctx, dcancel := p.withTimeout(ctx)
+					client.Database("admin").RunCommand(dctx, cmd).Decode(&chunk)
+					dcancel()
 					if chunk["jumbo"] != nil && chunk["jumbo"].(bool) {
 						jcount++
 					}
@@ -370,10 +519,10 @@ func (p *DatabaseStats) collectChunksDistribution(client *mongo.Client, shard st
 		p.Logger.Info(msg)
 	} else {
 		emptyCounts = -1
-		if count, err = coll.CountDocuments(ctx, bson.M{"shard": shard, "ns": ns}); err != nil {
+		if count, err = coll.CountDocuments(cctx, bson.M{"shard": shard, "ns": ns}); err != nil {
 			return chunk, err
 		}
-		if jumboCounts, err = coll.CountDocuments(ctx, bson.M{"shard": shard, "ns": ns, "jumbo": true}); err != nil {
+		if jumboCounts, err = coll.CountDocuments(cctx, bson.M{"shard": shard, "ns": ns, "jumbo": true}); err != nil {
 			return chunk, err
 		}
 	}
@@ -0,0 +1,58 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestDatabaseStatsWithTimeout(t *testing.T) {
+	p := NewDatabaseStats("utest-xxxxxx")
+	ctx, cancel := p.withTimeout(context.Background())
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatal("expected no deadline without timeout")
+	}
+	cancel()
+	p.SetTimeout(time.Second)
+	ctx, cancel = p.withTimeout(context.Background())
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Second {
+		t.Fatal("expected deadline of timeout", deadline)
+	}
+
+	p.SetCollectionWorkers(0)
+	p.SetDatabaseWorkers(8)
+	if p.collWorkers != 4 || p.dbWorkers != 8 {
+		t.Fatal("unexpected workers", p.collWorkers, p.dbWorkers)
+	}
+	p.addNamespaceStatus("keyhole.cars", NamespaceFailed, errors.New("collStats: context deadline exceeded"))
+	if len(p.NamespaceStatuses) != 1 || p.NamespaceStatuses[0].Status != NamespaceFailed {
+		t.Fatal("unexpected statuses", p.NamespaceStatuses)
+	}
+}
+
+func TestGetAllDatabasesStatsCanceled(t *testing.T) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := NewDatabaseStats("utest-xxxxxx")
+	databases, err := p.GetAllDatabasesStatsWithContext(ctx, client, nil)
+	if err == nil || len(databases) != 0 {
+		t.Fatal("expected no databases with error", databases, err)
+	}
+	if len(p.NamespaceStatuses) != 1 || p.NamespaceStatuses[0].Status != NamespaceSkipped ||
+		!strings.HasPrefix(p.NamespaceStatuses[0].Error, "listDatabases") {
+		t.Fatal("expected listDatabases skipped", p.NamespaceStatuses)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/simagix/gox"
@@ -20,6 +22,35 @@ import (
 // Explain stores explain object info
 type Explain struct {
 	verbose bool
+	version string
+	whatIf  bool
+}
+
+// ExplainReport stores explain results of query shapes of a log file
+type ExplainReport struct {
+	Filename string          `json:"filename"`
+	Results  []ExplainResult `json:"results"`
+	Stdout   string          `json:"stdout"`
+}
+
+// ExplainResult stores explain result of a query shape
+type ExplainResult struct {
+	Cardinality      CardinalitySummary `json:"cardinality"`
+	Command          string             `json:"command"`
+	Count            int                `json:"count"`
+	Error            string             `json:"error,omitempty"`
+	Explain          ExplainSummary     `json:"explain"`
+	Namespace        string             `json:"ns"`
+	QueryHash        string             `json:"queryHash"`
+	QueryShape       string             `json:"queryShape"`
+	RecommendedIndex *gox.OrderedMap    `json:"recommendedIndex,omitempty"`
+	Scores           []IndexScore       `json:"scores"`
+	Suggestions      []IndexSuggestion  `json:"suggestions"`
+	Stdout           string             `json:"stdout"`
+	TotalMilli       int                `json:"totalMilli"`
+	WhatIf           *WhatIfResult      `json:"whatIf,omitempty"`
+
+	line string
 }
 
 // NewExplain returns Explain struct
@@ -32,76 +63,190 @@ func (e *Explain) SetVerbose(verbose bool) {
 	e.verbose = verbose
 }
 
-// ExecuteAllPlans calls queryPlanner and cardinality
+// SetWhatIf sets to evaluate suggested indexes on a scratch clone of sampled documents
+func (e *Explain) SetWhatIf(whatIf bool) {
+	e.whatIf = whatIf
+}
+
+// SetVersion sets version of the report
+func (e *Explain) SetVersion(version string) {
+	e.version = version
+}
+
+// ExecuteAllPlans explains each query shape of a log once, ranked by total time, and
+// writes a consolidated report in JSON and HTML
 func (e *Explain) ExecuteAllPlans(client *mongo.Client, filename string) error {
 	var err error
-	var reader *bufio.Reader
-
-	if reader, err = gox.NewFileReader(filename); err != nil {
+	var results []*ExplainResult
+	if results, err = e.readQueryShapes(filename); err != nil {
 		return err
 	}
 	qe := NewQueryExplainer(client)
 	qe.SetVerbose(e.verbose)
 	card := NewCardinality(client)
 	card.SetVerbose(e.verbose)
-	stdout := ""
-	counter := 0
+	report := ExplainReport{Filename: filepath.Base(filename), Results: []ExplainResult{}}
+	strs := []string{}
+	for i, result := range results {
+		if err = qe.ReadQueryShape([]byte(result.line)); err != nil {
+			return err
+		}
+		e.explain(qe, card, result)
+		if i == 0 {
+			fmt.Println(result.Stdout)
+		}
+		fmt.Printf("%3d. %v %v, count: %v, total: %v ms, hash: %v\n", i+1, result.Command, result.Namespace,
+			result.Count, result.TotalMilli, result.QueryHash)
+		strs = append(strs, fmt.Sprintf("%d. %v %v, count: %v, total: %v ms", i+1, result.Command, result.Namespace,
+			result.Count, result.TotalMilli), result.Stdout)
+		report.Results = append(report.Results, *result)
+	}
+	report.Stdout = strings.Join(strs, "\n")
+	os.Mkdir(outdir, 0755)
+	ofile := fmt.Sprintf("%v/%v-explain.json.gz", outdir, filepath.Base(filename))
+	data, _ := json.Marshal(report)
+	if err = gox.OutputGzipped(data, ofile); err != nil {
+		return err
+	}
+	fmt.Println("json data written to", ofile)
+	htmlGen := NewHTMLGenerator(e.version)
+	_, err = htmlGen.GenerateExplainHTML(&report)
+	return err
+}
+
+// readQueryShapes reads slow ops of a log and returns query shapes ranked by total time
+func (e *Explain) readQueryShapes(filename string) ([]*ExplainResult, error) {
+	var err error
+	var reader *bufio.Reader
+	if reader, err = gox.NewFileReader(filename); err != nil {
+		return nil, err
+	}
+	qe := NewQueryExplainer(nil)
+	results := []*ExplainResult{}
+	shapes := map[string]*ExplainResult{}
 	for {
-		buffer, _, rerr := reader.ReadLine()
+		str, rerr := readLogLine(reader) // logv2 lines can be longer than the buffer
 		if rerr != nil {
 			break
-		} else if !strings.HasSuffix(string(buffer), "ms") {
+		} else if !strings.HasSuffix(str, "ms") && !strings.HasPrefix(strings.TrimSpace(str), "{") {
 			continue
 		}
-		if err = qe.ReadQueryShape(buffer); err != nil {
+		if err = qe.ReadQueryShape([]byte(str)); err != nil {
 			continue
 		}
-		var summary CardinalitySummary
-		keys := GetKeys(qe.ExplainCmd.Filter)
-		keys = append(keys, GetKeys(qe.ExplainCmd.Sort)...)
-		pos := strings.Index(qe.NameSpace, ".")
-		db := qe.NameSpace[:pos]
-		collection := qe.NameSpace[pos+1:]
-		if summary, err = card.GetCardinalityArray(db, collection, keys); err != nil {
-			return err
+		result, ok := shapes[qe.QueryHash]
+		if !ok {
+			command := qe.ExplainCmd.Command
+			if command == "" {
+				command = cmdFind
+			}
+			result = &ExplainResult{Command: command, Namespace: qe.NameSpace, QueryHash: qe.QueryHash,
+				QueryShape: qe.QueryShape, line: str}
+			shapes[qe.QueryHash] = result
+			results = append(results, result)
 		}
-		var explainSummary ExplainSummary
-		if explainSummary, err = qe.Explain(); err != nil {
-			fmt.Println(err.Error())
+		result.Count++
+		result.TotalMilli += getLineMilli(str)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].TotalMilli == results[j].TotalMilli {
+			return results[i].Count > results[j].Count
 		}
-		strs := []string{}
-		strs = append(strs, qe.GetSummary(explainSummary))
-		strs = append(strs, "=> All Applicable Indexes Scores")
-		strs = append(strs, "=========================================")
-		scores := qe.GetIndexesScores(keys)
-		strs = append(strs, gox.Stringify(scores, "", "  "))
-		strs = append(strs, card.GetSummary(summary)+"\n")
-		document := bson.M{}
-		document["ns"] = qe.NameSpace
-		document["cardinality"] = summary
-		document["explain"] = explainSummary
-		document["scores"] = scores
-		if len(summary.List) > 0 {
-			recommendedIndex := GetIndexSuggestion(qe.ExplainCmd, summary.List)
-			document["recommendedIndex"] = recommendedIndex
-			strs = append(strs, "Index Suggestion:", gox.Stringify(recommendedIndex))
+		return results[i].TotalMilli > results[j].TotalMilli
+	})
+	return results, nil
+}
+
+// explain explains a query shape, evaluates scores of indexes, and recommends an index
+func (e *Explain) explain(qe *QueryExplainer, card *Cardinality, result *ExplainResult) {
+	var err error
+	keys := GetKeys(qe.ExplainCmd.Filter)
+	keys = append(keys, GetKeys(qe.ExplainCmd.Sort)...)
+	db, collection := SplitNamespace(qe.NameSpace)
+	if result.Cardinality, err = card.GetCardinalityArray(db, collection, keys); err != nil {
+		result.Error = err.Error()
+	}
+	if result.Explain, err = qe.Explain(); err != nil {
+		fmt.Println(err.Error())
+		if result.Error == "" {
+			result.Error = err.Error()
 		}
-		strs = append(strs, "")
-		stdout = strings.Join(strs, "\n")
-		document["stdout"] = stdout
-		counter++
-		if counter == 1 {
-			fmt.Println(stdout)
+	}
+	strs := []string{}
+	strs = append(strs, qe.GetSummary(result.Explain))
+	strs = append(strs, "=> All Applicable Indexes Scores")
+	strs = append(strs, "=========================================")
+	result.Scores = qe.GetIndexesScores(keys)
+	strs = append(strs, gox.Stringify(result.Scores, "", "  "))
+	strs = append(strs, card.GetSummary(result.Cardinality)+"\n")
+	result.Suggestions = GetIndexSuggestions(qe.ExplainCmd, result.Cardinality.List, result.Explain.MultiKeyFields)
+	for _, suggestion := range result.Suggestions {
+		if len(suggestion.Key) == 0 {
+			continue
 		}
-		os.Mkdir(outdir, 0755)
-		ofile := fmt.Sprintf("%v/%v-explain-%03d.json.gz", outdir, filepath.Base(filename), counter)
-		data, _ := bson.MarshalExtJSON(document, false, false)
-		if err = gox.OutputGzipped(data, ofile); err != nil {
-			return err
+		if result.RecommendedIndex == nil { // of the first branch
+			keys := []string{}
+			for _, elem := range suggestion.Key {
+				keys = append(keys, fmt.Sprintf(`"%v":%v`, elem.Key, elem.Value))
+			}
+			result.RecommendedIndex = gox.NewOrderedMap("{" + strings.Join(keys, ",") + "}")
 		}
-		fmt.Println("json data written to", ofile)
+		strs = append(strs, getIndexSuggestionString(suggestion))
 	}
-	return err
+	if e.whatIf {
+		candidates := []bson.D{}
+		for _, suggestion := range result.Suggestions {
+			if len(suggestion.Key) > 0 && suggestion.Key[0].Key != "_id" {
+				candidates = append(candidates, suggestion.Key)
+			}
+		}
+		if len(candidates) > 0 {
+			whatIf := NewIndexWhatIf(qe.client)
+			whatIf.SetVerbose(e.verbose)
+			if result.WhatIf, err = whatIf.Evaluate(qe, candidates); err != nil {
+				fmt.Println(err.Error())
+				if result.Error == "" {
+					result.Error = err.Error()
+				}
+			}
+			strs = append(strs, "", result.WhatIf.String())
+		}
+	}
+	strs = append(strs, "")
+	result.Stdout = strings.Join(strs, "\n")
+}
+
+// getIndexSuggestionString returns an index suggestion with reasons of key positions
+func getIndexSuggestionString(suggestion IndexSuggestion) string {
+	strs := []string{}
+	if suggestion.Branch > 0 {
+		strs = append(strs, fmt.Sprintf("Index Suggestion of $or branch %d: %v", suggestion.Branch, suggestion.KeyString))
+	} else {
+		strs = append(strs, "Index Suggestion: "+suggestion.KeyString)
+	}
+	for _, key := range suggestion.Keys {
+		strs = append(strs, fmt.Sprintf("  %-8s %v: %v", key.Type, key.Field, key.Reason))
+	}
+	for _, note := range suggestion.Notes {
+		strs = append(strs, "  * "+note)
+	}
+	return strings.Join(strs, "\n")
+}
+
+// reLineMilli matches the duration of a slow op text log line
+var reLineMilli = regexp.MustCompile(`(\d+)ms$`)
+
+// getLineMilli returns duration in milliseconds of a slow op log line
+func getLineMilli(str string) int {
+	if isLogv2Line([]byte(str)) {
+		var doc Logv2
+		json.Unmarshal([]byte(str), &doc)
+		return doc.Attributes.Milli
+	}
+	if m := reLineMilli.FindStringSubmatch(str); m != nil {
+		return ToInt(m[1])
+	}
+	return 0
 }
 
 // PrintExplainResults prints explain results
@@ -0,0 +1,76 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var explainLogs = []string{
+	`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"Red"},"$db":"keyhole"},"durationMillis":150}}`,
+	`{"t":{"$date":"2021-03-01T10:00:02.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"aggregate":"cars","pipeline":[{"$match":{"brand":"BMW"}},{"$group":{"_id":"$color"}}],"cursor":{},"$db":"keyhole"},"durationMillis":500}}`,
+	`{"t":{"$date":"2021-03-01T10:00:03.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"Blue"},"$db":"keyhole"},"durationMillis":400}}`,
+	`{"t":{"$date":"2021-03-01T10:00:04.000+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"127.0.0.1:50000","connectionCount":1}}`,
+}
+
+func TestReadQueryShapes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mongod.log")
+	if err := os.WriteFile(filename, []byte(strings.Join(explainLogs, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := NewExplain().readQueryShapes(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal("expected 2 query shapes, but got", len(results))
+	}
+	if results[0].Command != cmdFind || results[0].Count != 2 || results[0].TotalMilli != 550 {
+		t.Fatal("unexpected first query shape", results[0])
+	}
+	if results[1].Command != cmdAggregate || results[1].Count != 1 || results[1].TotalMilli != 500 {
+		t.Fatal("unexpected second query shape", results[1])
+	}
+	if getLineMilli(`2019-05-06T13:40:07.300+0000 I COMMAND  [conn1] command keyhole.cars command: find { find: "cars" } 123ms`) != 123 {
+		t.Fatal("expected 123 ms")
+	}
+}
+
+func TestGetPlanString(t *testing.T) {
+	str := `{"stage": "FETCH", "inputStage": {"stage": "OR", "inputStages": [
+		{"stage": "IXSCAN", "keyPattern": {"a": 1}}, {"stage": "IXSCAN", "keyPattern": {"b": -1}}]}}`
+	var plan bson.D
+	if err := bson.UnmarshalExtJSON([]byte(str), false, &plan); err != nil {
+		t.Fatal(err)
+	}
+	expected := "FETCH > OR > [IXSCAN { a: 1 }, IXSCAN { b: -1 }]"
+	if s := getPlanString(plan); s != expected {
+		t.Fatal("expected", expected, "but got", s)
+	}
+}
+
+func TestGetExplainTemplate(t *testing.T) {
+	templ, err := NewHTMLGenerator("utest-xxxxxx").GetExplainTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := ExplainReport{Filename: "mongod.log", Results: []ExplainResult{
+		{Command: cmdFind, Count: 2, Namespace: "keyhole.cars", QueryShape: `{"color":1}`, TotalMilli: 550,
+			Explain: ExplainSummary{WinningPlan: "FETCH > IXSCAN { color: 1 }", RejectedPlans: []string{"COLLSCAN"}}},
+		{Command: cmdAggregate, Count: 1, Error: "no index selected (COLLSCAN)", Namespace: "keyhole.cars"},
+	}}
+	var buffer bytes.Buffer
+	if err = templ.Execute(&buffer, report); err != nil {
+		t.Fatal(err)
+	}
+	html := buffer.String()
+	if !strings.Contains(html, "FETCH &gt; IXSCAN { color: 1 }") || !strings.Contains(html, "no index selected (COLLSCAN)") {
+		t.Fatal("expected winning plan and error in the report")
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// severities of findings, from the most to the least severe
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// scopes of rules
+const (
+	ScopeCluster    = "cluster"
+	ScopeHost       = "host"
+	ScopeCollection = "collection"
+)
+
+// defaultHealthRules are best practice checks, a rule of an external file of the same id replaces one
+const defaultHealthRules = `{"rules": [
+	{"id": "eol-version", "scope": "cluster", "severity": "critical",
+		"conditions": [{"metric": "versionEOL", "op": "==", "value": true}],
+		"message": "MongoDB v{version} reached end of life on {eolDate}, upgrade to a supported version"},
+	{"id": "oplog-window", "scope": "host", "severity": "warning",
+		"conditions": [{"metric": "oplogWindowHours", "op": "<", "value": 24}],
+		"message": "oplog window of {oplogWindowHours} hours is under 24 hours"},
+	{"id": "wt-cache-usage", "scope": "host", "severity": "critical",
+		"conditions": [{"metric": "wtCacheUsedPct", "op": ">", "value": 95}],
+		"message": "WiredTiger cache is {wtCacheUsedPct}% used, eviction by application threads slows down operations"},
+	{"id": "numa-enabled", "scope": "host", "severity": "warning",
+		"conditions": [{"metric": "numaEnabled", "op": "==", "value": true}],
+		"message": "NUMA is enabled, disable zone reclaim and start mongod with numactl --interleave=all"},
+	{"id": "thp", "scope": "host", "severity": "warning",
+		"conditions": [{"metric": "thpMisconfigured", "op": "==", "value": true}],
+		"message": "transparent huge pages is {thpEnabled}, expected never before v8.0 and always since v8.0"},
+	{"id": "id-index-only", "scope": "collection", "severity": "warning",
+		"conditions": [{"metric": "idIndexOnly", "op": "==", "value": true}, {"metric": "count", "op": ">", "value": 100000},
+			{"metric": "collectionScans", "op": ">", "value": 0}],
+		"message": "only the _id index on {count} documents, scanned {collectionScans} times"},
+	{"id": "too-many-indexes", "scope": "collection", "severity": "warning",
+		"conditions": [{"metric": "numIndexes", "op": ">", "value": 20}],
+		"message": "{numIndexes} indexes slow down writes and consume cache"},
+	{"id": "jumbo-chunks", "scope": "collection", "severity": "warning",
+		"conditions": [{"metric": "jumboChunks", "op": ">", "value": 0}],
+		"message": "{jumboChunks} jumbo chunks cannot be moved by the balancer"},
+	{"id": "unbalanced-shards", "scope": "collection", "severity": "warning",
+		"conditions": [{"metric": "shardImbalancePct", "op": ">", "value": 20}],
+		"message": "data of shards differs by {shardImbalancePct}% of the average"}
+]}`
+
+// eolDates are end of life dates of MongoDB versions, older versions are end of life
+var eolDates = map[string]string{
+	"4.0": "2022-04-30", "4.2": "2023-04-30", "4.4": "2024-02-29", "5.0": "2024-10-31",
+	"6.0": "2025-07-31", "7.0": "2027-08-31", "8.0": "2029-10-31",
+}
+
+// HealthRule defines a check, a finding is reported when all conditions are met
+type HealthRule struct {
+	Conditions []RuleCondition `json:"conditions"`
+	Disabled   bool            `json:"disabled,omitempty"`
+	ID         string          `json:"id"`
+	Message    string          `json:"message"` // {metric} is replaced by its value
+	Scope      string          `json:"scope"`
+	Severity   string          `json:"severity"`
+}
+
+// RuleCondition compares a metric with a value. A metric is a computed fact or a dotted path of
+// the cluster stats, a server stats, or a collection of the rule scope
+type RuleCondition struct {
+	Metric string      `json:"metric"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value"`
+}
+
+// Finding stores a result of a rule
+type Finding struct {
+	Message  string `json:"message" bson:"message"`
+	RuleID   string `json:"rule" bson:"rule"`
+	Scope    string `json:"scope" bson:"scope"`
+	Severity string `json:"severity" bson:"severity"`
+	Target   string `json:"target" bson:"target"` // cluster, host, or namespace
+}
+
+// HealthCheck evaluates rules against cluster stats
+type HealthCheck struct {
+	rules []HealthRule
+}
+
+// NewHealthCheck returns HealthCheck of default rules
+func NewHealthCheck() *HealthCheck {
+	rules, _ := parseHealthRules([]byte(defaultHealthRules))
+	return &HealthCheck{rules: rules}
+}
+
+// ReadRulesFile merges rules from a JSON file, a rule of the same id replaces a default rule
+func (hc *HealthCheck) ReadRulesFile(filename string) error {
+	var err error
+	var data []byte
+	if data, err = os.ReadFile(filename); err != nil {
+		return err
+	}
+	var rules []HealthRule
+	if rules, err = parseHealthRules(data); err != nil {
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+	for _, rule := range rules {
+		replaced := false
+		for i := range hc.rules {
+			if hc.rules[i].ID == rule.ID {
+				hc.rules[i], replaced = rule, true
+			}
+		}
+		if !replaced {
+			hc.rules = append(hc.rules, rule)
+		}
+	}
+	return nil
+}
+
+// parseHealthRules returns validated rules of a JSON document {"rules": [...]}
+func parseHealthRules(data []byte) ([]HealthRule, error) {
+	var doc struct {
+		Rules []HealthRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for _, rule := range doc.Rules {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("rule id is required")
+		}
+		if rule.Scope != ScopeCluster && rule.Scope != ScopeHost && rule.Scope != ScopeCollection {
+			return nil, fmt.Errorf("rule %v: invalid scope %q", rule.ID, rule.Scope)
+		}
+		if getSeverityRank(rule.Severity) < 0 {
+			return nil, fmt.Errorf("rule %v: invalid severity %q", rule.ID, rule.Severity)
+		}
+		if len(rule.Conditions) == 0 {
+			return nil, fmt.Errorf("rule %v: conditions are required", rule.ID)
+		}
+		for _, cond := range rule.Conditions {
+			switch cond.Op {
+			case "<", "<=", ">", ">=", "==", "!=":
+			default:
+				return nil, fmt.Errorf("rule %v: invalid op %q", rule.ID, cond.Op)
+			}
+		}
+	}
+	return doc.Rules, nil
+}
+
+// getSeverityRank returns rank of a severity, 0 is the most severe
+func getSeverityRank(severity string) int {
+	for i, s := range []string{SeverityCritical, SeverityWarning, SeverityInfo} {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Evaluate returns findings of all rules sorted by severity
+func (hc *HealthCheck) Evaluate(stats *ClusterStats) []Finding {
+	findings := []Finding{}
+	cluster := getClusterFacts(stats)
+	hosts := getHostFacts(stats)
+	colls := []ruleFacts{}
+	if stats.Databases != nil {
+		for _, db := range *stats.Databases {
+			for _, coll := range db.Collections {
+				colls = append(colls, getCollectionFacts(coll))
+			}
+		}
+	}
+	for _, rule := range hc.rules {
+		if rule.Disabled {
+			continue
+		}
+		targets := []ruleFacts{cluster}
+		if rule.Scope == ScopeHost {
+			targets = hosts
+		} else if rule.Scope == ScopeCollection {
+			targets = colls
+		}
+		for _, facts := range targets {
+			if matched, message := facts.match(rule); matched {
+				findings = append(findings, Finding{Message: message, RuleID: rule.ID, Scope: rule.Scope,
+					Severity: rule.Severity, Target: facts.target})
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return getSeverityRank(findings[i].Severity) < getSeverityRank(findings[j].Severity)
+	})
+	return findings
+}
+
+// ruleFacts stores computed facts and the document of a target
+type ruleFacts struct {
+	doc    bson.M
+	facts  map[string]interface{}
+	target string
+}
+
+// get returns a computed fact, or a value of a dotted path of the document
+func (rf ruleFacts) get(metric string) (interface{}, bool) {
+	if v, ok := rf.facts[metric]; ok {
+		return v, true
+	}
+	var v interface{} = rf.doc
+	for _, key := range strings.Split(metric, ".") {
+		switch doc := v.(type) {
+		case bson.M:
+			v = doc[key]
+		case bson.D:
+			v = doc.Map()[key]
+		default:
+			return nil, false
+		}
+		if v == nil {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// match returns true and the message if all conditions of a rule are met
+func (rf ruleFacts) match(rule HealthRule) (bool, string) {
+	message := rule.Message
+	for _, cond := range rule.Conditions {
+		v, ok := rf.get(cond.Metric)
+		if !ok || !compareRuleValue(v, cond.Op, cond.Value) {
+			return false, ""
+		}
+	}
+	for start := 0; start < len(message); {
+		i := strings.Index(message[start:], "{")
+		if i < 0 {
+			break
+		}
+		i += start
+		j := strings.Index(message[i:], "}")
+		if j < 0 {
+			break
+		}
+		str := "-"
+		if v, ok := rf.get(message[i+1 : i+j]); ok {
+			str = fmt.Sprint(v)
+			if f, isFloat := v.(float64); isFloat && f != float64(int64(f)) {
+				str = fmt.Sprintf("%.1f", f)
+			}
+		}
+		message = message[:i] + str + message[i+j+1:]
+		start = i + len(str)
+	}
+	return true, message
+}
+
+// compareRuleValue compares numbers numerically, and other values as strings
+func compareRuleValue(v interface{}, op string, value interface{}) bool {
+	a, aerr := strconv.ParseFloat(fmt.Sprint(v), 64)
+	b, berr := strconv.ParseFloat(fmt.Sprint(value), 64)
+	if aerr != nil || berr != nil {
+		sa, sb := fmt.Sprint(v), fmt.Sprint(value)
+		switch op {
+		case "==":
+			return sa == sb
+		case "!=":
+			return sa != sb
+		}
+		return false
+	}
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+// toRuleDoc returns a document of a struct for dotted paths of rules
+func toRuleDoc(v interface{}) bson.M {
+	doc := bson.M{}
+	if data, err := bson.Marshal(v); err == nil {
+		bson.Unmarshal(data, &doc)
+	}
+	return doc
+}
+
+// getClusterFacts returns facts of the cluster
+func getClusterFacts(stats *ClusterStats) ruleFacts {
+	summary := *stats
+	summary.Databases, summary.Shards = nil, nil
+	facts := map[string]interface{}{"version": stats.BuildInfo.Version, "numShards": len(stats.Shards)}
+	if eolDate, isEOL := getVersionEOL(stats.BuildInfo.Version, getStatsTime(stats)); eolDate != "" {
+		facts["eolDate"], facts["versionEOL"] = eolDate, isEOL
+	}
+	target := stats.HostInfo.System.Hostname
+	if target == "" {
+		target = ScopeCluster
+	}
+	return ruleFacts{doc: toRuleDoc(summary), facts: facts, target: target}
+}
+
+// getStatsTime returns the time stats collected
+func getStatsTime(stats *ClusterStats) time.Time {
+	if stats.ServerStatus.LocalTime.IsZero() {
+		return time.Now()
+	}
+	return stats.ServerStatus.LocalTime
+}
+
+// getVersionEOL returns end of life date of a version and whether it is passed
+func getVersionEOL(version string, now time.Time) (string, bool) {
+	toks := strings.Split(version, ".")
+	if len(toks) < 2 {
+		return "", false
+	}
+	major, _ := strconv.Atoi(toks[0])
+	minor, _ := strconv.Atoi(toks[1])
+	if eolDate, ok := eolDates[fmt.Sprintf("%d.%d", major, minor)]; ok {
+		t, _ := time.Parse("2006-01-02", eolDate)
+		return eolDate, now.After(t)
+	} else if major < 4 {
+		return "before 2022", true
+	}
+	return "", false
+}
+
+// getHostFacts returns facts of all servers, or of the connected server
+func getHostFacts(stats *ClusterStats) []ruleFacts {
+	servers := []ClusterStats{}
+	for _, shard := range stats.Shards {
+		servers = append(servers, shard.Servers...)
+	}
+	if len(servers) == 0 {
+		servers = append(servers, *stats)
+	}
+	hosts := []ruleFacts{}
+	for _, server := range servers {
+		server.Databases, server.Shards = nil, nil
+		facts := map[string]interface{}{"numaEnabled": server.HostInfo.System.NumaEnabled,
+			"version": server.BuildInfo.Version}
+		if server.OplogStats.MaxSize > 0 {
+			facts["oplogWindowHours"] = float64(server.OplogStats.DurationInSeconds) / 3600
+		}
+		cache := server.ServerStatus.WiredTiger.Cache
+		if maxBytes := toFloat64(cache["maximum bytes configured"]); maxBytes > 0 {
+			facts["wtCacheUsedPct"] = 100 * toFloat64(cache["bytes currently in the cache"]) / maxBytes
+		}
+		if enabled := server.HostInfo.Extra.THP.Enabled; enabled != "" {
+			expected := "never"
+			if toks := strings.Split(server.BuildInfo.Version, "."); toInt64(toks[0]) >= 8 {
+				expected = "always"
+			}
+			facts["thpEnabled"], facts["thpMisconfigured"] = enabled, enabled != expected
+		}
+		target := server.ServerStatus.Host
+		if target == "" {
+			target = server.HostInfo.System.Hostname
+		}
+		hosts = append(hosts, ruleFacts{doc: toRuleDoc(server), facts: facts, target: target})
+	}
+	return hosts
+}
+
+// getCollectionFacts returns facts of a collection
+func getCollectionFacts(coll Collection) ruleFacts {
+	facts := map[string]interface{}{"count": coll.Stats.Count, "dataSize": coll.Stats.Size,
+		"numIndexes": len(coll.Indexes), "storageSize": coll.Stats.StorageSize,
+		"idIndexOnly":     len(coll.Indexes) == 1 && coll.Indexes[0].Name == "_id_",
+		"collectionScans": coll.Stats.QueryExecStats.CollectionScans.Total} // of $collStats since 4.4
+	var jumbo int64
+	sizes := []float64{}
+	useSize := true
+	for _, chunk := range coll.Chunks {
+		jumbo += chunk.Jumbo
+		if chunk.Size == 0 {
+			useSize = false
+		}
+	}
+	for _, chunk := range coll.Chunks { // data sizes of shards, or numbers of chunks if sizes not collected
+		if useSize {
+			sizes = append(sizes, float64(chunk.Size))
+		} else {
+			sizes = append(sizes, float64(chunk.Total))
+		}
+	}
+	if len(coll.Chunks) > 0 {
+		facts["jumboChunks"] = jumbo
+	}
+	if len(sizes) > 1 {
+		sort.Float64s(sizes)
+		var sum float64
+		for _, size := range sizes {
+			sum += size
+		}
+		if avg := sum / float64(len(sizes)); avg > 0 {
+			facts["shardImbalancePct"] = 100 * (sizes[len(sizes)-1] - sizes[0]) / avg
+		}
+	}
+	return ruleFacts{doc: toRuleDoc(coll), facts: facts, target: coll.NS}
+}
+
+// GetFindingsSummary returns findings in lines
+func GetFindingsSummary(findings []Finding) string {
+	var buffer bytes.Buffer
+	counts := map[string]int{}
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+	buffer.WriteString(fmt.Sprintf("Health check: %d critical, %d warning, %d info\n",
+		counts[SeverityCritical], counts[SeverityWarning], counts[SeverityInfo]))
+	for _, f := range findings {
+		buffer.WriteString(fmt.Sprintf("  [%v] %v %v: %v\n", strings.ToUpper(f.Severity), f.RuleID, f.Target, f.Message))
+	}
+	return buffer.String()
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestHealthCheckEvaluate(t *testing.T) {
+	server := ClusterStats{}
+	server.BuildInfo.Version = "5.0.14"
+	server.ServerStatus.Host = "host1:27017"
+	server.ServerStatus.LocalTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.ServerStatus.WiredTiger.Cache = bson.M{"maximum bytes configured": 1000, "bytes currently in the cache": 980}
+	server.HostInfo.System.NumaEnabled = true
+	server.HostInfo.Extra.THP.Enabled = "always"
+	server.OplogStats = OplogStats{DurationInSeconds: 12 * 3600, MaxSize: 1024}
+	stats := server
+	stats.Shards = []Shard{{ID: "rs0", Servers: []ClusterStats{server}}}
+	cars := Collection{NS: "keyhole.cars", Indexes: []Index{{Name: "_id_"}},
+		Chunks: []Chunk{{Shard: "shard0", Size: 300, Jumbo: 2}, {Shard: "shard1", Size: 100}}}
+	cars.Stats.Count = 200000
+	cars.Stats.QueryExecStats.CollectionScans.Total = 12
+	logs := Collection{NS: "keyhole.logs", Indexes: []Index{{Name: "_id_"}}} // append only, never scanned
+	logs.Stats.Count = 500000
+	stats.Databases = &[]Database{{Name: "keyhole", Collections: []Collection{cars, logs}}}
+
+	findings := NewHealthCheck().Evaluate(&stats)
+	rules := map[string]Finding{}
+	for _, f := range findings {
+		if f.Target == "keyhole.logs" {
+			t.Fatal("unexpected finding of a collection never scanned", f)
+		}
+		rules[f.RuleID] = f
+	}
+	for _, id := range []string{"eol-version", "oplog-window", "wt-cache-usage", "numa-enabled", "thp",
+		"id-index-only", "jumbo-chunks", "unbalanced-shards"} {
+		if _, ok := rules[id]; !ok {
+			t.Fatal("expected finding of", id, findings)
+		}
+	}
+	if _, ok := rules["too-many-indexes"]; ok {
+		t.Fatal("unexpected finding of too-many-indexes")
+	}
+	if findings[0].Severity != SeverityCritical || rules["oplog-window"].Target != "host1:27017" ||
+		rules["oplog-window"].Message != "oplog window of 12 hours is under 24 hours" ||
+		rules["unbalanced-shards"].Message != "data of shards differs by 100% of the average" ||
+		rules["id-index-only"].Message != "only the _id index on 200000 documents, scanned 12 times" {
+		t.Fatal("unexpected findings", findings)
+	}
+	stats.Findings = findings
+	templ, err := NewHTMLGenerator("utest-xxxxxx").GetClusterTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	if err = templ.Execute(&buffer, &stats); err != nil || !strings.Contains(buffer.String(), "Health Check (8)") {
+		t.Fatal("unexpected html", err)
+	}
+	t.Log(GetFindingsSummary(findings))
+}
+
+func TestHealthCheckReadRulesFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rules.json")
+	rules := `{"rules": [
+		{"id": "numa-enabled", "disabled": true, "scope": "host", "severity": "warning",
+			"conditions": [{"metric": "numaEnabled", "op": "==", "value": true}]},
+		{"id": "capped", "scope": "collection", "severity": "info",
+			"conditions": [{"metric": "stats.capped", "op": "==", "value": true}], "message": "{namespace} is capped"}]}`
+	if err := os.WriteFile(filename, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hc := NewHealthCheck()
+	if err := hc.ReadRulesFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	stats := ClusterStats{}
+	stats.HostInfo.System.NumaEnabled = true
+	logs := Collection{NS: "keyhole.logs"}
+	logs.Stats.Capped = true
+	stats.Databases = &[]Database{{Name: "keyhole", Collections: []Collection{logs}}}
+	findings := hc.Evaluate(&stats)
+	if len(findings) != 1 || findings[0].RuleID != "capped" || findings[0].Message != "keyhole.logs is capped" {
+		t.Fatal("unexpected findings", findings)
+	}
+
+	invalid := strings.Replace(rules, `"op": "=="`, `"op": "="`, 1)
+	os.WriteFile(filename, []byte(invalid), 0644)
+	if err := hc.ReadRulesFile(filename); err == nil {
+		t.Fatal("expected error of an invalid op")
+	}
+}
@@ -13,6 +13,10 @@ import (
 type HostInfo struct {
 	Extra struct {
 		KernelVersion string
+		THP           struct { // transparent huge pages, if reported
+			Defrag  string `bson:"defrag"`
+			Enabled string `bson:"enabled"`
+		} `bson:"thp"`
 	}
 	OS struct {
 		Name    string
@@ -32,7 +36,11 @@ type HostInfo struct {
 
 // GetHostInfo returns MongoDB build information
 func GetHostInfo(client *mongo.Client) (HostInfo, error) {
-	ctx := context.Background()
+	return GetHostInfoWithContext(context.Background(), client)
+}
+
+// GetHostInfoWithContext returns host information within a context
+func GetHostInfoWithContext(ctx context.Context, client *mongo.Client) (HostInfo, error) {
 	var hostInfo HostInfo
 	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hostInfo", Value: 1}}).Decode(&hostInfo)
 	return hostInfo, err
@@ -10,6 +10,7 @@ import (
     "strings"
     "time"
 
+    "github.com/simagix/gox"
     "go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -84,9 +85,116 @@ func (hg *HTMLGenerator) GetClusterTemplate() (*template.Template, error) {
         "indexSize":       hg.indexSize,
         "sortCollectionsBySize": hg.sortCollectionsBySize,
         "gtf":             func(a, b float64) bool { return a > b },
+        "avgLatency":      hg.avgLatency,
 	}).Parse(clusterHTMLTemplate)
 }
 
+// GenerateExplainHTML generates an HTML report from explain results of query shapes
+func (hg *HTMLGenerator) GenerateExplainHTML(report *ExplainReport) (string, error) {
+	var err error
+	os.Mkdir(htmldir, 0755)
+	ofile := fmt.Sprintf(`%v/%v-explain.html`, htmldir, report.Filename)
+	var w *os.File
+	if w, err = os.Create(ofile); err != nil {
+		return "", err
+	}
+	defer w.Close()
+
+	templ, err := hg.GetExplainTemplate()
+	if err != nil {
+		return "", err
+	}
+	if err = templ.Execute(w, report); err != nil {
+		return "", err
+	}
+	fmt.Printf("HTML report written to %v\n", ofile)
+	return ofile, nil
+}
+
+// GetExplainTemplate returns the HTML template for explain results
+func (hg *HTMLGenerator) GetExplainTemplate() (*template.Template, error) {
+	return template.New("explain").Funcs(template.FuncMap{
+		"formatNumber":    hg.formatNumber,
+		"getCurrentTime":  func() string { return time.Now().Format("2006-01-02 15:04:05") },
+		"getMongoVersion": func() string { return hg.version },
+		"int64":           func(i int) int64 { return int64(i) },
+		"add":             func(a, b int) int { return a + b },
+		"div":             func(a, b int) int { return a / b },
+		"stringify":       func(v interface{}) string { return gox.Stringify(v) },
+	}).Parse(explainHTMLTemplate)
+}
+
+// GenerateTrendHTML generates an HTML report of growth trends of snapshots
+func (hg *HTMLGenerator) GenerateTrendHTML(trend *StatsTrend) (string, error) {
+	var err error
+	os.Mkdir(htmldir, 0755)
+	ofile := fmt.Sprintf(`%v/%v-trend.html`, htmldir, strings.ReplaceAll(trend.Host, ":", "_"))
+	var w *os.File
+	if w, err = os.Create(ofile); err != nil {
+		return "", err
+	}
+	defer w.Close()
+
+	templ, err := hg.GetTrendTemplate()
+	if err != nil {
+		return "", err
+	}
+	if err = templ.Execute(w, trend); err != nil {
+		return "", err
+	}
+	fmt.Printf("HTML report written to %v\n", ofile)
+	return ofile, nil
+}
+
+// GetTrendTemplate returns the HTML template for growth trends
+func (hg *HTMLGenerator) GetTrendTemplate() (*template.Template, error) {
+	return template.New("trend").Funcs(template.FuncMap{
+		"formatBytes":     hg.formatBytes,
+		"formatNumber":    hg.formatNumber,
+		"formatTime":      hg.formatTime,
+		"getCurrentTime":  func() string { return time.Now().Format("2006-01-02 15:04:05") },
+		"getMongoVersion": func() string { return hg.version },
+		"int64":           func(f float64) int64 { return int64(f) },
+		"pct":             func(f float64) string { return fmt.Sprintf("%+.1f%%", f) },
+		"daysLeft":        getDaysLeftString,
+	}).Parse(trendHTMLTemplate)
+}
+
+// GenerateShardingHTML generates an HTML report of balance and chunk distribution of a sharded cluster
+func (hg *HTMLGenerator) GenerateShardingHTML(report *ShardingReport) (string, error) {
+	var err error
+	os.Mkdir(htmldir, 0755)
+	ofile := fmt.Sprintf(`%v/%v-sharding.html`, htmldir, strings.ReplaceAll(report.Host, ":", "_"))
+	var w *os.File
+	if w, err = os.Create(ofile); err != nil {
+		return "", err
+	}
+	defer w.Close()
+
+	templ, err := hg.GetShardingTemplate()
+	if err != nil {
+		return "", err
+	}
+	if err = templ.Execute(w, report); err != nil {
+		return "", err
+	}
+	fmt.Printf("HTML report written to %v\n", ofile)
+	return ofile, nil
+}
+
+// GetShardingTemplate returns the HTML template for the sharding report
+func (hg *HTMLGenerator) GetShardingTemplate() (*template.Template, error) {
+	return template.New("sharding").Funcs(template.FuncMap{
+		"formatBytes":     hg.formatBytes,
+		"formatNumber":    hg.formatNumber,
+		"formatTime":      hg.formatTime,
+		"getCurrentTime":  func() string { return time.Now().Format("2006-01-02 15:04:05") },
+		"getMongoVersion": func() string { return hg.version },
+		"pct":             func(f float64) string { return fmt.Sprintf("%.1f%%", f) },
+		"join":            strings.Join,
+	}).Parse(shardingHTMLTemplate)
+}
+
 // formatBytes formats bytes into human readable format
 func (hg *HTMLGenerator) formatBytes(bytes int64) string {
 	if bytes == 0 {
@@ -104,6 +212,14 @@ func (hg *HTMLGenerator) formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// avgLatency returns average latency of ops in milliseconds
+func (hg *HTMLGenerator) avgLatency(o OpLatency) string {
+	if o.Ops == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f ms", float64(o.Latency)/float64(o.Ops)/1000)
+}
+
 // formatNumber formats numbers with commas
 func (hg *HTMLGenerator) formatNumber(n int64) string {
 	if n == 0 {
@@ -361,6 +477,13 @@ const clusterHTMLTemplate = `<!DOCTYPE html>
     .section {
       margin: 30px 0;
     }
+    .severity-critical td:first-child {
+      color: #c00;
+      font-weight: bold;
+    }
+    .severity-warning td:first-child {
+      color: #e68a00;
+    }
     .timestamp {
       color: #666;
       font-size: 0.9em;
@@ -390,6 +513,32 @@ const clusterHTMLTemplate = `<!DOCTYPE html>
       </div>
     </div>
 
+    {{if .Findings}}
+    <!-- Health Check -->
+    <div class="section">
+      <h2>Health Check ({{len .Findings}})</h2>
+      <table>
+        <tr><th>Severity</th><th>Rule</th><th>Target</th><th>Finding</th></tr>
+        {{range .Findings}}
+        <tr class="severity-{{.Severity}}"><td>{{.Severity}}</td><td>{{.RuleID}}</td><td>{{.Target}}</td><td>{{.Message}}</td></tr>
+        {{end}}
+      </table>
+    </div>
+    {{end}}
+
+    {{if .Incomplete}}
+    <!-- Incomplete Namespaces -->
+    <div class="section">
+      <h2>Namespaces and Servers Skipped or Failed ({{len .Incomplete}}){{if .Interrupted}} - Interrupted{{end}}</h2>
+      <table>
+        <tr><th>Status</th><th>Namespace or Server</th><th>Error</th></tr>
+        {{range .Incomplete}}
+        <tr><td>{{.Status}}</td><td>{{.Namespace}}</td><td>{{.Error}}</td></tr>
+        {{end}}
+      </table>
+    </div>
+    {{end}}
+
     <!-- Server Status -->
     <div class="section">
       <h2>Server Status</h2>
@@ -456,7 +605,21 @@ const clusterHTMLTemplate = `<!DOCTYPE html>
           <tr><td>Indexes Size</td><td>{{formatBytes .Stats.TotalIndexSize}}</td></tr>
           <tr><td>Storage Size</td><td>{{formatBytes .Stats.StorageSize}}</td></tr>
           <tr><td>Data File Fragmentation</td><td>{{fragPct .Stats.Size .Stats.StorageSize}}</td></tr>
+          <tr><td>Reads</td><td>{{formatNumber .Stats.LatencyStats.Reads.Ops}} ops, {{avgLatency .Stats.LatencyStats.Reads}} avg</td></tr>
+          <tr><td>Writes</td><td>{{formatNumber .Stats.LatencyStats.Writes.Ops}} ops, {{avgLatency .Stats.LatencyStats.Writes}} avg</td></tr>
+          <tr><td>Collection Scans</td><td>{{formatNumber .Stats.QueryExecStats.CollectionScans.Total}}</td></tr>
+        </table>
+        {{if .Stats.ShardStats}}
+        <table>
+          <tr><th>Shard</th><th>Documents</th><th>Data Size</th><th>Reads</th><th>Writes</th><th>Collection Scans</th></tr>
+          {{range .Stats.ShardStats}}
+          <tr><td>{{.Shard}}</td><td>{{formatNumber .Count}}</td><td>{{formatBytes .Size}}</td>
+            <td>{{formatNumber .LatencyStats.Reads.Ops}} ops, {{avgLatency .LatencyStats.Reads}} avg</td>
+            <td>{{formatNumber .LatencyStats.Writes.Ops}} ops, {{avgLatency .LatencyStats.Writes}} avg</td>
+            <td>{{formatNumber .QueryExecStats.CollectionScans.Total}}</td></tr>
+          {{end}}
         </table>
+        {{end}}
 
         <!-- Indexes Usage per collection -->
         <h4>Indexes Usage</h4>
@@ -549,3 +712,410 @@ const clusterHTMLTemplate = `<!DOCTYPE html>
   </div>
 </body>
 </html>`
+
+const explainHTMLTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <title>Explain Results - {{.Filename}}</title>
+  <style>
+    body {
+      font-family: Arial, Helvetica, sans-serif;
+      margin: 20px;
+      background-color: #f5f5f5;
+    }
+    .container {
+      max-width: 1200px;
+      margin: 0 auto;
+      background-color: white;
+      padding: 20px;
+      border-radius: 8px;
+      box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+    }
+    h1, h2, h3 {
+      color: #333;
+      border-bottom: 2px solid #4CAF50;
+      padding-bottom: 10px;
+    }
+    table {
+      font-family: Consolas, monaco, monospace;
+      border-collapse: collapse;
+      width: 100%;
+      margin: 10px 0;
+    }
+    th, td {
+      border: 1px solid #ddd;
+      padding: 8px;
+      text-align: left;
+    }
+    th {
+      background-color: #4CAF50;
+      color: white;
+      font-weight: bold;
+    }
+    tr:nth-child(even) {
+      background-color: #f2f2f2;
+    }
+    pre {
+      font-family: Consolas, monaco, monospace;
+      white-space: pre-wrap;
+      word-break: break-all;
+    }
+    .error {
+      color: #c00;
+    }
+    .section {
+      margin: 30px 0;
+    }
+    .timestamp {
+      color: #666;
+      font-size: 0.9em;
+      text-align: right;
+    }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <h1>Explain Results - {{.Filename}}</h1>
+    <div class="timestamp">Generated: {{getCurrentTime}} | Keyhole Version: {{getMongoVersion}}</div>
+
+    <!-- Query Shapes -->
+    <div class="section">
+      <h2>Query Shapes by Total Time</h2>
+      <table>
+        <tr><th>#</th><th>Command</th><th>Namespace</th><th>Count</th><th>Total ms</th><th>Avg ms</th><th>Winning Plan</th><th>Hash</th></tr>
+        {{range $i, $r := .Results}}
+        <tr>
+          <td><a href="#shape-{{add $i 1}}">{{add $i 1}}</a></td>
+          <td>{{$r.Command}}</td>
+          <td>{{$r.Namespace}}</td>
+          <td>{{formatNumber (int64 $r.Count)}}</td>
+          <td>{{formatNumber (int64 $r.TotalMilli)}}</td>
+          <td>{{if $r.Count}}{{formatNumber (int64 (div $r.TotalMilli $r.Count))}}{{end}}</td>
+          <td>{{if $r.Error}}<span class="error">{{$r.Error}}</span>{{else}}{{$r.Explain.WinningPlan}}{{end}}</td>
+          <td>{{$r.QueryHash}}</td>
+        </tr>
+        {{end}}
+      </table>
+    </div>
+
+    {{range $i, $r := .Results}}
+    <div class="section" id="shape-{{add $i 1}}">
+      <h2>{{add $i 1}}. {{$r.Command}} {{$r.Namespace}}</h2>
+      <pre>{{$r.QueryShape}}</pre>
+      {{if $r.Error}}<p class="error">{{$r.Error}}</p>{{end}}
+      <h3>Winning Plan</h3>
+      <table>
+        <tr><th>Plan</th><th>Keys Examined</th><th>Docs Examined</th><th>Advanced</th><th>Works</th><th>Time ms (est.)</th></tr>
+        <tr>
+          <td>{{$r.Explain.WinningPlan}}{{if $r.Explain.ShardName}} (shard {{$r.Explain.ShardName}}){{end}}</td>
+          <td>{{$r.Explain.ExecutionStats.TotalKeysExamined}}</td>
+          <td>{{$r.Explain.ExecutionStats.TotalDocsExamined}}</td>
+          <td>{{$r.Explain.ExecutionStats.Advanced}}</td>
+          <td>{{$r.Explain.ExecutionStats.Works}}</td>
+          <td>{{$r.Explain.ExecutionStats.ExecTimeMillisEst}}</td>
+        </tr>
+      </table>
+      {{if $r.Explain.PipelineStages}}
+      <h3>Pipeline Stages</h3>
+      <table>
+        <tr><th>Stage</th><th>Returned</th><th>Time ms (est.)</th></tr>
+        {{range $r.Explain.PipelineStages}}
+        <tr><td>{{.Stage}}</td><td>{{.NReturned}}</td><td>{{.ExecTimeMillisEst}}</td></tr>
+        {{end}}
+      </table>
+      {{end}}
+      {{if $r.Explain.RejectedPlans}}
+      <h3>Rejected Plans</h3>
+      <table>
+        <tr><th>Plan</th></tr>
+        {{range $r.Explain.RejectedPlans}}
+        <tr><td>{{.}}</td></tr>
+        {{end}}
+      </table>
+      {{end}}
+      {{if $r.Scores}}
+      <h3>Index Scores</h3>
+      <table>
+        <tr><th>Index</th><th>Score</th></tr>
+        {{range $r.Scores}}
+        <tr><td>{{stringify .Index}}</td><td>{{printf "%.4f" .Score}}</td></tr>
+        {{end}}
+      </table>
+      {{end}}
+      {{range $r.Suggestions}}{{if .Keys}}
+      <h3>Index Suggestion{{if .Branch}} of $or Branch {{.Branch}}{{end}}: {{.KeyString}}</h3>
+      <table>
+        <tr><th>Field</th><th>Type</th><th>Reason</th></tr>
+        {{range .Keys}}
+        <tr><td>{{.Field}}</td><td>{{.Type}}</td><td>{{.Reason}}</td></tr>
+        {{end}}
+      </table>
+      {{range .Notes}}<p>* {{.}}</p>{{end}}
+      {{end}}{{end}}
+      {{if $r.WhatIf}}
+      <h3>What-If Evaluation on {{$r.WhatIf.Namespace}} of {{$r.WhatIf.Sampled}} Sampled Documents</h3>
+      <table>
+        <tr><th>Index</th><th>Plan</th><th>Score</th><th>Improvement</th><th>Keys Examined</th><th>Docs Examined</th></tr>
+        <tr><td>(current)</td><td>{{$r.WhatIf.Baseline.Plan}}{{$r.WhatIf.Baseline.Error}}</td><td>{{printf "%.4f" $r.WhatIf.Baseline.Score}}</td><td></td>
+          <td>{{$r.WhatIf.Baseline.Stats.TotalKeysExamined}}</td><td>{{$r.WhatIf.Baseline.Stats.TotalDocsExamined}}</td></tr>
+        {{range $r.WhatIf.Candidates}}
+        <tr><td>{{.Index}}</td><td>{{.Plan}}{{.Error}}</td><td>{{printf "%.4f" .Score}}</td><td>{{printf "%+.4f" .Improvement}}</td>
+          <td>{{.Stats.TotalKeysExamined}}</td><td>{{.Stats.TotalDocsExamined}}</td></tr>
+        {{end}}
+      </table>
+      {{if $r.WhatIf.WinningNew}}<p>The query planner selects a candidate index without hints.</p>{{end}}
+      {{end}}
+    </div>
+    {{end}}
+
+    <div class="timestamp">
+      <p>Report generated by Keyhole - MongoDB Cluster Analysis Tool</p>
+    </div>
+  </div>
+</body>
+</html>`
+
+
+const trendHTMLTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <title>Growth Trend - {{.Host}}</title>
+  <style>
+    body {
+      font-family: Arial, Helvetica, sans-serif;
+      margin: 20px;
+      background-color: #f5f5f5;
+    }
+    .container {
+      max-width: 1200px;
+      margin: 0 auto;
+      background-color: white;
+      padding: 20px;
+      border-radius: 8px;
+      box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+    }
+    h1, h2 {
+      color: #333;
+      border-bottom: 2px solid #4CAF50;
+      padding-bottom: 10px;
+    }
+    table {
+      font-family: Consolas, monaco, monospace;
+      border-collapse: collapse;
+      width: 100%;
+      margin: 10px 0;
+    }
+    th, td {
+      border: 1px solid #ddd;
+      padding: 8px;
+      text-align: left;
+    }
+    th {
+      background-color: #4CAF50;
+      color: white;
+      font-weight: bold;
+    }
+    tr:nth-child(even) {
+      background-color: #f2f2f2;
+    }
+    .section {
+      margin: 30px 0;
+    }
+    .timestamp {
+      color: #666;
+      font-size: 0.9em;
+      text-align: right;
+    }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <h1>Growth Trend - {{.Host}}</h1>
+    <div class="timestamp">Generated: {{getCurrentTime}} | Keyhole Version: {{getMongoVersion}}</div>
+    <p>{{len .Snapshots}} snapshots from {{formatTime .From}} to {{formatTime .To}}</p>
+
+    <div class="section">
+      <h2>Capacity Forecast</h2>
+      <table>
+        <tr><th>Resource</th><th>Capacity</th><th>Used</th><th>Growth per Day</th><th>Forecast</th></tr>
+        {{with .Disk}}<tr><td>Disk</td><td>{{formatBytes .Capacity}}</td><td>{{formatBytes .Used}}</td><td>{{formatBytes (int64 .PerDay)}}</td><td>{{daysLeft .DaysLeft}}</td></tr>{{else}}<tr><td>Disk</td><td colspan="4">capacity unknown</td></tr>{{end}}
+        {{with .Cache}}<tr><td>WiredTiger cache by indexes</td><td>{{formatBytes .Capacity}}</td><td>{{formatBytes .Used}}</td><td>{{formatBytes (int64 .PerDay)}}</td><td>{{daysLeft .DaysLeft}}</td></tr>{{else}}<tr><td>WiredTiger cache by indexes</td><td colspan="4">capacity unknown</td></tr>{{end}}
+      </table>
+    </div>
+{{define "growth"}}
+      <table>
+        <tr><th>Name</th><th>Data Size</th><th>Data per Day</th><th>Storage Size</th><th>Index Size</th><th>Documents</th></tr>
+        {{range .}}
+        <tr>
+          <td>{{.Name}}</td>
+          <td>{{formatBytes .DataSize.Last}} ({{pct .DataSize.Pct}})</td>
+          <td>{{formatBytes (int64 .DataSize.PerDay)}}</td>
+          <td>{{formatBytes .StorageSize.Last}} ({{pct .StorageSize.Pct}})</td>
+          <td>{{formatBytes .IndexSize.Last}} ({{pct .IndexSize.Pct}})</td>
+          <td>{{formatNumber .Count.Last}} ({{pct .Count.Pct}})</td>
+        </tr>
+        {{end}}
+      </table>
+{{end}}
+    <div class="section">
+      <h2>Fastest Growing Collections</h2>
+      {{template "growth" .Fastest}}
+    </div>
+
+    <div class="section">
+      <h2>Databases</h2>
+      {{template "growth" .Databases}}
+    </div>
+
+    <div class="section">
+      <h2>Collections</h2>
+      {{template "growth" .Collections}}
+    </div>
+  </div>
+</body>
+</html>`
+
+const shardingHTMLTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <title>Sharding Report - {{.Host}}</title>
+  <style>
+    body {
+      font-family: Arial, Helvetica, sans-serif;
+      margin: 20px;
+      background-color: #f5f5f5;
+    }
+    .container {
+      max-width: 1200px;
+      margin: 0 auto;
+      background-color: white;
+      padding: 20px;
+      border-radius: 8px;
+      box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+    }
+    h1, h2 {
+      color: #333;
+      border-bottom: 2px solid #4CAF50;
+      padding-bottom: 10px;
+    }
+    table {
+      font-family: Consolas, monaco, monospace;
+      border-collapse: collapse;
+      width: 100%;
+      margin: 10px 0;
+    }
+    th, td {
+      border: 1px solid #ddd;
+      padding: 8px;
+      text-align: left;
+    }
+    th {
+      background-color: #4CAF50;
+      color: white;
+      font-weight: bold;
+    }
+    tr:nth-child(even) {
+      background-color: #f2f2f2;
+    }
+    .section {
+      margin: 30px 0;
+    }
+    .timestamp {
+      color: #666;
+      font-size: 0.9em;
+      text-align: right;
+    }
+    .warning {
+      color: #d32f2f;
+    }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <h1>Sharding Report - {{.Host}}</h1>
+    <div class="timestamp">Generated: {{getCurrentTime}} | Keyhole Version: {{getMongoVersion}}</div>
+
+    <div class="section">
+      <h2>Balancer</h2>
+      {{with .Balancer}}
+      <table>
+        <tr><th>Mode</th><th>In Round</th><th>Active Window</th><th>Rounds</th><th>Round Errors</th><th>Chunks Moved</th><th>Migrations</th><th>Migration Errors</th><th>Last Round</th></tr>
+        <tr>
+          <td>{{.Mode}}</td>
+          <td>{{.InRound}}</td>
+          <td>{{if .ActiveWindow}}{{index .ActiveWindow "start"}} - {{index .ActiveWindow "stop"}}{{else}}always{{end}}</td>
+          <td>{{.Rounds}}</td>
+          <td>{{if .RoundErrors}}<span class="warning">{{.RoundErrors}}</span>{{else}}0{{end}}</td>
+          <td>{{formatNumber .ChunksMoved}}</td>
+          <td>{{formatNumber .Migrations}}</td>
+          <td>{{if .MigrationErrors}}<span class="warning">{{.MigrationErrors}}</span>{{else}}0{{end}}</td>
+          <td>{{formatTime .LastRound}}</td>
+        </tr>
+      </table>
+      <p>Activity of config.actionlog and config.changelog since {{formatTime .Since}}</p>
+      {{end}}
+    </div>
+
+    <div class="section">
+      <h2>Shards</h2>
+      <table>
+        <tr><th>Shard</th><th>Chunks</th><th>Jumbo</th><th>Data Size</th><th>Data %</th><th>Ops</th><th>Ops %</th></tr>
+        {{range .Shards}}
+        <tr>
+          <td>{{.Shard}}</td>
+          <td>{{formatNumber .Chunks}}</td>
+          <td>{{.Jumbo}}</td>
+          <td>{{formatBytes .DataSize}}</td>
+          <td>{{pct .DataPct}}</td>
+          <td>{{formatNumber .Ops}}</td>
+          <td>{{pct .OpsPct}}</td>
+        </tr>
+        {{end}}
+      </table>
+      {{if .HotShards}}<p class="warning">Hot shards: {{join .HotShards ", "}}</p>{{end}}
+    </div>
+
+    <div class="section">
+      <h2>Reshard Candidates</h2>
+      {{if .Reshard}}
+      <table>
+        <tr><th>Namespace</th><th>Shard Key</th><th>Reasons</th></tr>
+        {{range .Collections}}{{if .Reasons}}
+        <tr>
+          <td>{{.NS}}</td>
+          <td>{{.KeyString}}</td>
+          <td>{{range .Reasons}}{{.}}<br/>{{end}}</td>
+        </tr>
+        {{end}}{{end}}
+      </table>
+      {{else}}
+      <p>No reshard candidates</p>
+      {{end}}
+    </div>
+
+    <div class="section">
+      <h2>Sharded Collections</h2>
+      {{range .Collections}}
+      <h3>{{.NS}} {{.KeyString}}{{if .Unique}} unique{{end}}</h3>
+      <p>Chunk skew {{pct .ChunkSkewPct}}, data skew {{pct .DataSkewPct}}, {{.Jumbo}} jumbo chunks,
+        shard key prefix selectivity {{printf "%.4f" .Selectivity}} of {{formatNumber .SampledCount}} samples{{if .Monotonic}},
+        <span class="warning">monotonic: {{.Monotonic}}</span>{{end}}{{if .HotShard}}, <span class="warning">hot shard {{.HotShard}}</span>{{end}}</p>
+      <table>
+        <tr><th>Shard</th><th>Chunks</th><th>Jumbo</th><th>Data Size</th><th>Data %</th><th>Ops</th><th>Ops %</th></tr>
+        {{range .Distribution}}
+        <tr>
+          <td>{{.Shard}}</td>
+          <td>{{formatNumber .Chunks}}</td>
+          <td>{{.Jumbo}}</td>
+          <td>{{formatBytes .DataSize}}</td>
+          <td>{{pct .DataPct}}</td>
+          <td>{{formatNumber .Ops}}</td>
+          <td>{{pct .OpsPct}}</td>
+        </tr>
+        {{end}}
+      </table>
+      {{end}}
+    </div>
+  </div>
+</body>
+</html>`
@@ -0,0 +1,516 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// patterns examining more documents or keys per returned are considered for new indexes
+const minExaminedRatio = 10
+
+// IndexAdvisor proposes indexes from query patterns of logs and existing indexes
+type IndexAdvisor struct {
+	Logger    *gox.Logger     `bson:"keyhole"`
+	Proposals []IndexProposal `bson:"proposals"`
+
+	UnstablePlans []PlanCacheEntry `bson:"unstablePlans"` // unstable cached plans of query patterns
+
+	databases []Database
+	filename  string
+	patterns  []OpPattern
+	verbose   bool
+	version   string
+}
+
+// IndexProposal stores a proposed index and query patterns it serves
+type IndexProposal struct {
+	Collscans   int      `bson:"collscans"` // number of ops of COLLSCAN
+	Count       int      `bson:"count"`     // number of ops
+	Hashes      []string `bson:"hashes"`    // hashes of query patterns served
+	Impact      float64  `bson:"impact"`    // percentage of total time of all query patterns
+	Key         bson.D   `bson:"key"`
+	KeyString   string   `bson:"keyString"`
+	Namespace   string   `bson:"ns"`
+	Redundant   []string `bson:"redundant"` // existing indexes made redundant
+	TotalMilli  int64    `bson:"totalmilli"`
+	equalityLen int
+	sortLen     int
+}
+
+// NewIndexAdvisor returns IndexAdvisor
+func NewIndexAdvisor(version string) *IndexAdvisor {
+	return &IndexAdvisor{Logger: gox.GetLogger(version), version: version}
+}
+
+// SetVerbose sets verbose mode
+func (ia *IndexAdvisor) SetVerbose(verbose bool) {
+	ia.verbose = verbose
+	if verbose && ia.Logger != nil {
+		ia.Logger.SetLoggerLevel(gox.Debug)
+	}
+}
+
+// SetOpPatterns sets query patterns
+func (ia *IndexAdvisor) SetOpPatterns(patterns []OpPattern) {
+	ia.patterns = patterns
+}
+
+// SetDatabases sets databases of existing indexes
+func (ia *IndexAdvisor) SetDatabases(databases []Database) {
+	ia.databases = databases
+}
+
+// ReadFiles reads query patterns from a log file or a -log.bson.gz file and
+// existing indexes from a -index.bson.gz or a -stats.bson.gz file
+func (ia *IndexAdvisor) ReadFiles(filenames []string) error {
+	var err error
+	for _, filename := range filenames {
+		if strings.HasSuffix(filename, "-stats.bson.gz") {
+			var stats ClusterStats
+			if stats, err = ReadClusterStatsFile(filename); err != nil {
+				return err
+			}
+			ia.databases = append(ia.databases, *stats.Databases...)
+			continue
+		} else if strings.HasSuffix(filename, indexExt) {
+			var data []byte
+			var reader *bufio.Reader
+			if reader, err = gox.NewFileReader(filename); err != nil {
+				return err
+			}
+			if data, err = io.ReadAll(reader); err != nil {
+				return err
+			}
+			var doc struct {
+				Databases []Database `bson:"databases"`
+			}
+			if err = bson.Unmarshal(data, &doc); err != nil {
+				return err
+			}
+			ia.databases = append(ia.databases, doc.Databases...)
+			continue
+		}
+		li := NewLogInfo(ia.version)
+		li.SetSilent(true)
+		if err = li.AnalyzeFile(filename); err != nil {
+			return err
+		}
+		ia.patterns = append(ia.patterns, li.OpPatterns...)
+		if ia.filename == "" {
+			ia.filename = filename
+		}
+	}
+	if len(ia.patterns) == 0 {
+		return errors.New("no query patterns found")
+	}
+	return err
+}
+
+// Advise proposes a minimal set of indexes for query patterns
+func (ia *IndexAdvisor) Advise() []IndexProposal {
+	var totalMilli int64
+	for _, op := range ia.patterns {
+		totalMilli += op.TotalMilli
+	}
+	proposals := map[string][]IndexProposal{}
+	namespaces := []string{}
+	for _, op := range ia.patterns {
+		if op.Scan != COLLSCAN && op.DocsExaminedRatio() < minExaminedRatio && op.KeysExaminedRatio() < minExaminedRatio {
+			continue
+		}
+		filter, sortKeys, err := getQueryShapeFilter(op.Command, op.Filter)
+		if err != nil {
+			ia.Logger.Debugf("skip %v %v: %v", op.Namespace, op.Filter, err)
+			continue
+		}
+		for _, proposal := range getIndexProposals(filter, sortKeys) {
+			if name := ia.getCoveringIndex(op.Namespace, proposal); name != "" {
+				ia.Logger.Debugf("%v %v is covered by index %v", op.Namespace, op.Filter, name)
+				continue
+			}
+			proposal.Namespace = op.Namespace
+			proposal.Count = op.Count
+			proposal.Hashes = []string{op.Hash}
+			proposal.TotalMilli = op.TotalMilli
+			if op.Scan == COLLSCAN {
+				proposal.Collscans = op.Count
+			}
+			if _, ok := proposals[op.Namespace]; !ok {
+				namespaces = append(namespaces, op.Namespace)
+			}
+			proposals[op.Namespace] = addIndexProposal(proposals[op.Namespace], proposal)
+		}
+	}
+	ia.Proposals = []IndexProposal{}
+	for _, ns := range namespaces {
+		for _, proposal := range minimizeIndexProposals(proposals[ns]) {
+			if totalMilli > 0 {
+				proposal.Impact = 100 * float64(proposal.TotalMilli) / float64(totalMilli)
+			}
+			proposal.Redundant = ia.getRedundantIndexes(ns, proposal)
+			ia.Proposals = append(ia.Proposals, proposal)
+		}
+	}
+	sort.SliceStable(ia.Proposals, func(i, j int) bool {
+		return ia.Proposals[i].TotalMilli > ia.Proposals[j].TotalMilli
+	})
+	ia.UnstablePlans = ia.getUnstablePlans()
+	return ia.Proposals
+}
+
+// getUnstablePlans returns unstable cached plans correlated to query patterns
+func (ia *IndexAdvisor) getUnstablePlans() []PlanCacheEntry {
+	entries := []PlanCacheEntry{}
+	for _, db := range ia.databases {
+		for _, coll := range db.Collections {
+			if coll.PlanCache == nil {
+				continue
+			}
+			coll.PlanCache.Correlate(coll.NS, ia.patterns)
+			for _, entry := range coll.PlanCache.Entries {
+				if len(entry.Reasons) > 0 && len(entry.Patterns) > 0 {
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// regular expressions and lists in query shapes are not valid JSON
+var reShapeRegex = regexp.MustCompile(`:/(\^?)\.\.\./([a-z]*)`)
+var reSanitizedRegex = regexp.MustCompile(`^/(\^?)\.\.\./([a-z]*)$`)
+
+// sanitizeQueryShape converts a query shape to valid JSON, a list of a shape is of
+// multiple values
+func sanitizeQueryShape(shape string) string {
+	shape = strings.ReplaceAll(shape, "[...]", "[1,1]")
+	return reShapeRegex.ReplaceAllString(shape, `:"/$1.../$2"`)
+}
+
+// getQueryShapeFilter returns the filter and sort keys of a query shape
+func getQueryShapeFilter(command string, shape string) (bson.D, bson.D, error) {
+	var err error
+	var filter map[string]interface{}
+	var sortKeys bson.D
+	if command == cmdInsert || shape == "N/A" {
+		return nil, nil, errors.New("no filter")
+	}
+	shape = sanitizeQueryShape(shape)
+	if command == cmdAggregate {
+		var stages []map[string]json.RawMessage
+		if err = json.Unmarshal([]byte(shape), &stages); err != nil {
+			return nil, nil, err
+		}
+		if len(stages) == 0 || stages[0]["$match"] == nil {
+			return nil, nil, errors.New("no leading $match stage")
+		}
+		if err = json.Unmarshal(stages[0]["$match"], &filter); err != nil {
+			return nil, nil, err
+		}
+		if len(stages) > 1 && stages[1]["$sort"] != nil {
+			if sortKeys, err = decodeOrderedKeys(stages[1]["$sort"]); err != nil {
+				return nil, nil, err
+			}
+		}
+		doc, _ := toShapeDoc(filter).(bson.D)
+		return doc, sortKeys, nil
+	}
+	for _, label := range []string{", key: ", ", projection: "} {
+		if idx := strings.Index(shape, label); idx > 0 {
+			shape = shape[:idx]
+		}
+	}
+	if idx := strings.Index(shape, ", sort: "); idx > 0 {
+		if sortKeys, err = decodeOrderedKeys([]byte(shape[idx+len(", sort: "):])); err != nil {
+			return nil, nil, err
+		}
+		shape = shape[:idx]
+	}
+	if err = json.Unmarshal([]byte(shape), &filter); err != nil {
+		return nil, nil, err
+	}
+	doc, _ := toShapeDoc(filter).(bson.D)
+	return doc, sortKeys, nil
+}
+
+// toShapeDoc converts a decoded JSON query shape to bson, documents of sorted keys
+// as of query shapes, and /^.../ strings to regular expressions
+func toShapeDoc(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		doc := bson.D{}
+		for _, kv := range getKeyValues(v, true) {
+			doc = append(doc, bson.E{Key: kv.key, Value: toShapeDoc(kv.value)})
+		}
+		return doc
+	case []interface{}:
+		arr := primitive.A{}
+		for _, elem := range v {
+			arr = append(arr, toShapeDoc(elem))
+		}
+		return arr
+	case string:
+		if m := reSanitizedRegex.FindStringSubmatch(v); m != nil {
+			return primitive.Regex{Pattern: m[1] + "...", Options: m[2]}
+		}
+	}
+	return value
+}
+
+// decodeOrderedKeys decodes top level keys of a JSON document in order
+func decodeOrderedKeys(data []byte) (bson.D, error) {
+	var doc bson.D
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, errors.New("invalid document")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if err = decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		doc = append(doc, bson.E{Key: fmt.Sprint(token), Value: toShapeDoc(value)})
+	}
+	return doc, nil
+}
+
+// getIndexProposals returns index proposals of a filter, one per $or branch, of the
+// equality, sort, and range rules of GetIndexSuggestions
+func getIndexProposals(filter bson.D, sortKeys bson.D) []IndexProposal {
+	proposals := []IndexProposal{}
+	for _, suggestion := range GetIndexSuggestions(ExplainCommand{Filter: filter, Sort: sortKeys}, nil, nil) {
+		if len(suggestion.Key) == 0 || (len(suggestion.Key) == 1 && suggestion.Key[0].Key == "_id") { // served by the _id index
+			continue
+		}
+		proposal := IndexProposal{Key: suggestion.Key, KeyString: suggestion.KeyString}
+		for _, key := range suggestion.Keys {
+			if key.Type == keyEquality {
+				proposal.equalityLen++
+			} else if key.Type == keySort {
+				proposal.sortLen++
+			}
+		}
+		proposals = append(proposals, proposal)
+	}
+	return proposals
+}
+
+// getIndexKeyString returns an index key in the format of { a: 1, b: -1 }
+func getIndexKeyString(key bson.D) string {
+	strs := []string{}
+	for _, elem := range key {
+		strs = append(strs, fmt.Sprintf("%v: %v", elem.Key, elem.Value))
+	}
+	return "{ " + strings.Join(strs, ", ") + " }"
+}
+
+// addIndexProposal adds a proposal and merges with the one of the same key
+func addIndexProposal(proposals []IndexProposal, proposal IndexProposal) []IndexProposal {
+	for i := range proposals {
+		if proposals[i].KeyString == proposal.KeyString {
+			proposals[i].merge(proposal)
+			return proposals
+		}
+	}
+	return append(proposals, proposal)
+}
+
+func (p *IndexProposal) merge(other IndexProposal) {
+	p.Collscans += other.Collscans
+	p.Count += other.Count
+	p.TotalMilli += other.TotalMilli
+	for _, hash := range other.Hashes {
+		if !contains(p.Hashes, hash) {
+			p.Hashes = append(p.Hashes, hash)
+		}
+	}
+}
+
+// minimizeIndexProposals merges a proposal into another one that can serve
+// the same queries, longer proposals first
+func minimizeIndexProposals(proposals []IndexProposal) []IndexProposal {
+	sort.SliceStable(proposals, func(i, j int) bool {
+		return len(proposals[i].Key) > len(proposals[j].Key)
+	})
+	results := []IndexProposal{}
+	for _, proposal := range proposals {
+		merged := false
+		for i := range results {
+			if canServe(results[i].Key, proposal) {
+				results[i].merge(proposal)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			results = append(results, proposal)
+		}
+	}
+	return results
+}
+
+// canServe returns true if an index key can serve a proposal, i.e. equality
+// fields of the proposal lead the key in any order, followed by sort fields
+// in the same or reversed directions, and then range fields in any order
+func canServe(key bson.D, proposal IndexProposal) bool {
+	if len(key) < len(proposal.Key) {
+		return false
+	}
+	n := proposal.equalityLen
+	equalities := map[string]bool{}
+	for _, elem := range proposal.Key[:n] {
+		equalities[elem.Key] = true
+	}
+	for _, elem := range key[:n] {
+		if !equalities[elem.Key] {
+			return false
+		}
+	}
+	reversed := 0
+	for i := n; i < n+proposal.sortLen; i++ {
+		if key[i].Key != proposal.Key[i].Key {
+			return false
+		}
+		same := toInt64(key[i].Value) == toInt64(proposal.Key[i].Value)
+		if i == n && !same {
+			reversed = 1
+		} else if i > n && same == (reversed == 1) {
+			return false
+		}
+	}
+	ranges := map[string]bool{}
+	for _, elem := range proposal.Key[n+proposal.sortLen:] {
+		ranges[elem.Key] = true
+	}
+	for _, elem := range key[n+proposal.sortLen : len(proposal.Key)] {
+		if !ranges[elem.Key] {
+			return false
+		}
+	}
+	return true
+}
+
+// getCollectionIndexes returns existing indexes of a namespace
+func (ia *IndexAdvisor) getCollectionIndexes(ns string) []Index {
+	for _, db := range ia.databases {
+		for _, coll := range db.Collections {
+			if coll.NS == ns {
+				return coll.Indexes
+			}
+		}
+	}
+	return nil
+}
+
+// getCoveringIndex returns name of an existing index that can serve a proposal
+func (ia *IndexAdvisor) getCoveringIndex(ns string, proposal IndexProposal) string {
+	for _, index := range ia.getCollectionIndexes(ns) {
+		if len(index.PartialFilterExpression) == 0 && !index.Sparse && !index.Hidden && isRegularIndex(index.Key) &&
+			canServe(index.Key, proposal) {
+			return index.Name
+		}
+	}
+	return ""
+}
+
+// isRegularIndex returns false for text, 2dsphere, hashed, and other special indexes
+func isRegularIndex(key bson.D) bool {
+	return getIndexType(key) == indexTypeRegular
+}
+
+// getRedundantIndexes returns names of existing indexes whose keys are prefixes of a proposal
+func (ia *IndexAdvisor) getRedundantIndexes(ns string, proposal IndexProposal) []string {
+	names := []string{}
+	for _, index := range ia.getCollectionIndexes(ns) {
+		if index.Name == "_id_" || index.Unique || index.IsShardKey || index.ExpireAfterSeconds >= 0 ||
+			len(index.PartialFilterExpression) > 0 || index.Sparse || len(index.Collation) > 0 ||
+			len(index.Key) > len(proposal.Key) {
+			continue
+		}
+		isPrefix := true
+		for i, elem := range index.Key {
+			if elem.Key != proposal.Key[i].Key || fmt.Sprint(elem.Value) != fmt.Sprint(proposal.Key[i].Value) {
+				isPrefix = false
+				break
+			}
+		}
+		if isPrefix {
+			names = append(names, index.Name)
+		}
+	}
+	return names
+}
+
+// String returns proposals in text
+func (ia *IndexAdvisor) String() string {
+	var buffer bytes.Buffer
+	if len(ia.Proposals) == 0 {
+		buffer.WriteString("No index proposals\n")
+	} else {
+		buffer.WriteString(fmt.Sprintf("Index proposals (%d):\n", len(ia.Proposals)))
+	}
+	for _, proposal := range ia.Proposals {
+		db, coll := SplitNamespace(proposal.Namespace)
+		buffer.WriteString(fmt.Sprintf("%v: %v\n", proposal.Namespace, proposal.KeyString))
+		buffer.WriteString(fmt.Sprintf("  serves %d query patterns, %d ops, total %v (%.1f%% of all), %d ops of COLLSCAN\n",
+			len(proposal.Hashes), proposal.Count, milliToString(float64(proposal.TotalMilli)), proposal.Impact, proposal.Collscans))
+		if len(proposal.Redundant) > 0 {
+			buffer.WriteString(fmt.Sprintf("  makes redundant: %v\n", strings.Join(proposal.Redundant, ", ")))
+		}
+		key, _ := bson.MarshalExtJSON(proposal.Key, false, false)
+		buffer.WriteString(fmt.Sprintf("  db.getSiblingDB(%q).getCollection(%q).createIndex(%v)\n", db, coll, string(key)))
+	}
+	if len(ia.UnstablePlans) > 0 {
+		buffer.WriteString(fmt.Sprintf("Unstable cached plans of query patterns (%d):\n", len(ia.UnstablePlans)))
+	}
+	for _, entry := range ia.UnstablePlans {
+		buffer.WriteString(fmt.Sprintf("queryHash %v: %v\n", entry.QueryHash, entry.Plan))
+		for _, op := range ia.patterns {
+			if contains(entry.Patterns, op.Hash) {
+				buffer.WriteString(fmt.Sprintf("  %v %v %v, %d ops, total %v\n", op.Command, op.Namespace, op.Filter,
+					op.Count, milliToString(float64(op.TotalMilli))))
+			}
+		}
+		for _, reason := range entry.Reasons {
+			buffer.WriteString("  * " + reason + "\n")
+		}
+	}
+	return buffer.String()
+}
+
+// OutputJSON writes proposals to a JSON file
+func (ia *IndexAdvisor) OutputJSON() (string, error) {
+	var err error
+	var data []byte
+	if data, err = bson.MarshalExtJSON(ia, false, false); err != nil {
+		return "", err
+	}
+	os.Mkdir(outdir, 0755)
+	basename := filepath.Base(ia.filename)
+	basename = strings.TrimSuffix(strings.TrimSuffix(basename, logExt), ".gz")
+	ofile := fmt.Sprintf("%v/%v-advice.json", outdir, basename)
+	if err = os.WriteFile(ofile, data, 0644); err != nil {
+		return "", err
+	}
+	return ofile, err
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetQueryShapeFilter(t *testing.T) {
+	filter, sortKeys, err := getQueryShapeFilter(cmdFind,
+		`{"$or":[{"a":1},{"b":{"$in":[...]}}],"c":{"$gt":1},"name":/^.../i}, sort: {"d":-1,"e":1}, projection: {"_id":0}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter) != 3 || len(sortKeys) != 2 || sortKeys[0].Key != "d" {
+		t.Fatal("unexpected filter or sort", filter, sortKeys)
+	}
+	proposals := getIndexProposals(filter, sortKeys)
+	if len(proposals) != 2 || proposals[0].KeyString != "{ a: 1, d: -1, e: 1, c: 1, name: 1 }" {
+		t.Fatal("expected case-insensitive regex last", proposals)
+	}
+	if proposals[1].KeyString != "{ d: -1, e: 1, c: 1, b: 1, name: 1 }" || proposals[1].equalityLen != 0 || proposals[1].sortLen != 2 {
+		t.Fatal("expected $in after sort keys", proposals[1])
+	}
+	if filter, sortKeys, err = getQueryShapeFilter(cmdAggregate, `[{"$match":{"x":1,"y":{"$lt":1}}},{"$sort":{"z":1}},{"$limit":1}]`); err != nil {
+		t.Fatal(err)
+	}
+	if proposals = getIndexProposals(filter, sortKeys); len(proposals) != 1 || proposals[0].KeyString != "{ x: 1, z: 1, y: 1 }" {
+		t.Fatal(proposals)
+	}
+	if filter, sortKeys, err = getQueryShapeFilter(cmdFind, `{"a":{"$ne":1},"b":{"$gte":1},"c":{"$in":[...]}}`); err != nil {
+		t.Fatal(err)
+	}
+	if proposals = getIndexProposals(filter, sortKeys); len(proposals) != 1 || proposals[0].KeyString != "{ c: 1, b: 1, a: 1 }" {
+		t.Fatal("expected $in of equality without sort and $ne last", proposals)
+	}
+	if filter, _, _ = getQueryShapeFilter(cmdUpdate, `{"_id":1}`); len(getIndexProposals(filter, nil)) != 0 {
+		t.Fatal("expected no proposals of _id")
+	}
+}
+
+func TestIndexAdvisor(t *testing.T) {
+	patterns := []OpPattern{
+		{Command: cmdFind, Count: 100, Filter: `{"color":1,"year":{"$gte":1}}, sort: {"price":-1}`, Hash: "A",
+			Namespace: "keyhole.cars", Scan: COLLSCAN, TotalMilli: 30000},
+		{Command: cmdFind, Count: 50, Filter: `{"color":1,"price":1}`, Hash: "B", Namespace: "keyhole.cars",
+			TotalDocsExamined: 50000, TotalNReturned: 500, TotalMilli: 10000},
+		{Command: cmdFind, Count: 20, Filter: `{"brand":1}`, Hash: "C", Namespace: "keyhole.cars",
+			TotalDocsExamined: 200, TotalNReturned: 200, TotalMilli: 2000},
+		{Command: cmdUpdate, Count: 10, Filter: `{"_id":1}`, Hash: "D", Namespace: "keyhole.cars",
+			Scan: COLLSCAN, TotalMilli: 1000},
+		{Command: cmdFind, Count: 5, Filter: `{"dealer":1}`, Hash: "E", Namespace: "keyhole.dealers",
+			Scan: COLLSCAN, TotalMilli: 500},
+	}
+	databases := []Database{{Name: "keyhole", Collections: []Collection{
+		{NS: "keyhole.cars", Indexes: []Index{
+			{ExpireAfterSeconds: -1, Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+			{ExpireAfterSeconds: -1, Name: "color_1", Key: bson.D{{Key: "color", Value: int32(1)}}},
+		}},
+		{NS: "keyhole.dealers", Indexes: []Index{
+			{ExpireAfterSeconds: -1, Name: "dealer_1_city_1", Key: bson.D{{Key: "dealer", Value: int32(1)}, {Key: "city", Value: int32(1)}}},
+		}},
+	}}}
+	advisor := NewIndexAdvisor("utest-xxxxxx")
+	advisor.SetOpPatterns(patterns)
+	advisor.SetDatabases(databases)
+	proposals := advisor.Advise()
+	if len(proposals) != 1 {
+		t.Fatal("expected 1 proposal, but got", proposals)
+	}
+	proposal := proposals[0]
+	if proposal.KeyString != "{ color: 1, price: -1, year: 1 }" || proposal.Count != 150 || len(proposal.Hashes) != 2 {
+		t.Fatal("unexpected proposal", proposal)
+	}
+	if len(proposal.Redundant) != 1 || proposal.Redundant[0] != "color_1" {
+		t.Fatal("expected color_1 redundant", proposal.Redundant)
+	}
+	if proposal.Impact < 91 || proposal.Impact > 92 {
+		t.Fatal("unexpected impact", proposal.Impact)
+	}
+
+	// a TTL index expiring at dates of documents is never redundant
+	cars := &databases[0].Collections[0]
+	cars.Indexes[1].ExpireAfterSeconds = 0
+	if names := advisor.getRedundantIndexes("keyhole.cars", proposal); len(names) != 0 {
+		t.Fatal("expected TTL index not redundant", names)
+	}
+}
+
+func TestIndexAdvisorReadStatsFile(t *testing.T) {
+	stats := NewClusterStats("utest-xxxxxx")
+	stats.HostInfo.System.Hostname = "utest-advisor"
+	stats.Databases = &[]Database{{Name: "keyhole", Collections: []Collection{
+		{NS: "keyhole.cars", Indexes: []Index{
+			{ExpireAfterSeconds: -1, Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+			{ExpireAfterSeconds: -1, Name: "color_1", Key: bson.D{{Key: "color", Value: int32(1)}}}}},
+		{NS: "keyhole.dealers", Indexes: []Index{
+			{ExpireAfterSeconds: -1, Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}}}}}}}
+	ofile, _, err := stats.OutputBSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ofile)
+	advisor := NewIndexAdvisor("utest-xxxxxx")
+	if err = advisor.ReadFiles([]string{ofile}); err == nil || err.Error() != "no query patterns found" {
+		t.Fatal("expected no query patterns", err)
+	}
+	indexes := advisor.getCollectionIndexes("keyhole.cars")
+	if len(advisor.databases) != 1 || len(advisor.databases[0].Collections) != 2 || len(indexes) != 2 ||
+		indexes[1].Name != "color_1" || indexes[1].ExpireAfterSeconds != -1 {
+		t.Fatal("unexpected databases", advisor.databases)
+	}
+}
+
+func TestCanServe(t *testing.T) {
+	proposal := getIndexProposals(bson.D{{Key: "a", Value: 1.0}, {Key: "b", Value: 1.0}, {Key: "c", Value: bson.D{{Key: "$lt", Value: 1.0}}}},
+		bson.D{{Key: "s", Value: 1.0}, {Key: "t", Value: -1.0}})[0]
+	if !canServe(bson.D{{Key: "b", Value: 1}, {Key: "a", Value: -1}, {Key: "s", Value: -1}, {Key: "t", Value: 1}, {Key: "c", Value: 1}}, proposal) {
+		t.Fatal("expected reversed sort served")
+	}
+	if canServe(bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}, {Key: "s", Value: 1}, {Key: "t", Value: 1}, {Key: "c", Value: 1}}, proposal) {
+		t.Fatal("expected mixed sort directions not served")
+	}
+	if canServe(bson.D{{Key: "a", Value: 1}, {Key: "s", Value: 1}, {Key: "b", Value: 1}, {Key: "t", Value: -1}, {Key: "c", Value: 1}}, proposal) {
+		t.Fatal("expected equality fields to lead")
+	}
+}
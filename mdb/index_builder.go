@@ -0,0 +1,366 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+const (
+	defaultMaxLagSeconds = 10
+	defaultCommitQuorum  = "votingMembers"
+	buildStateExt        = "-build-state.json"
+)
+
+// status of an index build task
+const (
+	BuildDone    = "done"
+	BuildFailed  = "failed"
+	BuildPending = "pending"
+	BuildRunning = "building"
+)
+
+// IndexBuilder builds indexes one at a time, waits for all members to commit, and pauses
+// before the next build when replication lag exceeds a threshold
+type IndexBuilder struct {
+	Logger *gox.Logger
+
+	commitQuorum interface{}
+	dryRun       bool
+	interval     time.Duration
+	maxLag       int64
+	stateFile    string
+	verbose      bool
+	version      string
+}
+
+// IndexBuildState stores progress of index builds, to resume after a failure
+type IndexBuildState struct {
+	Tasks   []IndexBuildTask `bson:"tasks"`
+	Updated time.Time        `bson:"updated"`
+}
+
+// IndexBuildTask stores an index to build and its status
+type IndexBuildTask struct {
+	Error     string    `bson:"error,omitempty"`
+	Finished  time.Time `bson:"finished,omitempty"`
+	KeyString string    `bson:"keyString"`
+	Name      string    `bson:"name"`
+	Namespace string    `bson:"ns"`
+	Spec      bson.D    `bson:"spec"`
+	Started   time.Time `bson:"started,omitempty"`
+	Status    string    `bson:"status"`
+
+	collection Collection
+}
+
+// NewIndexBuilder returns IndexBuilder
+func NewIndexBuilder(version string) *IndexBuilder {
+	return &IndexBuilder{Logger: gox.GetLogger(version), commitQuorum: defaultCommitQuorum,
+		interval: 10 * time.Second, maxLag: defaultMaxLagSeconds, version: version}
+}
+
+// SetCommitQuorum sets commitQuorum of createIndexes, a number of members, majority, or votingMembers
+func (b *IndexBuilder) SetCommitQuorum(commitQuorum string) {
+	if commitQuorum == "" {
+		return
+	} else if n := ToInt(commitQuorum); fmt.Sprint(n) == commitQuorum {
+		b.commitQuorum = n
+	} else {
+		b.commitQuorum = commitQuorum
+	}
+}
+
+// SetDryRun sets to print builds without executing them
+func (b *IndexBuilder) SetDryRun(dryRun bool) {
+	b.dryRun = dryRun
+}
+
+// SetMaxLagSeconds sets replication lag in seconds to pause builds
+func (b *IndexBuilder) SetMaxLagSeconds(seconds int) {
+	if seconds > 0 {
+		b.maxLag = int64(seconds)
+	}
+}
+
+// SetStateFile sets file name of build state
+func (b *IndexBuilder) SetStateFile(stateFile string) {
+	b.stateFile = stateFile
+}
+
+// SetVerbose sets verbosity
+func (b *IndexBuilder) SetVerbose(verbose bool) {
+	b.verbose = verbose
+	if verbose && b.Logger != nil {
+		b.Logger.SetLoggerLevel(gox.Debug)
+	}
+}
+
+// BuildFromFile builds indexes defined in a -index.bson.gz or a -stats.bson.gz file, and keeps
+// build state in a file under the out directory by default
+func (b *IndexBuilder) BuildFromFile(client *mongo.Client, connString connstring.ConnString, filename string) error {
+	ix := NewIndexStats(b.version)
+	if err := ix.SetClusterDetailsFromFile(filename); err != nil {
+		return err
+	}
+	if b.stateFile == "" {
+		os.Mkdir(outdir, 0755)
+		basename := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filename), indexExt), "-stats.bson.gz")
+		b.stateFile = fmt.Sprintf("%v/%v%v", outdir, basename, buildStateExt)
+	}
+	return b.Build(client, connString, ix.Databases)
+}
+
+// Build builds indexes of databases one at a time, skips builds done of a previous run
+func (b *IndexBuilder) Build(client *mongo.Client, connString connstring.ConnString, databases []Database) error {
+	var err error
+	var replicas []*mongo.Client
+	state := b.getBuildState(databases)
+	if replicas, err = b.getReplicaClients(client, connString); err != nil {
+		return err
+	} else if len(replicas) == 0 { // commitQuorum is of replica sets only
+		b.commitQuorum = nil
+	}
+	defer func() {
+		for _, replica := range replicas {
+			if replica != client {
+				replica.Disconnect(context.Background())
+			}
+		}
+	}()
+	for i, task := range state.Tasks {
+		if task.Status == BuildDone {
+			b.Logger.Infof(`%v %v built, skipped`, task.Namespace, task.Name)
+			continue
+		}
+		if err = b.waitForLag(replicas); err != nil {
+			return err
+		}
+		cmd := b.getCreateIndexesCommand(task)
+		if b.dryRun {
+			data, _ := bson.MarshalExtJSON(cmd, false, false)
+			db, _ := SplitNamespace(task.Namespace)
+			fmt.Printf("db.getSiblingDB(%q).runCommand(%v)\n", db, string(data))
+			continue
+		}
+		state.Tasks[i].Status, state.Tasks[i].Started, state.Tasks[i].Error = BuildRunning, time.Now(), ""
+		b.saveBuildState(state)
+		if err = b.build(client, task, cmd); err != nil {
+			state.Tasks[i].Status, state.Tasks[i].Error = BuildFailed, err.Error()
+			b.saveBuildState(state)
+			return fmt.Errorf(`%v %v: %v, resume with state file %v`, task.Namespace, task.Name, err, b.stateFile)
+		}
+		state.Tasks[i].Status, state.Tasks[i].Finished = BuildDone, time.Now()
+		b.saveBuildState(state)
+		b.Logger.Infof(`%v %v built in %v`, task.Namespace, task.Name,
+			state.Tasks[i].Finished.Sub(state.Tasks[i].Started).Round(time.Second))
+	}
+	return nil
+}
+
+// getBuildState returns tasks of indexes, with status of a previous run from the state file
+func (b *IndexBuilder) getBuildState(databases []Database) IndexBuildState {
+	state := IndexBuildState{Tasks: []IndexBuildTask{}}
+	previous := map[string]string{}
+	if b.stateFile != "" && DoesFileExist(b.stateFile) {
+		var saved IndexBuildState
+		if data, err := os.ReadFile(b.stateFile); err != nil {
+			b.Logger.Warn(err)
+		} else if err = bson.UnmarshalExtJSON(data, false, &saved); err != nil {
+			b.Logger.Warn(err)
+		}
+		for _, task := range saved.Tasks {
+			previous[task.Namespace+"/"+task.Name] = task.Status
+		}
+	}
+	for _, db := range databases {
+		for _, coll := range db.Collections {
+			for _, o := range coll.Indexes {
+				if o.Name == "_id_" || o.Clustered {
+					continue
+				}
+				task := IndexBuildTask{KeyString: o.KeyString, Name: o.Name, Namespace: db.Name + "." + coll.Name,
+					Spec: o.GetIndexSpec(), Status: BuildPending, collection: coll}
+				if status, ok := previous[task.Namespace+"/"+task.Name]; ok && status == BuildDone {
+					task.Status = BuildDone
+				}
+				state.Tasks = append(state.Tasks, task)
+			}
+		}
+	}
+	return state
+}
+
+// saveBuildState writes build state to the state file
+func (b *IndexBuilder) saveBuildState(state IndexBuildState) {
+	if b.stateFile == "" {
+		return
+	}
+	state.Updated = time.Now()
+	data, err := bson.MarshalExtJSON(state, false, false)
+	if err == nil {
+		err = os.WriteFile(b.stateFile, data, 0644)
+	}
+	if err != nil {
+		b.Logger.Error(err)
+	}
+}
+
+// getCreateIndexesCommand returns createIndexes command of an index with commitQuorum
+func (b *IndexBuilder) getCreateIndexesCommand(task IndexBuildTask) bson.D {
+	_, coll := SplitNamespace(task.Namespace)
+	cmd := bson.D{{Key: "createIndexes", Value: coll}, {Key: "indexes", Value: bson.A{task.Spec}}}
+	if b.commitQuorum != nil {
+		cmd = append(cmd, bson.E{Key: "commitQuorum", Value: b.commitQuorum})
+	}
+	return cmd
+}
+
+// build runs createIndexes and reports progress of the build from $currentOp until it returns
+func (b *IndexBuilder) build(client *mongo.Client, task IndexBuildTask, cmd bson.D) error {
+	db, coll := SplitNamespace(task.Namespace)
+	if err := createCollectionOf(client, db, coll, task.collection); err != nil {
+		return err
+	}
+	b.Logger.Infof(`building %v %v %v`, task.Namespace, task.Name, task.KeyString)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Database(db).RunCommand(context.Background(), cmd).Err()
+	}()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if progress, err := getIndexBuildProgress(client, task.Namespace); err != nil {
+				b.Logger.Debug(err)
+			} else if progress != "" {
+				b.Logger.Infof(`building %v %v, %v`, task.Namespace, task.Name, progress)
+			}
+		}
+	}
+}
+
+// getIndexBuildProgress returns progress of index builds of a namespace from $currentOp
+func getIndexBuildProgress(client *mongo.Client, ns string) (string, error) {
+	var err error
+	var cur *mongo.Cursor
+	ctx := context.Background()
+	_, coll := SplitNamespace(ns)
+	pipeline := mongo.Pipeline{
+		{{Key: "$currentOp", Value: bson.D{{Key: "allUsers", Value: true}}}},
+		{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "ns", Value: ns}}, bson.D{{Key: "command.createIndexes", Value: coll}}}}}}},
+	}
+	if cur, err = client.Database("admin").Aggregate(ctx, pipeline); err != nil {
+		return "", err
+	}
+	defer cur.Close(ctx)
+	strs := []string{}
+	for cur.Next(ctx) {
+		var op struct {
+			Msg      string `bson:"msg"`
+			Progress struct {
+				Done  int64 `bson:"done"`
+				Total int64 `bson:"total"`
+			} `bson:"progress"`
+			Shard string `bson:"shard"`
+		}
+		if err = cur.Decode(&op); err != nil || op.Progress.Total == 0 {
+			continue
+		}
+		str := fmt.Sprintf("%v %d/%d (%.1f%%)", op.Msg, op.Progress.Done, op.Progress.Total,
+			100*float64(op.Progress.Done)/float64(op.Progress.Total))
+		if op.Shard != "" {
+			str = op.Shard + ": " + str
+		}
+		strs = append(strs, str)
+	}
+	return strings.Join(strs, ", "), nil
+}
+
+// getReplicaClients returns clients of replica sets, the client itself or of all shards
+func (b *IndexBuilder) getReplicaClients(client *mongo.Client, connString connstring.ConnString) ([]*mongo.Client, error) {
+	var err error
+	var serverStatus ServerStatus
+	if serverStatus, err = GetServerStatus(client); err != nil {
+		return nil, err
+	}
+	cluster := GetClusterType(serverStatus)
+	if cluster == Standalone {
+		return nil, nil
+	} else if cluster == Replica {
+		return []*mongo.Client{client}, nil
+	}
+	var shards []Shard
+	var uris []string
+	if shards, err = GetShards(client); err != nil {
+		return nil, err
+	}
+	if uris, err = GetAllShardURIs(shards, connString); err != nil {
+		return nil, err
+	}
+	clients := []*mongo.Client{}
+	for _, uri := range uris {
+		var replica *mongo.Client
+		if replica, err = NewMongoClient(uri); err != nil {
+			return clients, err
+		}
+		clients = append(clients, replica)
+	}
+	return clients, nil
+}
+
+// waitForLag waits until replication lag of all replica sets is within the threshold
+func (b *IndexBuilder) waitForLag(replicas []*mongo.Client) error {
+	for {
+		var maxLag int64
+		var setName string
+		for _, replica := range replicas {
+			status, err := GetReplSetGetStatus(replica)
+			if err != nil {
+				return err
+			}
+			if lag := getReplicationLag(status); lag > maxLag {
+				maxLag, setName = lag, status.Set
+			}
+		}
+		if maxLag <= b.maxLag {
+			return nil
+		} else if b.dryRun {
+			b.Logger.Infof(`would pause, replication lag of %v is %d seconds, above %d seconds`, setName, maxLag, b.maxLag)
+			return nil
+		}
+		b.Logger.Infof(`paused, replication lag of %v is %d seconds, above %d seconds`, setName, maxLag, b.maxLag)
+		time.Sleep(b.interval)
+	}
+}
+
+// getReplicationLag returns the max lag in seconds of secondaries from the primary
+func getReplicationLag(status ReplSetGetStatus) int64 {
+	var primary uint32
+	for _, member := range status.Members {
+		if member.State == 1 {
+			primary = member.Optime.TS.T
+		}
+	}
+	var lag int64
+	for _, member := range status.Members {
+		if member.State == 2 && primary > member.Optime.TS.T && int64(primary-member.Optime.TS.T) > lag {
+			lag = int64(primary - member.Optime.TS.T)
+		}
+	}
+	return lag
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetReplicationLag(t *testing.T) {
+	str := `{"set": "rs0", "members": [
+		{"_id": 0, "name": "host1:27017", "state": 1, "optime": {"ts": {"$timestamp": {"t": 1614592800, "i": 1}}}},
+		{"_id": 1, "name": "host2:27017", "state": 2, "optime": {"ts": {"$timestamp": {"t": 1614592785, "i": 1}}}},
+		{"_id": 2, "name": "host3:27017", "state": 2, "optime": {"ts": {"$timestamp": {"t": 1614592798, "i": 1}}}},
+		{"_id": 3, "name": "host4:27017", "state": 8, "optime": {"ts": {"$timestamp": {"t": 1614590000, "i": 1}}}}]}`
+	var status ReplSetGetStatus
+	if err := bson.UnmarshalExtJSON([]byte(str), false, &status); err != nil {
+		t.Fatal(err)
+	}
+	if lag := getReplicationLag(status); lag != 15 {
+		t.Fatal("expected lag of 15 seconds, but", lag)
+	}
+}
+
+func TestGetBuildState(t *testing.T) {
+	databases := []Database{{Name: "keyhole", Collections: []Collection{{Name: "cars", Indexes: []Index{
+		{ExpireAfterSeconds: -1, Key: bson.D{{Key: "_id", Value: 1}}, Name: "_id_"},
+		{ExpireAfterSeconds: -1, Key: bson.D{{Key: "color", Value: 1}}, Name: "color_1"},
+		{ExpireAfterSeconds: -1, Key: bson.D{{Key: "year", Value: -1}}, Name: "year_-1", Hidden: true}}}}}}
+	b := NewIndexBuilder("utest-xxxxxx")
+	b.SetStateFile(filepath.Join(t.TempDir(), "keyhole"+buildStateExt))
+	state := b.getBuildState(databases)
+	if len(state.Tasks) != 2 || state.Tasks[0].Status != BuildPending {
+		t.Fatal("unexpected tasks", state.Tasks)
+	}
+	state.Tasks[0].Status = BuildDone
+	state.Tasks[1].Status, state.Tasks[1].Error = BuildFailed, "interrupted"
+	b.saveBuildState(state)
+	if _, err := os.Stat(b.stateFile); err != nil {
+		t.Fatal(err)
+	}
+	state = b.getBuildState(databases)
+	if state.Tasks[0].Status != BuildDone || state.Tasks[1].Status != BuildPending {
+		t.Fatal("expected to resume from failed builds", state.Tasks)
+	}
+
+	b.SetCommitQuorum("2")
+	cmd := b.getCreateIndexesCommand(state.Tasks[1]).Map()
+	spec := cmd["indexes"].(bson.A)[0].(bson.D).Map()
+	if cmd["createIndexes"] != "cars" || cmd["commitQuorum"] != 2 || spec["hidden"] != true {
+		t.Fatal("unexpected command", cmd)
+	}
+	b.SetCommitQuorum("majority")
+	if cmd = b.getCreateIndexesCommand(state.Tasks[1]).Map(); cmd["commitQuorum"] != "majority" {
+		t.Fatal("unexpected commitQuorum", cmd)
+	}
+}
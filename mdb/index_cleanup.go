@@ -0,0 +1,269 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// classes of indexes of a cleanup review
+const (
+	IndexDuplicate = "duplicate" // same keys of another index with different options
+	IndexInUse     = "inUse"
+	IndexProtected = "protected" // _id, TTL, unique, or shard key
+	IndexRedundant = "redundant" // a prefix of another index
+	IndexUnused    = "unused"
+)
+
+const cleanupExt = "-index-cleanup"
+
+// IndexReview stores the cleanup class of an index
+type IndexReview struct {
+	Class     string    `json:"class" bson:"class"`
+	CoveredBy string    `json:"coveredBy,omitempty" bson:"coveredBy,omitempty"` // index serving the same queries
+	Hidden    bool      `json:"hidden" bson:"hidden"`
+	Hosts     int       `json:"hosts" bson:"hosts"` // number of hosts of $indexStats
+	KeyString string    `json:"keyString" bson:"keyString"`
+	Name      string    `json:"name" bson:"name"`
+	Namespace string    `json:"ns" bson:"ns"`
+	Ops       int       `json:"ops" bson:"ops"`
+	Reason    string    `json:"reason" bson:"reason"`
+	Since     time.Time `json:"since" bson:"since"` // ops counted since, the latest restart of all hosts
+}
+
+// IsDroppable returns true if an index can be hidden and then dropped
+func (r IndexReview) IsDroppable() bool {
+	return r.Class == IndexDuplicate || r.Class == IndexRedundant || r.Class == IndexUnused
+}
+
+// GetIndexReviews classifies indexes of all collections for cleanup
+func (ix *IndexStats) GetIndexReviews() []IndexReview {
+	reviews := []IndexReview{}
+	for _, db := range ix.Databases {
+		for _, coll := range db.Collections {
+			for _, o := range coll.Indexes {
+				reviews = append(reviews, getIndexReview(coll.NS, o, coll.Indexes))
+			}
+		}
+	}
+	return reviews
+}
+
+// getIndexReview returns the cleanup class of an index among indexes of a collection
+func getIndexReview(ns string, o Index, list []Index) IndexReview {
+	review := IndexReview{Class: IndexInUse, Hidden: o.Hidden, Hosts: len(o.Usage), KeyString: o.KeyString,
+		Name: o.Name, Namespace: ns, Ops: o.TotalOps}
+	for _, u := range o.Usage {
+		if u.Accesses.Since.After(review.Since) {
+			review.Since = u.Accesses.Since
+		}
+	}
+	if o.Name == "_id_" {
+		review.Class, review.Reason = IndexProtected, "_id index"
+		return review
+	} else if o.IsShardKey {
+		review.Class, review.Reason = IndexProtected, "shard key"
+		return review
+	} else if o.ExpireAfterSeconds >= 0 {
+		review.Class, review.Reason = IndexProtected, fmt.Sprintf("TTL index of %d seconds", o.ExpireAfterSeconds)
+		return review
+	} else if o.Unique {
+		review.Class, review.Reason = IndexProtected, "unique constraint"
+		return review
+	}
+	for _, other := range list {
+		if other.Name == o.Name || !isSameIndexKey(o.Key, other.Key) {
+			continue
+		}
+		// keeps the index of more ops, or the first by name of a tie
+		if other.TotalOps > o.TotalOps || (other.TotalOps == o.TotalOps && other.Name < o.Name) ||
+			other.IsShardKey || other.Unique || other.ExpireAfterSeconds >= 0 {
+			review.Class, review.CoveredBy = IndexDuplicate, other.Name
+			review.Reason = "same keys with different options: " + strings.Join(getIndexOptionsDiff(o, other), ", ")
+			return review
+		}
+	}
+	for _, other := range list {
+		if other.Name != o.Name && isIndexPrefixOf(o, other) {
+			review.Class, review.CoveredBy = IndexRedundant, other.Name
+			review.Reason = "prefix of " + other.KeyString
+			return review
+		}
+	}
+	if len(o.Usage) > 0 && o.TotalOps == 0 {
+		review.Class = IndexUnused
+		review.Reason = fmt.Sprintf("no ops on %d hosts since %v", len(o.Usage), review.Since.Format(time.RFC3339))
+	}
+	return review
+}
+
+// isSameIndexKey returns true if keys are the same in order and directions
+func isSameIndexKey(a bson.D, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || fmt.Sprint(a[i].Value) != fmt.Sprint(b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// isIndexPrefixOf returns true if keys of an index are a leading part of another index, and
+// the other index serves all queries of the index
+func isIndexPrefixOf(o Index, other Index) bool {
+	if len(o.Key) >= len(other.Key) || !isRegularIndex(o.Key) || !isRegularIndex(other.Key) {
+		return false
+	}
+	if other.Hidden || len(other.PartialFilterExpression) > 0 || (other.Sparse && !o.Sparse) ||
+		fmt.Sprint(o.Collation) != fmt.Sprint(other.Collation) {
+		return false
+	}
+	reversed := fmt.Sprint(o.Key[0].Value) != fmt.Sprint(other.Key[0].Value)
+	for i, elem := range o.Key {
+		if elem.Key != other.Key[i].Key {
+			return false
+		}
+		if sameDirection := fmt.Sprint(elem.Value) == fmt.Sprint(other.Key[i].Value); sameDirection == reversed {
+			return false
+		}
+	}
+	return true
+}
+
+// getIndexOptionsDiff returns options different between two indexes
+func getIndexOptionsDiff(o Index, other Index) []string {
+	diffs := []string{}
+	if fmt.Sprint(o.Collation) != fmt.Sprint(other.Collation) {
+		diffs = append(diffs, "collation")
+	}
+	if fmt.Sprint(o.PartialFilterExpression) != fmt.Sprint(other.PartialFilterExpression) {
+		diffs = append(diffs, "partialFilterExpression")
+	}
+	if o.Sparse != other.Sparse {
+		diffs = append(diffs, "sparse")
+	}
+	if o.Unique != other.Unique {
+		diffs = append(diffs, "unique")
+	}
+	if o.ExpireAfterSeconds != other.ExpireAfterSeconds {
+		diffs = append(diffs, "expireAfterSeconds")
+	}
+	if len(diffs) == 0 {
+		diffs = append(diffs, "name")
+	}
+	return diffs
+}
+
+// PrintIndexReviews prints indexes to be cleaned up
+func (ix *IndexStats) PrintIndexReviews(reviews []IndexReview) {
+	var buffer bytes.Buffer
+	buffer.WriteString("\nIndex cleanup review:\n")
+	counts := map[string]int{}
+	for _, r := range reviews {
+		counts[r.Class]++
+		if !r.IsDroppable() {
+			continue
+		}
+		hidden := ""
+		if r.Hidden {
+			hidden = " (hidden)"
+		}
+		buffer.WriteString(fmt.Sprintf("%-9v %v %v %v%v, %d ops, %v\n", r.Class, r.Namespace, r.Name, r.KeyString,
+			hidden, r.Ops, r.Reason))
+	}
+	classes := []string{IndexUnused, IndexRedundant, IndexDuplicate, IndexProtected, IndexInUse}
+	strs := []string{}
+	for _, class := range classes {
+		strs = append(strs, fmt.Sprintf("%v: %d", class, counts[class]))
+	}
+	buffer.WriteString(strings.Join(strs, ", "))
+	fmt.Println(buffer.String())
+}
+
+// GetIndexCleanupScript returns a JavaScript of mongo shell to hide indexes before dropping them
+func GetIndexCleanupScript(reviews []IndexReview) string {
+	droppable := []IndexReview{}
+	for _, r := range reviews {
+		if r.IsDroppable() {
+			droppable = append(droppable, r)
+		}
+	}
+	sort.SliceStable(droppable, func(i, j int) bool { return droppable[i].Namespace < droppable[j].Namespace })
+	var hide, unhide, drop bytes.Buffer
+	for _, r := range droppable {
+		db, coll := SplitNamespace(r.Namespace)
+		target := fmt.Sprintf("db.getSiblingDB(%q).getCollection(%q)", db, coll)
+		comment := fmt.Sprintf("// %v %v, %d ops, %v\n", r.Class, r.KeyString, r.Ops, r.Reason)
+		hide.WriteString(comment)
+		if r.Hidden {
+			hide.WriteString("// already hidden\n")
+		} else {
+			hide.WriteString(fmt.Sprintf("%v.hideIndex(%q);\n", target, r.Name))
+		}
+		unhide.WriteString(fmt.Sprintf("// %v.unhideIndex(%q);\n", target, r.Name))
+		drop.WriteString(fmt.Sprintf("// %v.dropIndex(%q);\n", target, r.Name))
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString("// Index cleanup script, review before running, requires 4.4+ to hide indexes\n")
+	buffer.WriteString(fmt.Sprintf("// %d indexes to clean up\n\n", len(droppable)))
+	buffer.WriteString("// step 1: hide indexes and monitor performance\n")
+	buffer.WriteString(hide.String())
+	buffer.WriteString("\n// to revert, unhide indexes\n")
+	buffer.WriteString(unhide.String())
+	buffer.WriteString("\n// step 2: drop hidden indexes after no regression is observed\n")
+	buffer.WriteString(drop.String())
+	return buffer.String()
+}
+
+// getIndexCleanupCommands returns collMod commands to hide or unhide indexes, and dropIndexes commands
+func getIndexCleanupCommands(reviews []IndexReview) (bson.A, bson.A, bson.A) {
+	hide, unhide, drop := bson.A{}, bson.A{}, bson.A{}
+	for _, r := range reviews {
+		if !r.IsDroppable() {
+			continue
+		}
+		db, coll := SplitNamespace(r.Namespace)
+		if !r.Hidden {
+			hide = append(hide, bson.D{{Key: "db", Value: db}, {Key: "command", Value: bson.D{{Key: "collMod", Value: coll},
+				{Key: "index", Value: bson.D{{Key: "name", Value: r.Name}, {Key: "hidden", Value: true}}}}}})
+		}
+		unhide = append(unhide, bson.D{{Key: "db", Value: db}, {Key: "command", Value: bson.D{{Key: "collMod", Value: coll},
+			{Key: "index", Value: bson.D{{Key: "name", Value: r.Name}, {Key: "hidden", Value: false}}}}}})
+		drop = append(drop, bson.D{{Key: "db", Value: db}, {Key: "command", Value: bson.D{{Key: "dropIndexes", Value: coll},
+			{Key: "index", Value: r.Name}}}})
+	}
+	return hide, unhide, drop
+}
+
+// OutputIndexCleanup writes index reviews with commands and a cleanup script to files
+func (ix *IndexStats) OutputIndexCleanup(reviews []IndexReview) ([]string, error) {
+	var err error
+	var data []byte
+	hide, unhide, drop := getIndexCleanupCommands(reviews)
+	doc := bson.D{{Key: "reviews", Value: reviews}, {Key: "hide", Value: hide}, {Key: "unhide", Value: unhide},
+		{Key: "drop", Value: drop}}
+	if data, err = bson.MarshalExtJSON(doc, false, false); err != nil {
+		return nil, err
+	}
+	os.Mkdir(outdir, 0755)
+	basename := strings.TrimSuffix(filepath.Base(ix.filename), indexExt)
+	ofiles := []string{fmt.Sprintf("%v/%v%v.json", outdir, basename, cleanupExt),
+		fmt.Sprintf("%v/%v%v.js", outdir, basename, cleanupExt)}
+	if err = os.WriteFile(ofiles[0], data, 0644); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(ofiles[1], []byte(GetIndexCleanupScript(reviews)), 0644); err != nil {
+		return nil, err
+	}
+	return ofiles, err
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetIndexReviews(t *testing.T) {
+	since := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	usage := func(ops ...int) []IndexUsage {
+		list := []IndexUsage{}
+		for i, n := range ops {
+			list = append(list, IndexUsage{Accesses: Accesses{Ops: n, Since: since.Add(time.Duration(i) * time.Hour)}})
+		}
+		return list
+	}
+	index := func(name string, key bson.D, ops ...int) Index {
+		o := Index{ExpireAfterSeconds: -1, Key: key, Name: name, KeyString: getIndexKeyString(key), Usage: usage(ops...)}
+		for _, n := range ops {
+			o.TotalOps += n
+		}
+		return o
+	}
+	indexes := []Index{
+		index("_id_", bson.D{{Key: "_id", Value: 1}}, 0, 0),
+		index("color_1", bson.D{{Key: "color", Value: 1}}, 5, 3),
+		index("color_1_year_-1", bson.D{{Key: "color", Value: 1}, {Key: "year", Value: -1}}, 10, 2),
+		index("color_-1_year_1_brand_1", bson.D{{Key: "color", Value: -1}, {Key: "year", Value: 1}, {Key: "brand", Value: 1}}, 1, 0),
+		index("brand_1", bson.D{{Key: "brand", Value: 1}}, 0, 0),
+		index("brand_1_fr", bson.D{{Key: "brand", Value: 1}}, 4, 0),
+		index("created_1", bson.D{{Key: "created", Value: 1}}, 0, 0),
+		index("vin_1", bson.D{{Key: "vin", Value: 1}}, 0, 0),
+		index("style_1", bson.D{{Key: "style", Value: 1}}, 0, 0),
+	}
+	indexes[5].Collation = bson.D{{Key: "locale", Value: "fr"}}
+	indexes[6].ExpireAfterSeconds = 3600
+	indexes[7].Unique = true
+	indexes[8].Hidden = true
+	ix := NewIndexStats("utest-xxxxxx")
+	ix.Databases = []Database{{Name: "keyhole", Collections: []Collection{{NS: "keyhole.cars", Name: "cars", Indexes: indexes}}}}
+	reviews := ix.GetIndexReviews()
+	expected := map[string]string{"_id_": IndexProtected, "color_1": IndexRedundant, "color_1_year_-1": IndexRedundant,
+		"color_-1_year_1_brand_1": IndexInUse, "brand_1": IndexDuplicate, "brand_1_fr": IndexInUse,
+		"created_1": IndexProtected, "vin_1": IndexProtected, "style_1": IndexUnused}
+	for _, r := range reviews {
+		if expected[r.Name] != r.Class {
+			t.Fatal("expected", expected[r.Name], "of", r.Name, "but", r.Class, r.Reason)
+		}
+	}
+	if reviews[4].CoveredBy != "brand_1_fr" || !strings.Contains(reviews[4].Reason, "collation") {
+		t.Fatal("unexpected duplicate", reviews[4])
+	}
+	if reviews[8].Since != since.Add(time.Hour) || reviews[8].Hosts != 2 {
+		t.Fatal("expected unused since the latest of hosts", reviews[8])
+	}
+
+	script := GetIndexCleanupScript(reviews)
+	if strings.Count(script, ".hideIndex(") != 3 || strings.Count(script, ".dropIndex(") != 4 ||
+		!strings.Contains(script, `db.getSiblingDB("keyhole").getCollection("cars").hideIndex("color_1");`) {
+		t.Fatal("unexpected script", script)
+	}
+	hide, unhide, drop := getIndexCleanupCommands(reviews)
+	if len(hide) != 3 || len(unhide) != 4 || len(drop) != 4 {
+		t.Fatal("unexpected commands", hide, unhide, drop)
+	}
+	t.Log(script)
+}
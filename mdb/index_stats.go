@@ -19,7 +19,6 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // IndexStats holder indexes reader struct
@@ -53,6 +52,7 @@ type Index struct {
 	Background              bool   `json:"background" bson:"background"`
 	Collation               bson.D `json:"collation" bson:"collation"`
 	ExpireAfterSeconds      int32  `json:"expireAfterSeconds" bson:"expireAfterSeconds,truncate,omitempty"`
+	Hidden                  bool   `json:"hidden" bson:"hidden,omitempty"`
 	Key                     bson.D `json:"key" bson:"key"`
 	Name                    string `json:"name" bson:"name,truncate"`
 	PartialFilterExpression bson.D `json:"partialFilterExpression" bson:"partialFilterExpression"`
@@ -61,6 +61,19 @@ type Index struct {
 	Version                 int32  `json:"v" bson:"v,truncate"`
 	Weights                 bson.D `json:"weights" bson:"weights"`
 
+	Bits                  int32    `json:"bits,omitempty" bson:"bits,truncate,omitempty"`                          // 2d
+	Clustered             bool     `json:"clustered,omitempty" bson:"clustered,omitempty"`                         // clustered collection, 5.3+
+	ColumnstoreProjection bson.D   `json:"columnstoreProjection,omitempty" bson:"columnstoreProjection,omitempty"` // columnstore, 6.3+
+	DefaultLanguage       string   `json:"default_language,omitempty" bson:"default_language,omitempty"`           // text
+	LanguageOverride      string   `json:"language_override,omitempty" bson:"language_override,omitempty"`         // text
+	Max                   *float64 `json:"max,omitempty" bson:"max,omitempty"`                                     // 2d
+	Min                   *float64 `json:"min,omitempty" bson:"min,omitempty"`                                     // 2d
+	PrepareUnique         bool     `json:"prepareUnique,omitempty" bson:"prepareUnique,omitempty"`                 // 6.0+
+	SphereIndexVersion    int32    `json:"2dsphereIndexVersion,omitempty" bson:"2dsphereIndexVersion,truncate,omitempty"`
+	StorageEngine         bson.D   `json:"storageEngine,omitempty" bson:"storageEngine,omitempty"`
+	TextIndexVersion      int32    `json:"textIndexVersion,omitempty" bson:"textIndexVersion,truncate,omitempty"`
+	WildcardProjection    bson.D   `json:"wildcardProjection,omitempty" bson:"wildcardProjection,omitempty"` // wildcard, 4.2+
+
 	EffectiveKey string       `json:"effectiveKey" bson:"effectiveKey"`
 	Fields       []string     `json:"fields" bson:"fields"`
 	IsDupped     bool         `json:"isDupped" bson:"isDupped"`
@@ -70,6 +83,69 @@ type Index struct {
 	Usage        []IndexUsage `json:"usage" bson:"usage"`
 }
 
+// index types by key values
+const (
+	indexType2d          = "2d"
+	indexType2dsphere    = "2dsphere"
+	indexTypeColumnstore = "columnstore"
+	indexTypeHashed      = "hashed"
+	indexTypeRegular     = "regular"
+	indexTypeText        = "text"
+	indexTypeWildcard    = "wildcard"
+)
+
+// getIndexType returns the type of an index, or of the first special key of a compound index
+func getIndexType(key bson.D) string {
+	for _, elem := range key {
+		if elem.Key == "$**" || strings.HasSuffix(elem.Key, ".$**") {
+			if elem.Value == indexTypeColumnstore {
+				return indexTypeColumnstore
+			}
+			return indexTypeWildcard
+		}
+		if str, ok := elem.Value.(string); ok {
+			return str // text, 2d, 2dsphere, hashed, or geoHaystack
+		}
+	}
+	return indexTypeRegular
+}
+
+// GetIndexSpec returns the specification of an index of createIndexes command
+func (o Index) GetIndexSpec() bson.D {
+	spec := bson.D{{Key: "key", Value: o.Key}, {Key: "name", Value: o.Name}}
+	opts := []struct {
+		key   string
+		value interface{}
+		isSet bool
+	}{
+		{"background", o.Background, o.Background},
+		{"unique", o.Unique, o.Unique},
+		{"sparse", o.Sparse, o.Sparse},
+		{"hidden", o.Hidden, o.Hidden},
+		{"prepareUnique", o.PrepareUnique, o.PrepareUnique},
+		{"expireAfterSeconds", o.ExpireAfterSeconds, o.ExpireAfterSeconds >= 0},
+		{"partialFilterExpression", o.PartialFilterExpression, len(o.PartialFilterExpression) > 0},
+		{"collation", o.Collation, len(o.Collation) > 0},
+		{"weights", o.Weights, len(o.Weights) > 0},
+		{"default_language", o.DefaultLanguage, o.DefaultLanguage != ""},
+		{"language_override", o.LanguageOverride, o.LanguageOverride != ""},
+		{"textIndexVersion", o.TextIndexVersion, o.TextIndexVersion > 0},
+		{"2dsphereIndexVersion", o.SphereIndexVersion, o.SphereIndexVersion > 0},
+		{"bits", o.Bits, o.Bits > 0},
+		{"min", o.Min, o.Min != nil},
+		{"max", o.Max, o.Max != nil},
+		{"wildcardProjection", o.WildcardProjection, len(o.WildcardProjection) > 0},
+		{"columnstoreProjection", o.ColumnstoreProjection, len(o.ColumnstoreProjection) > 0},
+		{"storageEngine", o.StorageEngine, len(o.StorageEngine) > 0},
+	}
+	for _, opt := range opts {
+		if opt.isSet {
+			spec = append(spec, bson.E{Key: opt.key, Value: opt.value})
+		}
+	}
+	return spec
+}
+
 const (
 	indexExt = "-index.bson.gz"
 )
@@ -96,6 +172,14 @@ func (ix *IndexStats) SetClusterDetailsFromFile(filename string) error {
 	if !strings.HasSuffix(filename, indexExt) && !strings.HasSuffix(filename, "-stats.bson.gz") {
 		return errors.New("unsupported file type")
 	}
+	if strings.HasSuffix(filename, "-stats.bson.gz") {
+		stats, err := ReadClusterStatsFile(filename)
+		if err != nil {
+			return err
+		}
+		ix.Databases = *stats.Databases
+		return nil
+	}
 	var data []byte
 	var err error
 	var fd *bufio.Reader
@@ -151,6 +235,13 @@ func (ix *IndexStats) GetIndexes(client *mongo.Client) ([]Database, error) {
 	return ix.Databases, err
 }
 
+// collectionInfo stores a collection of listCollections
+type collectionInfo struct {
+	Name    string `bson:"name"`
+	Options bson.D `bson:"options"`
+	Type    string `bson:"type"`
+}
+
 // GetIndexesFromDB list all indexes of collections of a database
 func (ix *IndexStats) GetIndexesFromDB(client *mongo.Client, db string) ([]Collection, error) {
 	var err error
@@ -163,27 +254,33 @@ func (ix *IndexStats) GetIndexesFromDB(client *mongo.Client, db string) ([]Colle
 	}
 	defer cur.Close(ctx)
 	collectionNames := []string{}
+	collectionTypes := map[string]collectionInfo{}
 	for cur.Next(ctx) {
-		var elem struct {
-			Name string `bson:"name"`
-			Type string `bson:"type"`
-		}
+		var elem collectionInfo
 		if err = cur.Decode(&elem); err != nil {
 			continue
 		}
-		if strings.HasPrefix(elem.Name, "system.") || (elem.Type != "" && elem.Type != "collection") {
+		if strings.HasPrefix(elem.Name, "system.") || (elem.Type != "" && elem.Type != "collection" && elem.Type != "timeseries") {
 			ix.Logger.Debug("skip ", elem.Name)
 			continue
 		}
 		collectionNames = append(collectionNames, elem.Name)
+		collectionTypes[elem.Name] = elem
 	}
 
 	sort.Strings(collectionNames)
 	for _, v := range collectionNames {
-		var collection = Collection{NS: db + "." + v, Name: v}
+		var collection = Collection{NS: db + "." + v, Name: v, Options: collectionTypes[v].Options,
+			Type: collectionTypes[v].Type}
 		if collection.Indexes, err = ix.GetIndexesFromCollection(client, client.Database(db).Collection(v)); err != nil {
 			return collections, err
 		}
+		if !ix.fastMode {
+			var perr error
+			if collection.PlanCache, perr = ix.GetPlanCacheFromCollection(client.Database(db).Collection(v)); perr != nil {
+				ix.Logger.Debugf(`%v plan cache error %v`, collection.NS, perr)
+			}
+		}
 		collections = append(collections, collection)
 	}
 	return collections, nil
@@ -191,8 +288,12 @@ func (ix *IndexStats) GetIndexesFromDB(client *mongo.Client, db string) ([]Colle
 
 // GetIndexesFromCollection gets indexes from a collection
 func (ix *IndexStats) GetIndexesFromCollection(client *mongo.Client, collection *mongo.Collection) ([]Index, error) {
+	return ix.GetIndexesFromCollectionWithContext(context.Background(), client, collection)
+}
+
+// GetIndexesFromCollectionWithContext gets indexes from a collection, commands are canceled with the context
+func (ix *IndexStats) GetIndexesFromCollectionWithContext(ctx context.Context, client *mongo.Client, collection *mongo.Collection) ([]Index, error) {
 	var err error
-	var ctx = context.Background()
 	var pipeline = MongoPipeline(`{"$indexStats": {}}`)
 	var list []Index
 	var icur *mongo.Cursor
@@ -290,11 +391,12 @@ func (ix *IndexStats) GetIndexesFromCollection(client *mongo.Client, collection
 
 // check if an index is a dup of others
 func checkIfDupped(doc Index, list []Index) bool {
-	if strings.Index(doc.KeyString, "2dsphere") > 0 {
+	// text, 2d, 2dsphere, hashed, wildcard, and columnstore indexes are not served by other indexes
+	if getIndexType(doc.Key) != indexTypeRegular || doc.Clustered || doc.Unique || doc.ExpireAfterSeconds >= 0 {
 		return false
 	}
 	for _, o := range list {
-		if strings.Index(o.KeyString, "2dsphere") > 0 {
+		if !canServeIndex(o, doc) {
 			continue
 		} else if !o.IsDupped && doc.Fields[0] == o.Fields[0] && doc.KeyString != o.KeyString && len(o.Fields) >= len(doc.Fields) {
 			// check indexes if not marked as dupped, has the same first field, and more or equal number of fields
@@ -305,7 +407,9 @@ func checkIfDupped(doc Index, list []Index) bool {
 				}
 				for j, field := range o.Fields {
 					if j > 0 && fld == field {
-						nmatched++
+						if _, ok := o.Key[j].Value.(string); !ok { // not hashed, text, or geo keys
+							nmatched++
+						}
 						break
 					}
 				}
@@ -318,6 +422,23 @@ func checkIfDupped(doc Index, list []Index) bool {
 	return false
 }
 
+// canServeIndex returns true if options of an index allow to serve queries of another index
+func canServeIndex(o Index, doc Index) bool {
+	if o.Hidden || len(o.Key) == 0 || len(o.Fields) == 0 {
+		return false
+	}
+	if t := getIndexType(o.Key); t != indexTypeRegular && t != indexTypeHashed {
+		return false
+	} else if _, ok := o.Key[0].Value.(string); ok { // leading hashed key
+		return false
+	}
+	if (len(o.PartialFilterExpression) > 0 && fmt.Sprint(o.PartialFilterExpression) != fmt.Sprint(doc.PartialFilterExpression)) ||
+		fmt.Sprint(o.Collation) != fmt.Sprint(doc.Collation) || (o.Sparse && !doc.Sparse) {
+		return false
+	}
+	return true
+}
+
 // OutputBSON writes index stats bson to a file
 func (ix *IndexStats) OutputBSON() (string, []byte, error) {
 	var err error
@@ -400,12 +521,18 @@ func (ix *IndexStats) PrintIndexesOf(databases []Database) {
 				} else {
 					buffer.WriteString(fmt.Sprintf("  %v", o.KeyString))
 				}
+				if o.Hidden {
+					buffer.WriteString(" (hidden)")
+				}
 
 				for _, u := range o.Usage {
 					buffer.Write([]byte("\n\thost: " + u.Host + ", ops: " + fmt.Sprintf("%v", u.Accesses.Ops) + ", since: " + fmt.Sprintf("%v", u.Accesses.Since)))
 				}
 				buffer.WriteString("\n")
 			}
+			if coll.PlanCache != nil && len(coll.PlanCache.Entries) > 0 {
+				buffer.WriteString(coll.PlanCache.String() + "\n")
+			}
 			fmt.Println(buffer.String())
 		}
 	}
@@ -468,67 +595,24 @@ func (ix *IndexStats) CopyIndexesWithDest(client *mongo.Client, namespaces []Ind
 				cmd := bson.D{{Key: "dropIndexes", Value: collName}, {Key: "index", Value: "*"}}
 				client.Database(dbName).RunCommand(ctx, cmd).Decode(&doc)
 			}
-			collection := client.Database(dbName).Collection(collName)
-			indexes := []mongo.IndexModel{}
+			if err = createCollectionOf(client, dbName, collName, coll); err != nil {
+				return err
+			}
+			indexes := bson.A{}
 			for _, o := range coll.Indexes {
-				opt := options.Index()
-				// opt.SetVersion(o.Version)
-				opt.SetName(o.Name)
-				if o.Background {
-					opt.SetBackground(o.Background)
-				}
-				if o.ExpireAfterSeconds >= 0 {
-					opt.SetExpireAfterSeconds(o.ExpireAfterSeconds)
-				}
-				if o.Unique {
-					opt.SetUnique(o.Unique)
-				}
-				if o.Sparse {
-					opt.SetSparse(o.Sparse)
-				}
-				var collation *options.Collation
-				if o.Collation != nil {
-					data, err := bson.Marshal(o.Collation)
-					if err != nil {
-						return err
-					}
-					bson.Unmarshal(data, &collation)
-					opt.SetCollation(collation)
-				}
-				if o.PartialFilterExpression != nil {
-					opt.SetPartialFilterExpression(o.PartialFilterExpression)
-				}
-				if o.Weights != nil {
-					opt.SetWeights(o.Weights)
+				if o.Clustered { // created with the collection
+					continue
 				}
 				if ix.verbose {
 					ix.Logger.Info(fmt.Sprintf(`creating index %v on %v `, o.KeyString, ns))
 				}
-				indexes = append(indexes, mongo.IndexModel{Keys: o.Key, Options: opt})
-				if o.Key.Map()["_id"] != nil {
-					collNames, _ := client.Database(dbName).ListCollectionNames(ctx, bson.D{})
-					var exists bool
-					for _, name := range collNames {
-						if name == coll.Name {
-							exists = true
-							break
-						}
-					}
-					if !exists {
-						collOpts := options.CreateCollection()
-						if collation != nil {
-							collOpts.SetCollation(collation)
-						}
-						if err = client.Database(dbName).CreateCollection(ctx, collName, collOpts); err != nil {
-							return err
-						}
-						if _, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: o.Key, Options: opt}); err != nil {
-							return err
-						}
-					}
-				}
+				indexes = append(indexes, o.GetIndexSpec())
 			}
-			if _, err = collection.Indexes().CreateMany(ctx, indexes); err != nil {
+			if len(indexes) == 0 {
+				continue
+			}
+			cmd := bson.D{{Key: "createIndexes", Value: collName}, {Key: "indexes", Value: indexes}}
+			if err = client.Database(dbName).RunCommand(ctx, cmd).Err(); err != nil {
 				return err
 			}
 		}
@@ -536,6 +620,37 @@ func (ix *IndexStats) CopyIndexesWithDest(client *mongo.Client, namespaces []Ind
 	return err
 }
 
+// createCollectionOf creates a collection with options, e.g. of time-series, clustered index, or
+// default collation, if it doesn't exist
+func createCollectionOf(client *mongo.Client, dbName string, collName string, coll Collection) error {
+	var err error
+	var ctx = context.Background()
+	var names []string
+	if names, err = client.Database(dbName).ListCollectionNames(ctx, bson.D{{Key: "name", Value: collName}}); err != nil {
+		return err
+	} else if len(names) > 0 {
+		return nil
+	}
+	cmd := bson.D{{Key: "create", Value: collName}}
+	if len(coll.Options) > 0 {
+		cmd = append(cmd, coll.Options...)
+	} else { // from files without options of collections
+		for _, o := range coll.Indexes {
+			if o.Key.Map()["_id"] == nil || len(o.Key) > 1 {
+				continue
+			}
+			if len(o.Collation) > 0 {
+				cmd = append(cmd, bson.E{Key: "collation", Value: o.Collation})
+			}
+			if o.Clustered {
+				cmd = append(cmd, bson.E{Key: "clusteredIndex", Value: bson.D{{Key: "key", Value: o.Key},
+					{Key: "unique", Value: true}, {Key: "name", Value: o.Name}}})
+			}
+		}
+	}
+	return client.Database(dbName).RunCommand(ctx, cmd).Err()
+}
+
 // GetDatabaseNames gets all database names
 func GetDatabaseNames(client *mongo.Client) ([]string, error) {
 	var err error
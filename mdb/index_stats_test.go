@@ -6,6 +6,7 @@ import (
 	"context"
 	"log"
 	"math/rand"
+	"os"
 	"testing"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -69,3 +70,105 @@ func seedNumbers(c *mongo.Collection) {
 	}
 	indexView.CreateOne(ctx, idx)
 }
+
+func TestGetIndexSpec(t *testing.T) {
+	str := `{"indexes": [
+		{"v": 2, "key": {"_id": 1}, "name": "_id_", "unique": true, "clustered": true},
+		{"v": 2, "key": {"$**": 1}, "name": "$**_1", "wildcardProjection": {"a": 1}, "hidden": true},
+		{"v": 2, "key": {"_fts": "text", "_ftsx": 1}, "name": "title_text", "weights": {"title": 1},
+			"default_language": "english", "language_override": "language", "textIndexVersion": 3},
+		{"v": 2, "key": {"loc": "2dsphere"}, "name": "loc_2dsphere", "2dsphereIndexVersion": 3},
+		{"v": 2, "key": {"pos": "2d"}, "name": "pos_2d", "bits": 26, "min": -180.0, "max": 180.0},
+		{"v": 2, "key": {"$**": "columnstore"}, "name": "$**_columnstore", "columnstoreProjection": {"b": 1}},
+		{"v": 2, "key": {"a": 1, "b": "hashed"}, "name": "a_1_b_hashed", "prepareUnique": true}]}`
+	var doc struct {
+		Indexes []Index `bson:"indexes"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(str), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	types := []string{indexTypeRegular, indexTypeWildcard, indexTypeText, indexType2dsphere, indexType2d,
+		indexTypeColumnstore, indexTypeHashed}
+	options := []string{"clustered", "wildcardProjection", "textIndexVersion", "2dsphereIndexVersion", "bits",
+		"columnstoreProjection", "prepareUnique"}
+	for i, o := range doc.Indexes {
+		if getIndexType(o.Key) != types[i] {
+			t.Fatal("expected", types[i], "of", o.Name, "but", getIndexType(o.Key))
+		}
+		o.ExpireAfterSeconds = -1
+		spec := o.GetIndexSpec().Map()
+		if i > 0 && spec[options[i]] == nil {
+			t.Fatal("expected option", options[i], "of", o.Name, spec)
+		}
+	}
+	if doc.Indexes[1].GetIndexSpec().Map()["hidden"] != true || *doc.Indexes[4].Min != -180 {
+		t.Fatal("unexpected options", doc.Indexes[1], doc.Indexes[4])
+	}
+	data, _ := bson.Marshal(doc)
+	var copied struct {
+		Indexes []Index `bson:"indexes"`
+	}
+	bson.Unmarshal(data, &copied)
+	if !copied.Indexes[0].Clustered || copied.Indexes[2].TextIndexVersion != 3 || len(copied.Indexes[5].ColumnstoreProjection) != 1 {
+		t.Fatal("expected options preserved", copied.Indexes)
+	}
+}
+
+func TestCheckIfDupped(t *testing.T) {
+	index := func(key bson.D) Index {
+		o := Index{ExpireAfterSeconds: -1, Key: key, KeyString: getIndexKeyString(key)}
+		for _, elem := range key {
+			o.Fields = append(o.Fields, elem.Key)
+		}
+		return o
+	}
+	a := index(bson.D{{Key: "a", Value: 1}})
+	ab := index(bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}})
+	aHashed := index(bson.D{{Key: "a", Value: "hashed"}})
+	aBHashed := index(bson.D{{Key: "a", Value: 1}, {Key: "b", Value: "hashed"}})
+	loc := index(bson.D{{Key: "loc", Value: "2dsphere"}})
+	wildcard := index(bson.D{{Key: "a.$**", Value: 1}})
+	if !checkIfDupped(a, []Index{a, ab}) || !checkIfDupped(a, []Index{a, aBHashed}) {
+		t.Fatal("expected { a: 1 } dupped")
+	}
+	if checkIfDupped(a, []Index{a, aHashed}) || checkIfDupped(loc, []Index{loc, a}) || checkIfDupped(wildcard, []Index{wildcard, ab}) {
+		t.Fatal("expected not dupped of special indexes")
+	}
+	if checkIfDupped(index(bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}}), []Index{aBHashed}) {
+		t.Fatal("expected not dupped by a hashed key")
+	}
+	ab.Hidden = true
+	if checkIfDupped(a, []Index{a, ab}) {
+		t.Fatal("expected not dupped by a hidden index")
+	}
+	ab.Hidden = false
+	ab.PartialFilterExpression = bson.D{{Key: "b", Value: bson.D{{Key: "$exists", Value: true}}}}
+	if checkIfDupped(a, []Index{a, ab}) {
+		t.Fatal("expected not dupped by a partial index")
+	}
+}
+
+func TestSetClusterDetailsFromStatsFile(t *testing.T) {
+	stats := NewClusterStats("utest-xxxxxx")
+	stats.HostInfo.System.Hostname = "utest-index"
+	stats.Databases = &[]Database{{Name: "keyhole", Collections: []Collection{
+		{NS: "keyhole.cars", Name: "cars", Indexes: []Index{
+			{ExpireAfterSeconds: -1, Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+			{ExpireAfterSeconds: 0, Name: "expireAt_1", Key: bson.D{{Key: "expireAt", Value: int32(1)}}}}}}}}
+	ofile, _, err := stats.OutputBSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ofile)
+	ix := NewIndexStats("utest-xxxxxx")
+	if err = ix.SetClusterDetailsFromFile(ofile); err != nil {
+		t.Fatal(err)
+	}
+	if len(ix.Databases) != 1 || len(ix.Databases[0].Collections) != 1 {
+		t.Fatal("unexpected databases", ix.Databases)
+	}
+	indexes := ix.Databases[0].Collections[0].Indexes
+	if len(indexes) != 2 || indexes[0].ExpireAfterSeconds != -1 || indexes[1].ExpireAfterSeconds != 0 {
+		t.Fatal("unexpected indexes", indexes)
+	}
+}
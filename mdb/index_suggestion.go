@@ -4,6 +4,8 @@ package mdb
 
 import (
 	"encoding/json"
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/simagix/gox"
@@ -13,6 +15,8 @@ import (
 
 // GetIndexSuggestion returns a recommended index by cardinalities
 // index follows a principle of equality, sort, rnage
+//
+// Deprecated: use GetIndexSuggestions, which understands $in, $ne, regex, arrays and $or branches
 func GetIndexSuggestion(explain ExplainCommand, cardList []CardinalityCount) gox.OrderedMap {
 	equalityKeys := GetKeys(explain.Filter, false)
 	rangeKeys := GetKeys(explain.Filter, true)
@@ -99,3 +103,291 @@ func isRange(value interface{}) bool {
 	}
 	return false
 }
+
+// types of keys of a suggested index in the order of positions
+const (
+	keyEquality = "equality"
+	keySort     = "sort"
+	keyRange    = "range"
+	keyFilter   = "filter" // low selectivity predicates, e.g. $ne, $nin, and unanchored regex
+)
+
+var keyTypeOrders = map[string]int{keyEquality: 0, keySort: 1, keyRange: 2, keyFilter: 3}
+
+// IndexSuggestion stores a suggested index of a query, or of a $or branch, and reasons of key positions
+type IndexSuggestion struct {
+	Branch        int              `json:"branch"` // 1-based $or branch, 0 if not of a $or
+	Key           bson.D           `json:"-"`
+	KeyString     string           `json:"key"`
+	Keys          []IndexKeyReason `json:"keys"`
+	Notes         []string         `json:"notes,omitempty"`
+	PartialFilter string           `json:"partialFilter,omitempty"`
+}
+
+// IndexKeyReason stores a key of a suggested index and the reason of its position
+type IndexKeyReason struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+	Type   string `json:"type"`
+}
+
+// predicate stores how a field of a filter can use an index
+type predicate struct {
+	exists   bool // $exists: true, a candidate of partial indexes
+	field    string
+	kind     string
+	multikey bool
+	reason   string
+}
+
+// GetIndexSuggestions returns suggested indexes of a query, one of each $or branch, following the
+// equality, sort, and range (ESR) rule.  Equality and range fields are ordered by cardinalities,
+// high to low.  Known multikey fields, e.g. multiKeyPaths from explain, are used to note limitations
+// of array fields.
+func GetIndexSuggestions(explain ExplainCommand, cardList []CardinalityCount, multikeyFields []string) []IndexSuggestion {
+	suggestions := []IndexSuggestion{}
+	branches := getFilterDocBranches(explain.Filter)
+	for i, branch := range branches {
+		suggestion := getIndexSuggestion(branch, explain.Sort, cardList, multikeyFields)
+		if len(branches) > 1 {
+			suggestion.Branch = i + 1
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions
+}
+
+// getFilterDocBranches flattens $and and returns a filter of each $or branch
+func getFilterDocBranches(filter bson.D) []bson.D {
+	flat := bson.D{}
+	var ors primitive.A
+	for _, elem := range filter {
+		if elem.Key == "$and" {
+			for _, v := range getArray(elem.Value) {
+				if doc, ok := v.(bson.D); ok {
+					for _, branch := range getFilterDocBranches(doc) {
+						flat = append(flat, branch...)
+					}
+				}
+			}
+		} else if elem.Key == "$or" && ors == nil {
+			ors = getArray(elem.Value)
+		} else {
+			flat = append(flat, elem)
+		}
+	}
+	if len(ors) == 0 {
+		return []bson.D{flat}
+	}
+	branches := []bson.D{}
+	for _, v := range ors {
+		doc, ok := v.(bson.D)
+		if !ok {
+			continue
+		}
+		merged := append(append(bson.D{}, flat...), doc...)
+		branches = append(branches, getFilterDocBranches(merged)...)
+	}
+	return branches
+}
+
+// getIndexSuggestion returns a suggested index of a filter without $or
+func getIndexSuggestion(filter bson.D, sortKeys bson.D, cardList []CardinalityCount, multikeyFields []string) IndexSuggestion {
+	suggestion := IndexSuggestion{Keys: []IndexKeyReason{}}
+	predicates, notes := getPredicates(filter, "", len(sortKeys) > 0)
+	suggestion.Notes = notes
+	for _, p := range predicates {
+		if p.field == "_id" && p.kind == keyEquality {
+			suggestion.Key = bson.D{{Key: "_id", Value: 1}}
+			suggestion.KeyString = getIndexKeyString(suggestion.Key)
+			suggestion.Keys = append(suggestion.Keys, IndexKeyReason{Field: "_id", Type: keyEquality,
+				Reason: "equality on _id is served by the _id index"})
+			return suggestion
+		}
+	}
+	cardinalities := map[string]int64{}
+	for _, elem := range cardList {
+		cardinalities[elem.Field] = elem.Count
+	}
+	sort.SliceStable(predicates, func(i, j int) bool {
+		if predicates[i].kind != predicates[j].kind {
+			return keyTypeOrders[predicates[i].kind] < keyTypeOrders[predicates[j].kind]
+		}
+		return cardinalities[predicates[i].field] > cardinalities[predicates[j].field]
+	})
+
+	fields := map[string]bool{}
+	multikeys := []string{}
+	addKey := func(field string, direction int, kind string, reason string, multikey bool) {
+		if count, ok := cardinalities[field]; ok && kind != keySort {
+			reason += fmt.Sprintf(", cardinality %v", count)
+		}
+		suggestion.Key = append(suggestion.Key, bson.E{Key: field, Value: direction})
+		suggestion.Keys = append(suggestion.Keys, IndexKeyReason{Field: field, Reason: reason, Type: kind})
+		fields[field] = true
+		if multikey || contains(multikeyFields, field) {
+			multikeys = append(multikeys, field)
+		}
+	}
+	partials := []string{}
+	for _, p := range predicates {
+		if p.kind != keyEquality || fields[p.field] {
+			continue
+		}
+		addKey(p.field, 1, p.kind, p.reason, p.multikey)
+	}
+	for _, elem := range sortKeys {
+		if _, ok := elem.Value.(bson.D); ok { // $meta
+			continue
+		}
+		if fields[elem.Key] {
+			suggestion.Notes = append(suggestion.Notes, fmt.Sprintf("sort on %v is satisfied by the equality match", elem.Key))
+			continue
+		}
+		direction := 1
+		if toInt64(elem.Value) < 0 {
+			direction = -1
+		}
+		addKey(elem.Key, direction, keySort, "sort follows equality keys to avoid an in-memory sort", false)
+		if contains(multikeyFields, elem.Key) {
+			suggestion.Notes = append(suggestion.Notes, fmt.Sprintf("sort on array field %v may require a blocking sort", elem.Key))
+		}
+	}
+	for _, kind := range []string{keyRange, keyFilter} {
+		for _, p := range predicates {
+			if p.kind != kind || fields[p.field] {
+				continue
+			}
+			addKey(p.field, 1, p.kind, p.reason, p.multikey)
+			if p.exists {
+				partials = append(partials, p.field+": { $exists: true }")
+			}
+		}
+	}
+	if len(multikeys) > 1 {
+		suggestion.Notes = append(suggestion.Notes, fmt.Sprintf(
+			"fields %v may be arrays, a compound index cannot index more than one array field of a document",
+			strings.Join(multikeys, ", ")))
+	}
+	if len(partials) > 0 {
+		suggestion.PartialFilter = "{ " + strings.Join(partials, ", ") + " }"
+		suggestion.Notes = append(suggestion.Notes, "consider a partial index of "+suggestion.PartialFilter+
+			" if most documents don't have the fields")
+	}
+	suggestion.KeyString = getIndexKeyString(suggestion.Key)
+	return suggestion
+}
+
+// getPredicates returns predicates of fields of a filter, and notes of predicates unable to use an index
+func getPredicates(filter bson.D, prefix string, hasSort bool) ([]predicate, []string) {
+	predicates := []predicate{}
+	notes := []string{}
+	for _, elem := range filter {
+		if strings.HasPrefix(elem.Key, "$") {
+			notes = append(notes, elem.Key+" cannot use a regular index")
+			continue
+		}
+		field := prefix + elem.Key
+		switch value := elem.Value.(type) {
+		case primitive.Regex:
+			predicates = append(predicates, getRegexPredicate(field, value.Pattern, value.Options))
+		case primitive.A:
+			predicates = append(predicates, predicate{field: field, kind: keyEquality, multikey: true,
+				reason: "equality on an array"})
+		case bson.D:
+			if len(value) == 0 || !strings.HasPrefix(value[0].Key, "$") {
+				predicates = append(predicates, predicate{field: field, kind: keyEquality,
+					reason: "equality on an embedded document"})
+				continue
+			}
+			p, nested, n := getOperatorPredicate(field, value, hasSort)
+			notes = append(notes, n...)
+			predicates = append(predicates, nested...)
+			if p.kind != "" {
+				predicates = append(predicates, p)
+			}
+		default:
+			predicates = append(predicates, predicate{field: field, kind: keyEquality, reason: "equality"})
+		}
+	}
+	return predicates, notes
+}
+
+// getOperatorPredicate returns the predicate of a field of query operators, of which the
+// most selective operator decides the key type, and predicates of nested fields of $elemMatch
+func getOperatorPredicate(field string, ops bson.D, hasSort bool) (predicate, []predicate, []string) {
+	p := predicate{field: field}
+	nested := []predicate{}
+	notes := []string{}
+	set := func(kind string, reason string) {
+		if p.kind == "" || keyTypeOrders[kind] < keyTypeOrders[p.kind] {
+			p.kind = kind
+			p.reason = reason
+		}
+	}
+	options, _ := ops.Map()["$options"].(string)
+	for _, op := range ops {
+		switch op.Key {
+		case "$eq":
+			set(keyEquality, "equality")
+		case "$in":
+			n := len(getArray(op.Value))
+			if n <= 1 {
+				set(keyEquality, "$in of a single value is an equality")
+			} else if hasSort {
+				set(keyRange, fmt.Sprintf("$in of %d values breaks the sort order, placed after sort keys", n))
+			} else {
+				set(keyEquality, fmt.Sprintf("$in of %d values are point lookups without a sort", n))
+			}
+		case "$all":
+			p.multikey = true
+			set(keyEquality, "$all uses the first value for index bounds on an array")
+		case "$gt", "$gte", "$lt", "$lte":
+			set(keyRange, "range")
+		case "$regex":
+			pattern, _ := op.Value.(string)
+			if regex, ok := op.Value.(primitive.Regex); ok {
+				pattern = regex.Pattern
+				options += regex.Options
+			}
+			r := getRegexPredicate(field, pattern, options)
+			set(r.kind, r.reason)
+		case "$options":
+		case "$exists":
+			if exists, ok := op.Value.(bool); ok && !exists {
+				set(keyFilter, "$exists: false has low selectivity, placed last")
+			} else {
+				p.exists = true
+				set(keyRange, "$exists: true scans all bounds of the field")
+			}
+		case "$ne", "$nin", "$not":
+			set(keyFilter, op.Key+" has low selectivity, placed last")
+		case "$elemMatch":
+			p.multikey = true
+			sub, _ := op.Value.(bson.D)
+			if len(sub) > 0 && !strings.HasPrefix(sub[0].Key, "$") { // array of documents
+				predicates, n := getPredicates(sub, field+".", hasSort)
+				for i := range predicates {
+					predicates[i].multikey = true
+				}
+				nested = append(nested, predicates...)
+				notes = append(notes, n...)
+			} else if len(sub) > 0 { // array of values
+				elem, _, _ := getOperatorPredicate(field, sub, hasSort)
+				set(elem.kind, "$elemMatch "+elem.reason)
+			}
+		default: // $size, $type, $mod, $geoWithin, etc.
+			notes = append(notes, fmt.Sprintf("%v of %v cannot be bounded by a regular index", op.Key, field))
+		}
+	}
+	return p, nested, notes
+}
+
+// getRegexPredicate returns predicate of a regex, only a case-sensitive regex anchored with ^
+// is a prefix range scan
+func getRegexPredicate(field string, pattern string, options string) predicate {
+	if strings.HasPrefix(pattern, "^") && !strings.Contains(options, "i") {
+		return predicate{field: field, kind: keyRange, reason: "anchored regex is a prefix range"}
+	}
+	return predicate{field: field, kind: keyFilter, reason: "unanchored or case-insensitive regex scans all keys, placed last"}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/simagix/gox"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestGetIndexSuggestionFromFilter(t *testing.T) {
@@ -78,3 +79,44 @@ func TestGetIndexSuggestionElemMatch(t *testing.T) {
 	}
 	t.Log("index:", gox.Stringify(index))
 }
+
+func TestGetIndexSuggestions(t *testing.T) {
+	var explain ExplainCommand
+	str := `{"filter": {"brand": "BMW", "year": {"$gt": 2017}, "color": {"$in": ["Red", "Blue"]}}, "sort": {"price": -1}}`
+	bson.UnmarshalExtJSON([]byte(str), true, &explain)
+	cardList := []CardinalityCount{{Field: "year", Count: 20}, {Field: "brand", Count: 10}, {Field: "color", Count: 5}}
+	suggestions := GetIndexSuggestions(explain, cardList, nil)
+	if len(suggestions) != 1 || suggestions[0].KeyString != "{ brand: 1, price: -1, year: 1, color: 1 }" {
+		t.Fatal("unexpected suggestions", suggestions)
+	}
+	if keys := suggestions[0].Keys; keys[1].Type != keySort || keys[3].Type != keyRange {
+		t.Fatal("unexpected key types", keys)
+	}
+
+	explain = ExplainCommand{Filter: bson.D{
+		{Key: "$or", Value: bson.A{bson.D{{Key: "a", Value: 1}}, bson.D{{Key: "b", Value: primitive.Regex{Pattern: "^x"}}}}},
+		{Key: "status", Value: bson.D{{Key: "$ne", Value: "x"}}},
+		{Key: "name", Value: primitive.Regex{Pattern: "x", Options: "i"}}}}
+	suggestions = GetIndexSuggestions(explain, nil, nil)
+	if len(suggestions) != 2 || suggestions[0].Branch != 1 || suggestions[1].Branch != 2 {
+		t.Fatal("expected suggestions of 2 branches", suggestions)
+	}
+	if suggestions[0].KeyString != "{ a: 1, status: 1, name: 1 }" || suggestions[1].KeyString != "{ b: 1, status: 1, name: 1 }" {
+		t.Fatal("unexpected suggestions", suggestions[0].KeyString, suggestions[1].KeyString)
+	}
+
+	str = `{"filter": {"filters": {"$elemMatch": {"k": "color", "v": "Red"}}, "tags": {"$all": ["a", "b"]}, "deleted": {"$exists": true}}}`
+	bson.UnmarshalExtJSON([]byte(str), true, &explain)
+	suggestions = GetIndexSuggestions(explain, nil, []string{"tags"})
+	if suggestions[0].KeyString != "{ filters.k: 1, filters.v: 1, tags: 1, deleted: 1 }" {
+		t.Fatal("unexpected suggestion", suggestions[0].KeyString)
+	}
+	if suggestions[0].PartialFilter != "{ deleted: { $exists: true } }" || len(suggestions[0].Notes) != 2 {
+		t.Fatal("expected notes of arrays and partial index", suggestions[0].Notes)
+	}
+
+	explain = ExplainCommand{Filter: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}}}
+	if suggestions = GetIndexSuggestions(explain, nil, nil); suggestions[0].KeyString != "{ _id: 1 }" {
+		t.Fatal("expected _id index", suggestions[0].KeyString)
+	}
+}
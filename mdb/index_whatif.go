@@ -0,0 +1,234 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultWhatIfSampleSize = 10000
+	whatIfIndexPrefix       = "whatif_"
+)
+
+// IndexWhatIf evaluates hypothetical indexes on a scratch clone of sampled documents of a collection
+type IndexWhatIf struct {
+	client     *mongo.Client
+	sampleSize int
+	verbose    bool
+}
+
+// WhatIfResult stores scores of the current winning plan and of candidate indexes on a scratch clone
+type WhatIfResult struct {
+	Baseline   WhatIfScore   `json:"baseline"`
+	Candidates []WhatIfScore `json:"candidates"`
+	Namespace  string        `json:"ns"` // scratch namespace
+	Sampled    int           `json:"sampled"`
+	WinningNew bool          `json:"winningNew"` // planner picks a candidate without hints
+}
+
+// WhatIfScore stores explain stats of a plan
+type WhatIfScore struct {
+	Error       string     `json:"error,omitempty"`
+	Improvement float64    `json:"improvement"` // score difference from the baseline
+	Index       string     `json:"index"`
+	Plan        string     `json:"plan"`
+	Score       float64    `json:"score"`
+	Stats       StageStats `json:"stats"`
+}
+
+// NewIndexWhatIf returns IndexWhatIf
+func NewIndexWhatIf(client *mongo.Client) *IndexWhatIf {
+	return &IndexWhatIf{client: client, sampleSize: defaultWhatIfSampleSize}
+}
+
+// SetSampleSize sets number of documents sampled into the scratch collection
+func (w *IndexWhatIf) SetSampleSize(sampleSize int) {
+	if sampleSize > 0 {
+		w.sampleSize = sampleSize
+	}
+}
+
+// SetVerbose sets verbosity
+func (w *IndexWhatIf) SetVerbose(verbose bool) {
+	w.verbose = verbose
+}
+
+// Evaluate copies a $sample of the collection of a query to a scratch collection under KeyholeDB,
+// with existing indexes, explains the query with hints of candidate indexes, and compares scores
+// with the current winning plan.  The scratch collection is dropped afterward.
+func (w *IndexWhatIf) Evaluate(qe *QueryExplainer, candidates []bson.D) (*WhatIfResult, error) {
+	var err error
+	ctx := context.Background()
+	db, coll := SplitNamespace(qe.NameSpace)
+	name := fmt.Sprintf("%v%v_%v", whatIfIndexPrefix, db, coll)
+	scratch := w.client.Database(KeyholeDB).Collection(name)
+	result := &WhatIfResult{Namespace: KeyholeDB + "." + name, Candidates: []WhatIfScore{}}
+	if err = scratch.Drop(ctx); err != nil {
+		return result, err
+	}
+	defer scratch.Drop(ctx)
+	source := w.client.Database(db).Collection(coll)
+	if result.Sampled, err = w.copySample(source, scratch); err != nil {
+		return result, err
+	}
+	var indexes []Index
+	if indexes, err = w.copyIndexes(source, scratch); err != nil {
+		return result, err
+	}
+	existing := map[string]bool{}
+	for _, index := range indexes {
+		existing[getIndexKeyString(index.Key)] = true
+	}
+
+	sqe := *qe
+	sqe.NameSpace = result.Namespace
+	sqe.ExplainCmd.Collection = name
+	sqe.ExplainCmd.Hint = nil
+	result.Baseline = w.getScore(&sqe, "")
+
+	// candidates of keys of existing indexes are hinted without being created, and a failed
+	// creation is the error of the candidate
+	names := map[string]string{}
+	for i, key := range candidates {
+		keyString := getIndexKeyString(key)
+		score := WhatIfScore{Index: keyString}
+		if !existing[keyString] {
+			indexName := fmt.Sprintf("%v%d", whatIfIndexPrefix, i+1)
+			model := mongo.IndexModel{Keys: key, Options: options.Index().SetName(indexName)}
+			if _, err := scratch.Indexes().CreateOne(ctx, model); err != nil {
+				score.Error = err.Error()
+				result.Candidates = append(result.Candidates, score)
+				continue
+			}
+			existing[keyString] = true
+			names[indexName] = keyString
+		}
+		sqe.ExplainCmd.Hint = key
+		score = w.getScore(&sqe, keyString)
+		score.Improvement = score.Score - result.Baseline.Score
+		result.Candidates = append(result.Candidates, score)
+		if w.verbose {
+			fmt.Printf("what-if %d: %v, score: %v, improvement: %v\n", i+1, score.Index, score.Score, score.Improvement)
+		}
+	}
+	sqe.ExplainCmd.Hint = nil
+	if winning := w.getScore(&sqe, ""); winning.Error == "" {
+		for _, keyString := range names {
+			if strings.Contains(winning.Plan, keyString) {
+				result.WinningNew = true
+			}
+		}
+	}
+	return result, err
+}
+
+// getScore explains a query on the scratch collection and returns the score of the winning plan
+func (w *IndexWhatIf) getScore(qe *QueryExplainer, index string) WhatIfScore {
+	score := WhatIfScore{Index: index}
+	summary, _, err := qe.explain()
+	if err != nil {
+		score.Error = err.Error()
+		return score
+	}
+	score.Plan = summary.WinningPlan
+	score.Stats = summary.ExecutionStats
+	score.Score = summary.ExecutionStats.Score
+	return score
+}
+
+// copySample copies a $sample of documents to the scratch collection
+func (w *IndexWhatIf) copySample(source *mongo.Collection, scratch *mongo.Collection) (int, error) {
+	var err error
+	var cursor *mongo.Cursor
+	ctx := context.Background()
+	pipeline := mongo.Pipeline{{{Key: "$sample", Value: bson.D{{Key: "size", Value: w.sampleSize}}}}}
+	if cursor, err = source.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true)); err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	count := 0
+	docs := []interface{}{}
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err = cursor.Decode(&doc); err != nil {
+			return count, err
+		}
+		docs = append(docs, doc)
+		if len(docs) == 1000 {
+			if _, err = scratch.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+				return count, err
+			}
+			count += len(docs)
+			docs = []interface{}{}
+		}
+	}
+	if len(docs) > 0 {
+		if _, err = scratch.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+			return count, err
+		}
+		count += len(docs)
+	}
+	return count, cursor.Err()
+}
+
+// copyIndexes creates existing indexes on the scratch collection with all options, except TTL
+// indexes, which would remove sampled documents, and returns indexes of the scratch collection
+func (w *IndexWhatIf) copyIndexes(source *mongo.Collection, scratch *mongo.Collection) ([]Index, error) {
+	var err error
+	var cursor *mongo.Cursor
+	ctx := context.Background()
+	if cursor, err = source.Indexes().List(ctx); err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	indexes := []Index{}
+	specs := bson.A{}
+	for cursor.Next(ctx) {
+		index := Index{ExpireAfterSeconds: -1}
+		if err = cursor.Decode(&index); err != nil {
+			return nil, err
+		}
+		if index.ExpireAfterSeconds >= 0 || index.Clustered {
+			continue
+		}
+		indexes = append(indexes, index)
+		if index.Name != "_id_" {
+			specs = append(specs, index.GetIndexSpec())
+		}
+	}
+	if len(specs) == 0 {
+		return indexes, cursor.Err()
+	}
+	cmd := bson.D{{Key: "createIndexes", Value: scratch.Name()}, {Key: "indexes", Value: specs}}
+	return indexes, scratch.Database().RunCommand(ctx, cmd).Err()
+}
+
+// String returns a summary of what-if evaluation
+func (r *WhatIfResult) String() string {
+	strs := []string{fmt.Sprintf("=> What-If Evaluation on %v of %d sampled documents", r.Namespace, r.Sampled),
+		"========================================="}
+	baseline := fmt.Sprintf("current plan: %v, score: %.4f", r.Baseline.Plan, r.Baseline.Score)
+	if r.Baseline.Error != "" {
+		baseline = "current plan: " + r.Baseline.Error
+	}
+	strs = append(strs, baseline)
+	for _, score := range r.Candidates {
+		if score.Error != "" {
+			strs = append(strs, fmt.Sprintf("%v: %v", score.Index, score.Error))
+			continue
+		}
+		strs = append(strs, fmt.Sprintf("%v: score: %.4f (%+.4f), keys examined: %v, docs examined: %v",
+			score.Index, score.Score, score.Improvement, score.Stats.TotalKeysExamined, score.Stats.TotalDocsExamined))
+	}
+	if r.WinningNew {
+		strs = append(strs, "the query planner selects a candidate index without hints")
+	}
+	return strings.Join(strs, "\n")
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestWhatIfResultString(t *testing.T) {
+	result := WhatIfResult{Namespace: KeyholeDB + ".whatif_keyhole_cars", Sampled: 100, WinningNew: true,
+		Baseline: WhatIfScore{Plan: "COLLSCAN", Score: 1.01},
+		Candidates: []WhatIfScore{
+			{Index: "{ color: 1 }", Improvement: 0.98, Plan: "FETCH > IXSCAN { color: 1 }", Score: 1.99,
+				Stats: StageStats{TotalDocsExamined: 10, TotalKeysExamined: 10}},
+			{Index: "{ year: 1 }", Error: "hint provided does not correspond to an existing index"}}}
+	str := result.String()
+	for _, s := range []string{"current plan: COLLSCAN, score: 1.0100", "{ color: 1 }: score: 1.9900 (+0.9800)",
+		"{ year: 1 }: hint provided", "selects a candidate index"} {
+		if !strings.Contains(str, s) {
+			t.Fatal("expected", s, "in", str)
+		}
+	}
+}
+
+func TestIndexWhatIfEvaluate(t *testing.T) {
+	ctx := context.Background()
+	client := getMongoClient()
+	defer client.Disconnect(ctx)
+	source := client.Database(dbName).Collection("whatif_cars")
+	source.Drop(ctx)
+	defer source.Drop(ctx)
+	docs := []interface{}{}
+	for i := 0; i < 100; i++ {
+		docs = append(docs, bson.D{{Key: "color", Value: fmt.Sprintf("color-%d", i%10)}, {Key: "year", Value: 2000 + i%20}})
+	}
+	if _, err := source.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+	collation := &options.Collation{Locale: "en", NumericOrdering: true}
+	models := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "color", Value: 1}}},
+		{Keys: bson.D{{Key: "year", Value: 1}}, Options: options.Index().SetName("year_numeric").SetCollation(collation)}}
+	if _, err := source.Indexes().CreateMany(ctx, models); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewIndexWhatIf(client)
+	scratch := client.Database(KeyholeDB).Collection("whatif_copy")
+	scratch.Drop(ctx)
+	defer scratch.Drop(ctx)
+	if _, err := scratch.InsertOne(ctx, bson.D{{Key: "color", Value: "Red"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.copyIndexes(source, scratch); err != nil {
+		t.Fatal(err)
+	}
+	var specs []bson.M
+	cursor, err := scratch.Indexes().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cursor.All(ctx, &specs); err != nil {
+		t.Fatal(err)
+	}
+	numeric := false
+	for _, spec := range specs {
+		if c, ok := spec["collation"].(bson.M); ok && spec["name"] == "year_numeric" {
+			numeric = c["numericOrdering"] == true
+		}
+	}
+	if len(specs) != 3 || !numeric {
+		t.Fatal("expected indexes copied with collation", specs)
+	}
+
+	qe := NewQueryExplainer(client)
+	if err = qe.ReadQueryShape([]byte(`{"ns": "keyhole.whatif_cars", "filter": {"color": "color-1", "year": 2001}}`)); err != nil {
+		t.Fatal(err)
+	}
+	candidates := []bson.D{{{Key: "color", Value: 1}}, {{Key: "color", Value: 1}, {Key: "year", Value: 1}},
+		{{Key: "color", Value: "unknown"}}}
+	result, err := w.Evaluate(qe, candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Sampled != 100 || len(result.Candidates) != 3 {
+		t.Fatal("unexpected result", result)
+	}
+	if result.Candidates[0].Error != "" || result.Candidates[1].Error != "" || result.Candidates[2].Error == "" {
+		t.Fatal("expected an error of the invalid candidate only", result.Candidates)
+	}
+}
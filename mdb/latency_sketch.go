@@ -0,0 +1,100 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import "math"
+
+// latencyGamma is the growth factor of bucket boundaries, the relative error
+// of a quantile is within (gamma-1)/(gamma+1), about 5%
+const latencyGamma = 1.1
+
+var latencyLogGamma = math.Log(latencyGamma)
+
+// LatencySketch keeps a compact and mergeable histogram of durations in
+// milliseconds with logarithmic buckets
+type LatencySketch struct {
+	Bins   []int `bson:"bins"`   // counts of buckets starting from Offset
+	Count  int   `bson:"count"`  // total number of durations
+	Offset int   `bson:"offset"` // index of the first bucket
+	Zero   int   `bson:"zero"`   // number of 0ms durations
+}
+
+// Add adds a duration in milliseconds
+func (ls *LatencySketch) Add(milli int) {
+	ls.add(milli, 1)
+}
+
+// add adds n durations of the same milliseconds
+func (ls *LatencySketch) add(milli int, n int) {
+	ls.Count += n
+	if milli <= 0 {
+		ls.Zero += n
+		return
+	}
+	ls.addBucket(int(math.Ceil(math.Log(float64(milli))/latencyLogGamma)), n)
+}
+
+// addBucket adds n to a bucket, grows bins when needed
+func (ls *LatencySketch) addBucket(idx int, n int) {
+	if len(ls.Bins) == 0 {
+		ls.Offset = idx
+		ls.Bins = []int{0}
+	} else if idx < ls.Offset {
+		bins := make([]int, ls.Offset-idx+len(ls.Bins))
+		copy(bins[ls.Offset-idx:], ls.Bins)
+		ls.Bins = bins
+		ls.Offset = idx
+	} else if idx >= ls.Offset+len(ls.Bins) {
+		bins := make([]int, idx-ls.Offset+1)
+		copy(bins, ls.Bins)
+		ls.Bins = bins
+	}
+	ls.Bins[idx-ls.Offset] += n
+}
+
+// Merge merges another sketch
+func (ls *LatencySketch) Merge(other LatencySketch) {
+	ls.Count += other.Count
+	ls.Zero += other.Zero
+	for i, n := range other.Bins {
+		if n > 0 {
+			ls.addBucket(other.Offset+i, n)
+		}
+	}
+}
+
+// Clone returns a deep copy
+func (ls LatencySketch) Clone() LatencySketch {
+	sketch := ls
+	sketch.Bins = append([]int(nil), ls.Bins...)
+	return sketch
+}
+
+// Quantile returns estimated duration in milliseconds of a quantile, q is
+// between 0 and 1
+func (ls LatencySketch) Quantile(q float64) int {
+	if ls.Count == 0 {
+		return 0
+	}
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	rank := int(math.Ceil(q * float64(ls.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank <= ls.Zero {
+		return 0
+	}
+	total := ls.Zero
+	for i, n := range ls.Bins {
+		total += n
+		if total >= rank {
+			value := 2 * math.Pow(latencyGamma, float64(ls.Offset+i)) / (latencyGamma + 1)
+			return int(math.Round(value))
+		}
+	}
+	return int(math.Round(math.Pow(latencyGamma, float64(ls.Offset+len(ls.Bins)-1))))
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLatencySketch(t *testing.T) {
+	var sketch LatencySketch
+	for i := 1; i <= 1000; i++ {
+		sketch.Add(i)
+	}
+	for q, expected := range map[float64]float64{0.5: 500, 0.9: 900, 0.95: 950, 0.99: 990} {
+		value := float64(sketch.Quantile(q))
+		if math.Abs(value-expected)/expected > 0.05 {
+			t.Fatalf("q%v expected %v but got %v", q, expected, value)
+		}
+	}
+	if sketch.Count != 1000 {
+		t.Fatal("expected 1000 but got", sketch.Count)
+	}
+}
+
+func TestLatencySketchZero(t *testing.T) {
+	var sketch LatencySketch
+	if sketch.Quantile(0.99) != 0 {
+		t.Fatal("expected 0 from an empty sketch")
+	}
+	sketch.Add(0)
+	sketch.Add(0)
+	sketch.Add(100)
+	if sketch.Quantile(0.5) != 0 {
+		t.Fatal("expected 0 but got", sketch.Quantile(0.5))
+	}
+	if value := sketch.Quantile(0.99); value < 95 || value > 105 {
+		t.Fatal("expected 100 but got", value)
+	}
+}
+
+func TestLatencySketchMerge(t *testing.T) {
+	var all, low, high LatencySketch
+	for i := 1; i <= 100; i++ {
+		all.Add(i)
+		low.Add(i)
+	}
+	for i := 1000; i <= 1100; i++ {
+		all.Add(i)
+		high.Add(i)
+	}
+	merged := high.Clone()
+	merged.Merge(low)
+	if merged.Count != all.Count || merged.Offset != all.Offset || len(merged.Bins) != len(all.Bins) {
+		t.Fatal("merged sketch differs", merged.Count, merged.Offset, len(merged.Bins))
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		if merged.Quantile(q) != all.Quantile(q) {
+			t.Fatalf("q%v expected %v but got %v", q, all.Quantile(q), merged.Quantile(q))
+		}
+	}
+	if len(high.Bins) == len(merged.Bins) {
+		t.Fatal("clone should not share bins")
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// event categories
+const (
+	EventAssertion         = "assertion"
+	EventAuthFailure       = "authFailure"
+	EventCheckpoint        = "checkpoint"
+	EventElection          = "election"
+	EventRollback          = "rollback"
+	EventSlowWTTransaction = "slowWTTransaction"
+	EventWriteConflict     = "writeConflict"
+)
+
+var eventCategories = []string{EventElection, EventRollback, EventWriteConflict, EventSlowWTTransaction,
+	EventCheckpoint, EventAssertion, EventAuthFailure}
+
+// LogEvents stores analytics of all logv2 entries, not only slow ops
+type LogEvents struct {
+	Categories []EventCategory `bson:"categories"`
+	Components map[string]int  `bson:"components"`
+	IDs        []EventID       `bson:"ids"`
+	Messages   []EventMessage  `bson:"messages"` // fatal, error, and warning messages
+	Severities map[string]int  `bson:"severities"`
+	Total      int             `bson:"total"`
+
+	ids      map[eventIDKey]*EventID // IDs and Messages are built from maps in sort()
+	messages map[eventMessageKey]*EventMessage
+}
+
+type eventIDKey struct {
+	component string
+	id        int
+}
+
+type eventMessageKey struct {
+	component string
+	id        int
+	message   string
+	severity  string
+}
+
+// EventCategory stores counts and per minute counts of a category
+type EventCategory struct {
+	Count    int         `bson:"count"`
+	Name     string      `bson:"name"`
+	Timeline []TimePoint `bson:"timeline"`
+}
+
+// EventID stores counts of a log id
+type EventID struct {
+	Component string `bson:"c"`
+	Count     int    `bson:"count"`
+	ID        int    `bson:"id"`
+	Message   string `bson:"msg"`
+}
+
+// EventMessage stores counts of a fatal, error, or warning message
+type EventMessage struct {
+	Component string `bson:"c"`
+	Count     int    `bson:"count"`
+	First     string `bson:"first"`
+	ID        int    `bson:"id"`
+	Last      string `bson:"last"`
+	Message   string `bson:"msg"`
+	Severity  string `bson:"s"`
+}
+
+// NewLogEvents returns LogEvents
+func NewLogEvents() *LogEvents {
+	events := LogEvents{Components: map[string]int{}, Severities: map[string]int{}}
+	for _, name := range eventCategories {
+		events.Categories = append(events.Categories, EventCategory{Name: name})
+	}
+	return &events
+}
+
+// Add adds a logv2 entry
+func (le *LogEvents) Add(doc Logv2, str string) {
+	le.Total++
+	le.Components[doc.Component]++
+	le.Severities[doc.Severity]++
+	le.addID(EventID{Component: doc.Component, Count: 1, ID: doc.ID, Message: doc.Message})
+	timestamp := doc.Timestamp["$date"]
+	utc := ""
+	if len(timestamp) >= 16 {
+		utc = timestamp[:16] + ":00Z"
+	}
+	for _, category := range getEventCategories(doc, str) {
+		le.addCategory(EventCategory{Count: category.count, Name: category.name,
+			Timeline: []TimePoint{{Count: category.count, UTC: utc}}})
+	}
+	if doc.Severity == "F" || doc.Severity == "E" || doc.Severity == "W" {
+		le.addMessage(EventMessage{Component: doc.Component, Count: 1, First: timestamp, ID: doc.ID,
+			Last: timestamp, Message: doc.Message, Severity: doc.Severity})
+	}
+}
+
+type eventCount struct {
+	count int
+	name  string
+}
+
+// getEventCategories classifies a logv2 entry
+func getEventCategories(doc Logv2, str string) []eventCount {
+	categories := []eventCount{}
+	msg := doc.Message
+	lower := strings.ToLower(msg)
+	if doc.Component == "ELECTION" || strings.Contains(lower, "election") {
+		categories = append(categories, eventCount{1, EventElection})
+	}
+	if doc.Component == "ROLLBACK" || strings.Contains(lower, "rollback") {
+		categories = append(categories, eventCount{1, EventRollback})
+	}
+	if doc.Attributes.WriteConflicts > 0 {
+		categories = append(categories, eventCount{doc.Attributes.WriteConflicts, EventWriteConflict})
+	} else if strings.Contains(msg, "WriteConflict") || strings.Contains(str, `"codeName":"WriteConflict"`) {
+		categories = append(categories, eventCount{1, EventWriteConflict})
+	}
+	if strings.Contains(msg, "Slow WT transaction") {
+		categories = append(categories, eventCount{1, EventSlowWTTransaction})
+	}
+	if doc.Component == "WTCHKPT" || strings.Contains(str, "Checkpoint has been running") {
+		categories = append(categories, eventCount{1, EventCheckpoint})
+	}
+	if doc.Component == "ASSERT" || strings.HasPrefix(msg, "Assertion") {
+		categories = append(categories, eventCount{1, EventAssertion})
+	}
+	if strings.Contains(msg, "Authentication failed") || strings.Contains(msg, "Unauthorized") ||
+		strings.Contains(lower, "not authorized") {
+		categories = append(categories, eventCount{1, EventAuthFailure})
+	}
+	return categories
+}
+
+func (le *LogEvents) addCategory(category EventCategory) {
+	for i := range le.Categories {
+		if le.Categories[i].Name == category.Name {
+			le.Categories[i].Count += category.Count
+			for _, point := range category.Timeline {
+				le.Categories[i].Timeline = addTimePoint(le.Categories[i].Timeline, point)
+			}
+			return
+		}
+	}
+	le.Categories = append(le.Categories, category)
+}
+
+func (le *LogEvents) addID(id EventID) {
+	if le.ids == nil {
+		le.ids = map[eventIDKey]*EventID{}
+		for i := range le.IDs {
+			le.ids[eventIDKey{le.IDs[i].Component, le.IDs[i].ID}] = &le.IDs[i]
+		}
+	}
+	key := eventIDKey{id.Component, id.ID}
+	if e, ok := le.ids[key]; ok {
+		e.Count += id.Count
+		return
+	}
+	le.ids[key] = &id
+}
+
+func (le *LogEvents) addMessage(msg EventMessage) {
+	if le.messages == nil {
+		le.messages = map[eventMessageKey]*EventMessage{}
+		for i := range le.Messages {
+			m := &le.Messages[i]
+			le.messages[eventMessageKey{m.Component, m.ID, m.Message, m.Severity}] = m
+		}
+	}
+	key := eventMessageKey{msg.Component, msg.ID, msg.Message, msg.Severity}
+	m, ok := le.messages[key]
+	if !ok {
+		le.messages[key] = &msg
+		return
+	}
+	m.Count += msg.Count
+	if msg.First < m.First {
+		m.First = msg.First
+	}
+	if msg.Last > m.Last {
+		m.Last = msg.Last
+	}
+}
+
+// Merge merges events of another LogEvents
+func (le *LogEvents) Merge(other *LogEvents) {
+	if other == nil {
+		return
+	}
+	le.Total += other.Total
+	for k, v := range other.Components {
+		le.Components[k] += v
+	}
+	for k, v := range other.Severities {
+		le.Severities[k] += v
+	}
+	for _, id := range other.IDs {
+		le.addID(id)
+	}
+	for _, msg := range other.Messages {
+		le.addMessage(msg)
+	}
+	for _, category := range other.Categories {
+		found := false
+		for i := range le.Categories {
+			if le.Categories[i].Name == category.Name {
+				le.Categories[i].Count += category.Count
+				le.Categories[i].Timeline = mergeTimeline(le.Categories[i].Timeline, category.Timeline)
+				found = true
+				break
+			}
+		}
+		if !found {
+			category.Timeline = mergeTimeline(nil, category.Timeline)
+			le.Categories = append(le.Categories, category)
+		}
+	}
+	le.sort()
+}
+
+// sort builds ids and messages sorted by counts, and sorts timelines by time
+func (le *LogEvents) sort() {
+	for i := range le.Categories {
+		le.Categories[i].Timeline = mergeTimeline(nil, le.Categories[i].Timeline)
+	}
+	if le.ids != nil {
+		le.IDs = make([]EventID, 0, len(le.ids))
+		for _, id := range le.ids {
+			le.IDs = append(le.IDs, *id)
+		}
+		le.ids = nil
+	}
+	if le.messages != nil {
+		le.Messages = make([]EventMessage, 0, len(le.messages))
+		for _, msg := range le.messages {
+			le.Messages = append(le.Messages, *msg)
+		}
+		le.messages = nil
+	}
+	sort.Slice(le.IDs, func(i, j int) bool {
+		x, y := le.IDs[i], le.IDs[j]
+		if x.Count != y.Count {
+			return x.Count > y.Count
+		} else if x.ID != y.ID {
+			return x.ID < y.ID
+		}
+		return x.Component < y.Component
+	})
+	sort.Slice(le.Messages, func(i, j int) bool {
+		x, y := le.Messages[i], le.Messages[j]
+		if x.Count != y.Count {
+			return x.Count > y.Count
+		} else if x.Severity != y.Severity {
+			return x.Severity < y.Severity // F, E, then W
+		} else if x.ID != y.ID {
+			return x.ID < y.ID
+		} else if x.Component != y.Component {
+			return x.Component < y.Component
+		}
+		return x.Message < y.Message
+	})
+}
+
+// String returns events summary
+func (le *LogEvents) String() string {
+	if le == nil || le.Total == 0 {
+		return ""
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Log events analytics of %d entries:\n", le.Total))
+	buffer.WriteString("  severity:  " + getCountsString(le.Severities, 0) + "\n")
+	buffer.WriteString("  component: " + getCountsString(le.Components, 10) + "\n")
+	for _, category := range le.Categories {
+		if category.Count == 0 {
+			continue
+		}
+		peak := TimePoint{}
+		for _, point := range category.Timeline {
+			if point.Count > peak.Count {
+				peak = point
+			}
+		}
+		buffer.WriteString(fmt.Sprintf("  %-18s %d, peaked at %v with %d\n", category.Name+":", category.Count, peak.UTC, peak.Count))
+	}
+	if len(le.Messages) > 0 {
+		buffer.WriteString("  top fatal, error and warning messages:\n")
+		for i, msg := range le.Messages {
+			if i >= 10 {
+				break
+			}
+			buffer.WriteString(fmt.Sprintf("    %v %-8s %6d %6dx %v (%v - %v)\n", msg.Severity, msg.Component, msg.ID,
+				msg.Count, msg.Message, msg.First, msg.Last))
+		}
+	}
+	return buffer.String()
+}
+
+// getCountsString returns counts in descending order, top n if n > 0
+func getCountsString(counts map[string]int, n int) string {
+	keys := []string{}
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	strs := []string{}
+	for i, k := range keys {
+		if n > 0 && i >= n {
+			break
+		}
+		strs = append(strs, fmt.Sprintf("%v: %d", k, counts[k]))
+	}
+	return strings.Join(strs, ", ")
+}
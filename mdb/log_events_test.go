@@ -0,0 +1,86 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+var eventLogs = []string{
+	`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"ELECTION","id":21450,"ctx":"ReplCoord-1","msg":"Election succeeded, assuming primary role","attr":{"term":5}}`,
+	`{"t":{"$date":"2021-03-01T10:00:20.000+00:00"},"s":"I","c":"REPL","id":21358,"ctx":"ReplCoord-1","msg":"Replica set state transition","attr":{"newState":"PRIMARY","oldState":"SECONDARY"}}`,
+	`{"t":{"$date":"2021-03-01T10:01:02.000+00:00"},"s":"W","c":"WTCHKPT","id":22430,"ctx":"Checkpointer","msg":"WiredTiger message","attr":{"message":"Checkpoint has been running for 65 seconds"}}`,
+	`{"t":{"$date":"2021-03-01T10:01:05.000+00:00"},"s":"I","c":"ACCESS","id":20249,"ctx":"conn12","msg":"Authentication failed","attr":{"mechanism":"SCRAM-SHA-256","principalName":"app","error":"AuthenticationFailed"}}`,
+	`{"t":{"$date":"2021-03-01T10:01:06.000+00:00"},"s":"I","c":"ACCESS","id":20249,"ctx":"conn13","msg":"Authentication failed","attr":{"mechanism":"SCRAM-SHA-256","principalName":"app","error":"AuthenticationFailed"}}`,
+	`{"t":{"$date":"2021-03-01T10:02:00.000+00:00"},"s":"E","c":"ASSERT","id":23076,"ctx":"conn14","msg":"Assertion","attr":{"error":"BadValue"}}`,
+	`{"t":{"$date":"2021-03-01T10:02:10.000+00:00"},"s":"I","c":"WRITE","id":51803,"ctx":"conn15","msg":"Slow query","attr":{"type":"update","ns":"keyhole.cars","command":{"q":{"color":"red"},"u":{"$set":{"sold":true}}},"planSummary":"COLLSCAN","docsExamined":100,"writeConflicts":3,"durationMillis":120}}`,
+}
+
+func TestLogEvents(t *testing.T) {
+	li := NewLogInfo("utest-xxxxxx")
+	li.SetSilent(true)
+	if err := li.Parse(bufio.NewReader(strings.NewReader(strings.Join(eventLogs, "\n")))); err != nil {
+		t.Fatal(err)
+	}
+	events := li.Events
+	if events == nil || events.Total != len(eventLogs) {
+		t.Fatal("expected all entries counted", events)
+	}
+	if events.Severities["I"] != 5 || events.Severities["W"] != 1 || events.Severities["E"] != 1 {
+		t.Fatal("unexpected severities", events.Severities)
+	}
+	if events.Components["ACCESS"] != 2 {
+		t.Fatal("unexpected components", events.Components)
+	}
+	if events.IDs[0].ID != 20249 || events.IDs[0].Count != 2 {
+		t.Fatal("unexpected top id", events.IDs[0])
+	}
+	counts := map[string]int{}
+	for _, category := range events.Categories {
+		counts[category.Name] = category.Count
+	}
+	expected := map[string]int{EventElection: 1, EventCheckpoint: 1, EventAuthFailure: 2, EventAssertion: 1,
+		EventWriteConflict: 3, EventRollback: 0, EventSlowWTTransaction: 0}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Fatal(name, "expected", count, "but got", counts[name])
+		}
+	}
+	if len(events.Messages) != 2 || events.Messages[0].Severity != "E" {
+		t.Fatal("unexpected messages", events.Messages)
+	}
+	if len(li.OpPatterns) != 1 {
+		t.Fatal("expected slow op still analyzed", li.OpPatterns)
+	}
+}
+
+func TestLogEventsMerge(t *testing.T) {
+	x := NewLogEvents()
+	y := NewLogEvents()
+	for i, str := range eventLogs {
+		li := NewLogInfo("utest-xxxxxx")
+		li.SetSilent(true)
+		li.Parse(bufio.NewReader(strings.NewReader(str)))
+		if i%2 == 0 {
+			x.Merge(li.Events)
+		} else {
+			y.Merge(li.Events)
+		}
+	}
+	x.Merge(y)
+	if x.Total != len(eventLogs) {
+		t.Fatal("expected", len(eventLogs), "but got", x.Total)
+	}
+	for _, category := range x.Categories {
+		if category.Name == EventAuthFailure {
+			if category.Count != 2 || len(category.Timeline) != 1 || category.Timeline[0].Count != 2 {
+				t.Fatal("unexpected auth failures", category)
+			}
+		}
+	}
+	if len(x.Messages) != 2 || x.Messages[0].Severity != "E" || x.Messages[1].First != "2021-03-01T10:01:02.000+00:00" {
+		t.Fatal("unexpected messages", x.Messages)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"strings"
+	"sync"
+)
+
+// number of lines of a batch sent to a worker
+var logBatchSize = 1000
+
+// logBatch stores a batch of lines and their parsed results
+type logBatch struct {
+	lines   []string
+	logType string
+	results []logLine
+	seq     int
+}
+
+// parseParallel reads lines in batches, parses batches with workers, and
+// aggregates results in the order of batches, so that results are the same
+// as of parsing serially
+func (li *LogInfo) parseParallel(reader *bufio.Reader, lp *logParser) error {
+	batches := make(chan *logBatch, li.workers*2)
+	results := make(chan *logBatch, li.workers*2)
+	go func() {
+		defer close(batches)
+		index := 0
+		logType := ""
+		batch := &logBatch{}
+		for {
+			str, err := readLogLine(reader)
+			if err != nil {
+				break
+			}
+			index++
+			if len(str) == 0 {
+				continue
+			}
+			if logType == "" {
+				logType = getLogType(str)
+				batch.logType = logType
+			}
+			if !li.isSampled(index, str) {
+				continue
+			}
+			batch.lines = append(batch.lines, str)
+			if len(batch.lines) >= logBatchSize {
+				batches <- batch
+				batch = &logBatch{logType: logType, seq: batch.seq + 1}
+			}
+		}
+		if len(batch.lines) > 0 {
+			batches <- batch
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < li.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				batch.results = make([]logLine, 0, len(batch.lines))
+				for _, str := range batch.lines {
+					batch.results = append(batch.results, li.parseLine(str, batch.logType))
+				}
+				batch.lines = nil
+				results <- batch
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	next := 0
+	pending := map[int]*logBatch{}
+	for batch := range results {
+		pending[batch.seq] = batch
+		for {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			for _, line := range b.results {
+				li.aggregate(lp, b.logType, line)
+			}
+			delete(pending, next)
+			next++
+		}
+	}
+	return nil
+}
+
+// isSampled returns true if a line of the index is sampled, only slow ops are sampled and
+// other lines, e.g. build info, client metadata, connections, and transactions, are all kept
+func (li *LogInfo) isSampled(index int, str string) bool {
+	if li.SampleRate <= 0 || li.SampleRate >= 1 || !isSlowOpLine(str) {
+		return true
+	}
+	// Knuth's multiplicative hash spreads indexes evenly to avoid sampling periodic logs
+	return float64(uint32(index)*2654435761)/(1<<32) < li.SampleRate
+}
+
+// isSlowOpLine returns true if a line is a slow op of logv2 or text logs
+func isSlowOpLine(str string) bool {
+	if strings.HasPrefix(str, "{") {
+		return strings.Contains(str, `"Slow query"`)
+	}
+	return strings.HasSuffix(str, "ms")
+}
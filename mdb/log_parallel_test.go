@@ -0,0 +1,95 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getParallelTestLogs() string {
+	lines := []string{}
+	for i := 0; i < 5; i++ {
+		lines = append(lines, eventLogs...)
+		lines = append(lines, clientLogs...)
+		lines = append(lines, txnLogs...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestParseParallel(t *testing.T) {
+	batchSize := logBatchSize
+	logBatchSize = 3
+	defer func() { logBatchSize = batchSize }()
+	logs := getParallelTestLogs()
+
+	serial := NewLogInfo("utest-xxxxxx")
+	serial.SetSilent(true)
+	if err := serial.Parse(bufio.NewReader(strings.NewReader(logs))); err != nil {
+		t.Fatal(err)
+	}
+	parallel := NewLogInfo("utest-xxxxxx")
+	parallel.SetSilent(true)
+	parallel.SetWorkers(4)
+	if err := parallel.Parse(bufio.NewReader(strings.NewReader(logs))); err != nil {
+		t.Fatal(err)
+	}
+	if len(serial.OpPatterns) == 0 {
+		t.Fatal("expected op patterns")
+	}
+	if serial.DBVersion != parallel.DBVersion || serial.LogType != parallel.LogType {
+		t.Fatal("unexpected version or log type", parallel.DBVersion, parallel.LogType)
+	}
+	if !reflect.DeepEqual(serial.OpPatterns, parallel.OpPatterns) || !reflect.DeepEqual(serial.Histograms, parallel.Histograms) ||
+		!reflect.DeepEqual(serial.SlowOps, parallel.SlowOps) || !reflect.DeepEqual(serial.Timelines, parallel.Timelines) {
+		t.Fatal("parallel results of slow ops are different from serial results")
+	}
+	if !reflect.DeepEqual(serial.Events, parallel.Events) || !reflect.DeepEqual(serial.Transactions, parallel.Transactions) ||
+		!reflect.DeepEqual(serial.Clients, parallel.Clients) {
+		t.Fatal("parallel results of events are different from serial results")
+	}
+}
+
+func TestParseSampled(t *testing.T) {
+	li := NewLogInfo("utest-xxxxxx")
+	li.SetSilent(true)
+	if err := li.SetSampleRate(1.5); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := li.SetSampleRate(0.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := li.Parse(bufio.NewReader(strings.NewReader(getParallelTestLogs()))); err != nil {
+		t.Fatal(err)
+	}
+	total := 5 * (len(eventLogs) + len(clientLogs) + len(txnLogs))
+	if li.Events == nil || li.Events.Total == 0 || li.Events.Total >= total {
+		t.Fatal("expected part of lines sampled", li.Events)
+	}
+	if len(li.Clients) != 2 || li.Transactions == nil || li.Transactions.Count != 5*3 {
+		t.Fatal("expected clients and transactions of all lines", li.Clients, li.Transactions)
+	}
+
+	// slow ops are sampled, and sampled ops are attributed to apps of client metadata always kept
+	expected := map[string]int{}
+	for i, str := range strings.Split(getParallelTestLogs(), "\n") {
+		if !li.isSampled(i+1, str) {
+			continue
+		} else if strings.Contains(str, `"status":"new"`) {
+			expected["orders-svc"]++
+		} else if strings.Contains(str, `"status":"paid"`) {
+			expected["billing"]++
+		}
+	}
+	apps := map[string]int{}
+	for _, op := range li.OpPatterns {
+		for _, app := range op.Apps {
+			apps[app.App] += app.Count
+		}
+	}
+	if expected["orders-svc"] == 0 || expected["orders-svc"]+expected["billing"] >= 10 || !reflect.DeepEqual(apps, expected) {
+		t.Fatal("unexpected apps of sampled ops", apps, expected)
+	}
+}
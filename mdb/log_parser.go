@@ -11,6 +11,9 @@ import (
 	"github.com/simagix/gox"
 )
 
+var examinedRegexp = regexp.MustCompile(` (docsExamined|keysExamined|nreturned|numYields):(\d+)`)
+var queryHashRegexp = regexp.MustCompile(` queryHash:(\w+)`)
+
 var hasFilters = map[string]bool{"count": true, "delete": true, "find": true, "remove": true, "update": true, "aggregate": true, "getMore": true, "getmore": true, "findAndModify": true, "distinct": true}
 
 // ParseLog - parses text message before v4.4
@@ -241,7 +244,22 @@ func (li *LogInfo) ParseLog(str string) (LogStats, error) {
 		resLength = ToInt(reslen[:idx])
 	}
 	stat = LogStats{filter: filter, index: index, milli: milli, ns: ns, op: op,
-		reslen: resLength, scan: scan, utc: utc}
+		reslen: resLength, scan: scan, timestamp: result[1], utc: utc}
+	for _, m := range examinedRegexp.FindAllStringSubmatch(str, -1) {
+		switch m[1] {
+		case "docsExamined":
+			stat.docsExamined = ToInt(m[2])
+		case "keysExamined":
+			stat.keysExamined = ToInt(m[2])
+		case "nreturned":
+			stat.nreturned = ToInt(m[2])
+		case "numYields":
+			stat.numYields = ToInt(m[2])
+		}
+	}
+	if m := queryHashRegexp.FindStringSubmatch(str); m != nil {
+		stat.queryHash = m[1]
+	}
 	return stat, nil
 }
 
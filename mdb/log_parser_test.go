@@ -15,6 +15,8 @@ func TestParseLogFind(t *testing.T) {
 		t.Fatal(err)
 	} else if stat.filter != `{tveUserId:1}, sort: { updated: -1 }` {
 		t.Fatal(stat.filter)
+	} else if stat.keysExamined != 29 || stat.docsExamined != 29 || stat.nreturned != 29 || stat.numYields != 1 {
+		t.Fatal("unexpected examined stats", stat)
 	}
 }
 
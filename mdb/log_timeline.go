@@ -0,0 +1,189 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimePoint stores ops count and time of a minute
+type TimePoint struct {
+	Count      int    `bson:"count"`
+	TotalMilli int64  `bson:"totalmilli"`
+	UTC        string `bson:"utc"`
+}
+
+// NamespaceTimeline stores per minute ops of a namespace
+type NamespaceTimeline struct {
+	Namespace string      `bson:"ns"`
+	Points    []TimePoint `bson:"points"`
+}
+
+// timeBound is the start or the end time of analysis
+type timeBound struct {
+	t    time.Time
+	wall bool // compares wall clock time of logs if no time zone is given
+}
+
+// time layouts of logs, logv2 and text logs
+var logTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999-0700", "2006-01-02T15:04:05-0700"}
+
+// time layouts of --from and --to without time zones and their precisions
+var windowLayouts = []struct {
+	layout    string
+	precision time.Duration
+}{
+	{"2006-01-02T15:04:05.999", time.Millisecond},
+	{"2006-01-02T15:04:05", time.Second},
+	{"2006-01-02T15:04", time.Minute},
+	{"2006-01-02T15", time.Hour},
+	{"2006-01-02", 24 * time.Hour},
+}
+
+// parseTimeBound parses time of --from or --to, an end time is inclusive to
+// its precision, e.g. 14:17 includes all logs of 14:17:xx
+func parseTimeBound(str string, isEnd bool) (*timeBound, error) {
+	if str == "" {
+		return nil, nil
+	}
+	str = strings.Replace(strings.TrimSpace(str), " ", "T", 1)
+	if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+		return &timeBound{t: t}, nil
+	}
+	for _, wl := range windowLayouts {
+		if t, err := time.Parse(wl.layout, str); err == nil {
+			if isEnd {
+				t = t.Add(wl.precision - time.Nanosecond)
+			}
+			return &timeBound{t: t, wall: true}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time %v, use format 2006-01-02T15:04:05 or RFC3339", str)
+}
+
+// parseLogTime parses timestamp of a log
+func parseLogTime(str string) (time.Time, error) {
+	var err error
+	var t time.Time
+	for _, layout := range logTimeLayouts {
+		if t, err = time.Parse(layout, str); err == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+// compare returns -1, 0, or 1 if t is before, equal to, or after the bound
+func (tb *timeBound) compare(t time.Time) int {
+	if tb.wall {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
+	if t.Before(tb.t) {
+		return -1
+	} else if t.After(tb.t) {
+		return 1
+	}
+	return 0
+}
+
+// isInTimeWindow returns true if a log timestamp is within --from and --to
+func (li *LogInfo) isInTimeWindow(timestamp string) bool {
+	if li.from == nil && li.to == nil {
+		return true
+	}
+	t, err := parseLogTime(timestamp)
+	if err != nil {
+		return false
+	}
+	if li.from != nil && li.from.compare(t) < 0 {
+		return false
+	}
+	if li.to != nil && li.to.compare(t) > 0 {
+		return false
+	}
+	return true
+}
+
+// addTimePoint adds a point, e.g. of an op, to a timeline
+func addTimePoint(timeline []TimePoint, point TimePoint) []TimePoint {
+	if n := len(timeline); n > 0 && timeline[n-1].UTC == point.UTC {
+		timeline[n-1].Count += point.Count
+		timeline[n-1].TotalMilli += point.TotalMilli
+		return timeline
+	}
+	return append(timeline, point)
+}
+
+// mergeTimeline combines points of the same minute and sorts by time
+func mergeTimeline(timeline []TimePoint, others []TimePoint) []TimePoint {
+	pointsMap := map[string]int{}
+	merged := []TimePoint{}
+	for _, points := range [][]TimePoint{timeline, others} {
+		for _, point := range points {
+			if i, ok := pointsMap[point.UTC]; ok {
+				merged[i].Count += point.Count
+				merged[i].TotalMilli += point.TotalMilli
+				continue
+			}
+			pointsMap[point.UTC] = len(merged)
+			merged = append(merged, point)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].UTC < merged[j].UTC
+	})
+	return merged
+}
+
+// mergeNamespaceTimelines merges timelines by namespace
+func mergeNamespaceTimelines(timelines []NamespaceTimeline, others []NamespaceTimeline) []NamespaceTimeline {
+	nsMap := map[string]int{}
+	for i, timeline := range timelines {
+		nsMap[timeline.Namespace] = i
+	}
+	for _, other := range others {
+		if i, ok := nsMap[other.Namespace]; ok {
+			timelines[i].Points = mergeTimeline(timelines[i].Points, other.Points)
+			continue
+		}
+		nsMap[other.Namespace] = len(timelines)
+		timelines = append(timelines, NamespaceTimeline{Namespace: other.Namespace, Points: mergeTimeline(nil, other.Points)})
+	}
+	sort.SliceStable(timelines, func(i, j int) bool {
+		return timelines[i].Namespace < timelines[j].Namespace
+	})
+	return timelines
+}
+
+// getPeakNamespaces returns namespaces of the highest minute ops counts
+func getPeakNamespaces(timelines []NamespaceTimeline, n int) []string {
+	type peak struct {
+		ns    string
+		point TimePoint
+	}
+	peaks := []peak{}
+	for _, timeline := range timelines {
+		p := peak{ns: timeline.Namespace}
+		for _, point := range timeline.Points {
+			if point.Count > p.point.Count {
+				p.point = point
+			}
+		}
+		peaks = append(peaks, p)
+	}
+	sort.SliceStable(peaks, func(i, j int) bool {
+		return peaks[i].point.Count > peaks[j].point.Count
+	})
+	strs := []string{}
+	for i, p := range peaks {
+		if i >= n {
+			break
+		}
+		strs = append(strs, fmt.Sprintf("%v peaked at %v with %d ops, %v total", p.ns, p.point.UTC, p.point.Count,
+			milliToString(float64(p.point.TotalMilli))))
+	}
+	return strs
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	tb, err := parseTimeBound("2021-03-01T14:17", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tb.wall || tb.t.Format("2006-01-02T15:04:05") != "2021-03-01T14:17:59" {
+		t.Fatal("unexpected end time", tb.t)
+	}
+	if tb, err = parseTimeBound("2021-03-01T14:02:00-05:00", false); err != nil {
+		t.Fatal(err)
+	} else if tb.wall {
+		t.Fatal("expected absolute time")
+	}
+	if _, err = parseTimeBound("03/01/2021", false); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestIsInTimeWindow(t *testing.T) {
+	loginfo := NewLogInfo("utest-xxxxxx")
+	if err := loginfo.SetTimeWindow("2021-03-01T14:02", "2021-03-01T14:17"); err != nil {
+		t.Fatal(err)
+	}
+	for timestamp, expected := range map[string]bool{
+		"2021-03-01T14:01:59.999-05:00": false,
+		"2021-03-01T14:02:00.000-05:00": true,
+		"2021-03-01T14:17:59.999+00:00": true,
+		"2021-03-01T14:18:00.000+00:00": false,
+		"2021-03-01T14:10:00.000-0400":  true,
+		"Mon Mar  1 14:10:00.000":       false,
+	} {
+		if loginfo.isInTimeWindow(timestamp) != expected {
+			t.Fatal("unexpected result of", timestamp)
+		}
+	}
+	if err := loginfo.SetTimeWindow("2021-03-01T14:02:00Z", ""); err != nil {
+		t.Fatal(err)
+	}
+	if loginfo.isInTimeWindow("2021-03-01T14:30:00.000+01:00") {
+		t.Fatal("expected out of window")
+	}
+}
+
+func TestLogInfoTimelines(t *testing.T) {
+	str := `{"t":{"$date":"2021-03-01T14:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"red"}},"planSummary":"COLLSCAN","durationMillis":200}}
+{"t":{"$date":"2021-03-01T14:05:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"red"}},"planSummary":"COLLSCAN","durationMillis":300}}
+{"t":{"$date":"2021-03-01T14:05:02.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"keyhole.dealers","command":{"find":"dealers","filter":{"state":"NY"}},"planSummary":"COLLSCAN","durationMillis":100}}
+{"t":{"$date":"2021-03-01T14:05:03.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"red"}},"planSummary":"COLLSCAN","durationMillis":400}}`
+	loginfo := NewLogInfo("utest-xxxxxx")
+	loginfo.SetSilent(true)
+	if err := loginfo.SetTimeWindow("2021-03-01T14:05", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := loginfo.Parse(bufio.NewReader(strings.NewReader(str))); err != nil {
+		t.Fatal(err)
+	}
+	if len(loginfo.Timelines) != 2 || loginfo.Timelines[0].Namespace != "keyhole.cars" {
+		t.Fatal("unexpected timelines", loginfo.Timelines)
+	}
+	points := loginfo.Timelines[0].Points
+	if len(points) != 1 || points[0].Count != 2 || points[0].TotalMilli != 700 {
+		t.Fatal("unexpected points", points)
+	}
+	for _, op := range loginfo.OpPatterns {
+		if op.Namespace == "keyhole.cars" && (op.Count != 2 || len(op.Timeline) != 1) {
+			t.Fatal("unexpected pattern", op)
+		}
+	}
+	peaks := getPeakNamespaces(loginfo.Timelines, 5)
+	if len(peaks) != 2 || !strings.HasPrefix(peaks[0], "keyhole.cars peaked at") {
+		t.Fatal("unexpected peaks", peaks)
+	}
+}
+
+func TestMergeTimeline(t *testing.T) {
+	a := []TimePoint{{Count: 1, TotalMilli: 10, UTC: "2021-03-01T14:01:00Z"}, {Count: 2, TotalMilli: 20, UTC: "2021-03-01T14:03:00Z"}}
+	b := []TimePoint{{Count: 3, TotalMilli: 30, UTC: "2021-03-01T14:02:00Z"}, {Count: 4, TotalMilli: 40, UTC: "2021-03-01T14:03:00Z"}}
+	merged := mergeTimeline(a, b)
+	if len(merged) != 3 || merged[1].UTC != "2021-03-01T14:02:00Z" || merged[2].Count != 6 || merged[2].TotalMilli != 60 {
+		t.Fatal("unexpected timeline", merged)
+	}
+	if a[1].Count != 2 {
+		t.Fatal("input should not be modified")
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const logIDTransaction = 51802
+const maxLongestTransactions = 10
+
+// LogTransactions stores analytics of multi-document transactions
+type LogTransactions struct {
+	AbortReasons        map[string]int         `bson:"abortReasons"`
+	Aborted             int                    `bson:"aborted"`
+	Committed           int                    `bson:"committed"`
+	Count               int                    `bson:"count"`
+	Longest             []TransactionInfo      `bson:"longest"`
+	Namespaces          []TransactionNamespace `bson:"namespaces"`
+	TotalActiveMicros   int64                  `bson:"totalActiveMicros"`
+	TotalInactiveMicros int64                  `bson:"totalInactiveMicros"`
+	TotalLockWaitMicros int64                  `bson:"totalLockWaitMicros"`
+	TotalMilli          int64                  `bson:"totalMilli"`
+	TotalNumYields      int64                  `bson:"totalNumYields"`
+}
+
+// TransactionInfo stores a logged transaction
+type TransactionInfo struct {
+	AbortCause         string   `bson:"abortCause,omitempty"`
+	LockWaitMicros     int64    `bson:"lockWaitMicros"`
+	LSID               string   `bson:"lsid"`
+	Milli              int      `bson:"milli"`
+	Namespaces         []string `bson:"namespaces,omitempty"`
+	NumYields          int      `bson:"numYields"`
+	TerminationCause   string   `bson:"terminationCause"`
+	TimeActiveMicros   int64    `bson:"timeActiveMicros"`
+	TimeInactiveMicros int64    `bson:"timeInactiveMicros"`
+	Timestamp          string   `bson:"timestamp"`
+	TxnNumber          int64    `bson:"txnNumber"`
+}
+
+// TransactionNamespace stores transactions counts of a namespace
+type TransactionNamespace struct {
+	Aborted   int    `bson:"aborted"`
+	Count     int    `bson:"count"`
+	Namespace string `bson:"ns"`
+}
+
+// NewLogTransactions returns LogTransactions
+func NewLogTransactions() *LogTransactions {
+	return &LogTransactions{AbortReasons: map[string]int{}}
+}
+
+// getLSID returns session id from a lsid document
+func getLSID(lsid interface{}) string {
+	doc, ok := lsid.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if id, ok := doc["id"].(map[string]interface{}); ok {
+		if uuid, ok := id["$uuid"].(string); ok {
+			return uuid
+		}
+	}
+	return ""
+}
+
+// getTxnKey returns key of a transaction, i.e. lsid and txnNumber
+func getTxnKey(lsid string, txnNumber int64) string {
+	return fmt.Sprintf("%v.%d", lsid, txnNumber)
+}
+
+// txnOpNamespaces stores namespaces of ops by lsid and txnNumber of the current and the
+// previous minutes, older ones are dropped as transactions are limited to 60 seconds by default
+type txnOpNamespaces struct {
+	current  map[string][]string
+	minute   string
+	previous map[string][]string
+}
+
+// add adds a namespace of an op of a transaction logged at a minute
+func (t *txnOpNamespaces) add(minute string, key string, ns string) {
+	if minute != t.minute {
+		t.previous, t.current, t.minute = t.current, map[string][]string{}, minute
+	}
+	namespaces, ok := t.current[key]
+	if !ok {
+		namespaces = t.previous[key]
+		delete(t.previous, key)
+	}
+	if !contains(namespaces, ns) {
+		namespaces = append(namespaces, ns)
+	}
+	t.current[key] = namespaces
+}
+
+// remove removes and returns namespaces of a transaction
+func (t *txnOpNamespaces) remove(key string) []string {
+	namespaces, ok := t.current[key]
+	if ok {
+		delete(t.current, key)
+	} else if namespaces, ok = t.previous[key]; ok {
+		delete(t.previous, key)
+	}
+	return namespaces
+}
+
+// addTxnNamespace keeps namespaces of ops within a transaction, retryable writes of
+// txnNumber without autocommit false are not of transactions
+func (li *LogInfo) addTxnNamespace(doc Logv2) {
+	command := doc.Attributes.Command
+	if command == nil || doc.Attributes.NS == "" || command["txnNumber"] == nil {
+		return
+	}
+	if autocommit, ok := command["autocommit"].(bool); !ok || autocommit {
+		return
+	}
+	lsid := getLSID(command["lsid"])
+	ts := doc.Timestamp["$date"]
+	if lsid == "" || len(ts) < 16 {
+		return
+	}
+	li.txnNamespaces.add(ts[:16], getTxnKey(lsid, toInt64(command["txnNumber"])), doc.Attributes.NS)
+}
+
+// addTransaction adds a logged transaction
+func (li *LogInfo) addTransaction(doc Logv2) {
+	attr := doc.Attributes
+	txn := TransactionInfo{AbortCause: attr.AbortCause, LockWaitMicros: getLockWaitMicros(attr.Locks),
+		LSID: getLSID(attr.Parameters.LSID), Milli: attr.Milli, NumYields: attr.NumYields,
+		TerminationCause: attr.TerminationCause, TimeActiveMicros: attr.TimeActiveMicros,
+		TimeInactiveMicros: attr.TimeInactiveMicros, Timestamp: doc.Timestamp["$date"], TxnNumber: attr.Parameters.TxnNumber}
+	if txn.AbortCause == "" && attr.ErrName != "" {
+		txn.AbortCause = attr.ErrName
+	}
+	txn.Namespaces = li.txnNamespaces.remove(getTxnKey(txn.LSID, txn.TxnNumber))
+	if li.Transactions == nil {
+		li.Transactions = NewLogTransactions()
+	}
+	li.Transactions.Add(txn)
+}
+
+// Add adds a transaction
+func (lt *LogTransactions) Add(txn TransactionInfo) {
+	lt.Count++
+	isAborted := txn.TerminationCause == "aborted"
+	if isAborted {
+		lt.Aborted++
+		reason := txn.AbortCause
+		if reason == "" {
+			reason = "unknown"
+		}
+		lt.AbortReasons[reason]++
+	} else if txn.TerminationCause == "committed" {
+		lt.Committed++
+	}
+	lt.TotalActiveMicros += txn.TimeActiveMicros
+	lt.TotalInactiveMicros += txn.TimeInactiveMicros
+	lt.TotalLockWaitMicros += txn.LockWaitMicros
+	lt.TotalMilli += int64(txn.Milli)
+	lt.TotalNumYields += int64(txn.NumYields)
+	for _, ns := range txn.Namespaces {
+		tns := TransactionNamespace{Count: 1, Namespace: ns}
+		if isAborted {
+			tns.Aborted = 1
+		}
+		lt.addNamespace(tns)
+	}
+	lt.addLongest(txn)
+}
+
+func (lt *LogTransactions) addNamespace(tns TransactionNamespace) {
+	for i := range lt.Namespaces {
+		if lt.Namespaces[i].Namespace == tns.Namespace {
+			lt.Namespaces[i].Count += tns.Count
+			lt.Namespaces[i].Aborted += tns.Aborted
+			return
+		}
+	}
+	lt.Namespaces = append(lt.Namespaces, tns)
+}
+
+// addLongest keeps the longest transactions
+func (lt *LogTransactions) addLongest(txn TransactionInfo) {
+	if len(lt.Longest) >= maxLongestTransactions && txn.Milli <= lt.Longest[len(lt.Longest)-1].Milli {
+		return
+	}
+	lt.Longest = append(lt.Longest, txn)
+	sort.SliceStable(lt.Longest, func(i, j int) bool {
+		return lt.Longest[i].Milli > lt.Longest[j].Milli
+	})
+	if len(lt.Longest) > maxLongestTransactions {
+		lt.Longest = lt.Longest[:maxLongestTransactions]
+	}
+}
+
+// Merge merges transactions of another LogTransactions
+func (lt *LogTransactions) Merge(other *LogTransactions) {
+	if other == nil {
+		return
+	}
+	lt.Aborted += other.Aborted
+	lt.Committed += other.Committed
+	lt.Count += other.Count
+	lt.TotalActiveMicros += other.TotalActiveMicros
+	lt.TotalInactiveMicros += other.TotalInactiveMicros
+	lt.TotalLockWaitMicros += other.TotalLockWaitMicros
+	lt.TotalMilli += other.TotalMilli
+	lt.TotalNumYields += other.TotalNumYields
+	for k, v := range other.AbortReasons {
+		lt.AbortReasons[k] += v
+	}
+	for _, tns := range other.Namespaces {
+		lt.addNamespace(tns)
+	}
+	for _, txn := range other.Longest {
+		lt.addLongest(txn)
+	}
+	lt.sort()
+}
+
+// sort sorts namespaces by transactions counts
+func (lt *LogTransactions) sort() {
+	sort.SliceStable(lt.Namespaces, func(i, j int) bool {
+		if lt.Namespaces[i].Count != lt.Namespaces[j].Count {
+			return lt.Namespaces[i].Count > lt.Namespaces[j].Count
+		}
+		return lt.Namespaces[i].Namespace < lt.Namespaces[j].Namespace
+	})
+}
+
+// String returns transactions summary
+func (lt *LogTransactions) String() string {
+	if lt == nil || lt.Count == 0 {
+		return ""
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Transactions: %d, committed: %d (%.1f%%), aborted: %d (%.1f%%)\n", lt.Count,
+		lt.Committed, 100*float64(lt.Committed)/float64(lt.Count), lt.Aborted, 100*float64(lt.Aborted)/float64(lt.Count)))
+	count := float64(lt.Count)
+	buffer.WriteString(fmt.Sprintf("  avg: %v, active: %v, inactive: %v, lock wait: %v, yields: %.1f\n",
+		milliToString(float64(lt.TotalMilli)/count), milliToString(float64(lt.TotalActiveMicros)/count/1000),
+		milliToString(float64(lt.TotalInactiveMicros)/count/1000), milliToString(float64(lt.TotalLockWaitMicros)/count/1000),
+		float64(lt.TotalNumYields)/count))
+	if len(lt.AbortReasons) > 0 {
+		buffer.WriteString("  abort reasons: " + getCountsString(lt.AbortReasons, 10) + "\n")
+	}
+	if len(lt.Namespaces) > 0 {
+		strs := []string{}
+		for i, tns := range lt.Namespaces {
+			if i >= 10 {
+				break
+			}
+			strs = append(strs, fmt.Sprintf("%v: %d (%d aborted)", tns.Namespace, tns.Count, tns.Aborted))
+		}
+		buffer.WriteString("  namespaces: " + strings.Join(strs, ", ") + "\n")
+	}
+	buffer.WriteString("  longest transactions:\n")
+	for i, txn := range lt.Longest {
+		if i >= 5 {
+			break
+		}
+		str := fmt.Sprintf("    %v %v, %v, active: %v, inactive: %v, lsid: %v, txnNumber: %d", txn.Timestamp,
+			milliToString(float64(txn.Milli)), txn.TerminationCause, milliToString(float64(txn.TimeActiveMicros)/1000),
+			milliToString(float64(txn.TimeInactiveMicros)/1000), txn.LSID, txn.TxnNumber)
+		if len(txn.Namespaces) > 0 {
+			str += ", ns: " + strings.Join(txn.Namespaces, ", ")
+		}
+		buffer.WriteString(str + "\n")
+	}
+	return buffer.String()
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+var txnLogs = []string{
+	`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"bank.accounts","command":{"update":"accounts","updates":[{"q":{"_id":1},"u":{"$inc":{"balance":-10}}}],"lsid":{"id":{"$uuid":"0b2c8e9a-1111-4a4a-8b8b-000000000001"}},"txnNumber":3,"autocommit":false,"$db":"bank"},"numYields":0,"durationMillis":150}}`,
+	`{"t":{"$date":"2021-03-01T10:00:01.100+00:00"},"s":"I","c":"WRITE","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"update","ns":"bank.ledger","command":{"q":{"acct":1},"u":{"$set":{"v":1}}},"planSummary":"COLLSCAN","durationMillis":120}}`,
+	`{"t":{"$date":"2021-03-01T10:00:01.200+00:00"},"s":"I","c":"TXN","id":51802,"ctx":"conn7","msg":"transaction","attr":{"parameters":{"lsid":{"id":{"$uuid":"0b2c8e9a-1111-4a4a-8b8b-000000000001"}},"txnNumber":3,"autocommit":false},"readTimestamp":"Timestamp(0, 0)","terminationCause":"committed","timeActiveMicros":200000,"timeInactiveMicros":50000,"numYields":1,"locks":{"Global":{"acquireCount":{"w":2},"timeAcquiringMicros":{"w":3000}}},"durationMillis":250}}`,
+	`{"t":{"$date":"2021-03-01T10:00:02.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn8","msg":"Slow query","attr":{"type":"command","ns":"bank.accounts","command":{"update":"accounts","updates":[{"q":{"_id":2},"u":{"$inc":{"balance":5}}}],"lsid":{"id":{"$uuid":"0b2c8e9a-1111-4a4a-8b8b-000000000002"}},"txnNumber":1,"autocommit":false,"$db":"bank"},"numYields":0,"durationMillis":110}}`,
+	`{"t":{"$date":"2021-03-01T10:00:03.000+00:00"},"s":"I","c":"TXN","id":51802,"ctx":"conn8","msg":"transaction","attr":{"parameters":{"lsid":{"id":{"$uuid":"0b2c8e9a-1111-4a4a-8b8b-000000000002"}},"txnNumber":1,"autocommit":false},"terminationCause":"aborted","abortCause":"WriteConflict","timeActiveMicros":900000,"timeInactiveMicros":100000,"numYields":0,"durationMillis":1000}}`,
+	`{"t":{"$date":"2021-03-01T10:00:04.000+00:00"},"s":"I","c":"TXN","id":51802,"ctx":"conn9","msg":"transaction","attr":{"parameters":{"lsid":{"id":{"$uuid":"0b2c8e9a-1111-4a4a-8b8b-000000000003"}},"txnNumber":9,"autocommit":false},"terminationCause":"aborted","timeActiveMicros":1000,"timeInactiveMicros":1000,"numYields":0,"durationMillis":2}}`,
+}
+
+func TestLogTransactions(t *testing.T) {
+	li := NewLogInfo("utest-xxxxxx")
+	li.SetSilent(true)
+	if err := li.Parse(bufio.NewReader(strings.NewReader(strings.Join(txnLogs, "\n")))); err != nil {
+		t.Fatal(err)
+	}
+	txns := li.Transactions
+	if txns == nil || txns.Count != 3 || txns.Committed != 1 || txns.Aborted != 2 {
+		t.Fatal("unexpected transactions", txns)
+	}
+	if txns.AbortReasons["WriteConflict"] != 1 || txns.AbortReasons["unknown"] != 1 {
+		t.Fatal("unexpected abort reasons", txns.AbortReasons)
+	}
+	if txns.TotalLockWaitMicros != 3000 || txns.TotalActiveMicros != 1101000 {
+		t.Fatal("unexpected totals", txns.TotalLockWaitMicros, txns.TotalActiveMicros)
+	}
+	if len(txns.Longest) != 3 || txns.Longest[0].Milli != 1000 || txns.Longest[0].Namespaces[0] != "bank.accounts" {
+		t.Fatal("unexpected longest transactions", txns.Longest)
+	}
+	if len(txns.Namespaces) != 1 || txns.Namespaces[0].Count != 2 || txns.Namespaces[0].Aborted != 1 {
+		t.Fatal("unexpected namespaces", txns.Namespaces)
+	}
+
+	merged := NewLogTransactions()
+	merged.Merge(txns)
+	merged.Merge(txns)
+	if merged.Count != 6 || merged.AbortReasons["WriteConflict"] != 2 || len(merged.Longest) != 6 {
+		t.Fatal("unexpected merged transactions", merged)
+	}
+}
+
+func TestAddTxnNamespace(t *testing.T) {
+	retryable := `{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn9","msg":"Slow query","attr":{"type":"command","ns":"bank.audit","command":{"insert":"audit","lsid":{"id":{"$uuid":"0b2c8e9a-1111-4a4a-8b8b-000000000003"}},"txnNumber":9,"$db":"bank"},"durationMillis":150}}`
+	li := NewLogInfo("utest-xxxxxx")
+	li.SetSilent(true)
+	if err := li.Parse(bufio.NewReader(strings.NewReader(retryable + "\n" + txnLogs[5]))); err != nil {
+		t.Fatal(err)
+	}
+	if li.Transactions == nil || len(li.Transactions.Longest) != 1 || len(li.Transactions.Longest[0].Namespaces) != 0 {
+		t.Fatal("expected no namespaces of retryable writes", li.Transactions)
+	}
+
+	var txns txnOpNamespaces
+	txns.add("2021-03-01T10:00", "a.1", "bank.accounts")
+	txns.add("2021-03-01T10:01", "b.1", "bank.ledger")
+	if namespaces := txns.remove("a.1"); len(namespaces) != 1 || namespaces[0] != "bank.accounts" {
+		t.Fatal("expected namespaces of the previous minute", namespaces)
+	}
+	txns.add("2021-03-01T10:02", "c.1", "bank.ledger")
+	txns.add("2021-03-01T10:03", "c.1", "bank.accounts")
+	if len(txns.remove("b.1")) != 0 || len(txns.remove("c.1")) != 2 || len(txns.current)+len(txns.previous) != 0 {
+		t.Fatal("expected namespaces older than the previous minute dropped", txns)
+	}
+}
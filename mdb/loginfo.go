@@ -5,6 +5,8 @@ package mdb
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -23,22 +25,40 @@ const COLLSCAN = "COLLSCAN"
 
 // LogInfo keeps loginfo struct
 type LogInfo struct {
-	Collscan   bool        `bson:"collscan"`
-	DBVersion  string      `bson:"version"`
-	Histograms []Histogram `bson:"histogram"`
-	Logger     *gox.Logger `bson:"keyhole"`
-	LogType    string      `bson:"type"`
-	Regex      string      `bson:"regex"`
-	OpPatterns []OpPattern `bson:"opPatterns"`
-	Redaction  bool        `bson:"redact"`
-	SlowOps    []RawLog    `bson:"slowOps"`
-
-	filename string
-	logs     []string
-	regex    string
-	regexp   *regexp.Regexp
-	silent   bool
-	verbose  bool
+	Clients    []ClientInfo        `bson:"clients,omitempty"`
+	Collscan   bool                `bson:"collscan"`
+	DBVersion  string              `bson:"version"`
+	Events     *LogEvents          `bson:"events,omitempty"`
+	From       string              `bson:"from,omitempty"`
+	Histograms []Histogram         `bson:"histogram"`
+	Hosts      []string            `bson:"hosts,omitempty"`
+	Logger     *gox.Logger         `bson:"keyhole"`
+	LogType    string              `bson:"type"`
+	Regex      string              `bson:"regex"`
+	OpPatterns []OpPattern         `bson:"opPatterns"`
+	Redaction  bool                `bson:"redact"`
+	SampleRate float64             `bson:"sampleRate,omitempty"`
+	SlowOps    []RawLog            `bson:"slowOps"`
+	Timelines  []NamespaceTimeline `bson:"timelines"`
+	To         string              `bson:"to,omitempty"`
+
+	Transactions *LogTransactions `bson:"transactions,omitempty"`
+
+	clients    map[string]ClientInfo
+	filename   string
+	from       *timeBound
+	host       string
+	logs       []string
+	regex      string
+	regexp     *regexp.Regexp
+	sampleKeys []string
+	silent     bool
+	sortBy     string
+	to         *timeBound
+	verbose    bool
+	workers    int
+
+	txnNamespaces txnOpNamespaces // namespaces of ops by lsid and txnNumber
 }
 
 // OpPattern stores performance data
@@ -46,12 +66,41 @@ type OpPattern struct {
 	Command     string `bson:"command"`     // count, delete, find, remove, and update
 	Count       int    `bson:"count"`       // number of ops
 	Filter      string `bson:"filter"`      // query pattern
+	Hash        string `bson:"hash"`        // hash of query shape
 	MaxMilli    int    `bson:"maxmilli"`    // max millisecond
 	Namespace   string `bson:"ns"`          // database.collectin
 	Scan        string `bson:"scan"`        // COLLSCAN
 	TotalMilli  int64  `bson:"totalmilli"`  // total milliseconds
 	TotalReslen int64  `bson:"totalreslen"` // total reslen
 	Index       string `bson:"index"`       // index used
+	QueryHash   string `bson:"queryHash"`   // queryHash of the server, 4.2+
+
+	TotalBytesRead      int64 `bson:"totalbytesread"`      // total bytes read from storage
+	TotalDocsExamined   int64 `bson:"totaldocsexamined"`   // total documents examined
+	TotalKeysExamined   int64 `bson:"totalkeysexamined"`   // total index keys examined
+	TotalLockWaitMicros int64 `bson:"totallockwaitmicros"` // total time acquiring locks
+	TotalNReturned      int64 `bson:"totalnreturned"`      // total documents returned
+	TotalNumYields      int64 `bson:"totalnumyields"`      // total number of yields
+	TotalPlanningMicros int64 `bson:"totalplanningmicros"` // total query planning time
+
+	Timeline []TimePoint `bson:"timeline"` // per minute ops
+
+	Latency LatencySketch `bson:"latency"` // durations distribution
+	P50     int           `bson:"p50"`     // 50th percentile milliseconds
+	P90     int           `bson:"p90"`     // 90th percentile milliseconds
+	P95     int           `bson:"p95"`     // 95th percentile milliseconds
+	P99     int           `bson:"p99"`     // 99th percentile milliseconds
+
+	Apps  []OpApp  `bson:"apps,omitempty"`  // per application breakdown
+	Hosts []OpHost `bson:"hosts,omitempty"` // per host breakdown of merged logs
+}
+
+// OpHost stores performance data of an op pattern from a host
+type OpHost struct {
+	Count      int    `bson:"count"`
+	Host       string `bson:"host"`
+	MaxMilli   int    `bson:"maxmilli"`
+	TotalMilli int64  `bson:"totalmilli"`
 }
 
 // RawLog holds slow ops log and time
@@ -62,14 +111,26 @@ type RawLog struct {
 
 // LogStats log stats structure
 type LogStats struct {
-	filter string
-	index  string
-	milli  int
-	ns     string
-	op     string
-	reslen int
-	scan   string
-	utc    string
+	app            string
+	conn           string
+	bytesRead      int64
+	docsExamined   int
+	filter         string
+	index          string
+	keysExamined   int
+	lockWaitMicros int64
+	milli          int
+	nreturned      int
+	ns             string
+	numYields      int
+	op             string
+	originatingOp  string // of a getMore
+	planningMicros int64
+	queryHash      string
+	reslen         int
+	scan           string
+	timestamp      string
+	utc            string
 }
 
 // Histogram stores ops info
@@ -108,6 +169,42 @@ func (li *LogInfo) SetVerbose(verbose bool) {
 	li.verbose = verbose
 }
 
+// SetSortBy sets sorting of query patterns, avg, docsExamined, or keysExamined
+func (li *LogInfo) SetSortBy(sortBy string) {
+	li.sortBy = sortBy
+}
+
+// SetTimeWindow sets start and end time of logs to analyze
+func (li *LogInfo) SetTimeWindow(from string, to string) error {
+	var err error
+	if li.from, err = parseTimeBound(from, false); err != nil {
+		return err
+	}
+	if li.to, err = parseTimeBound(to, true); err != nil {
+		return err
+	}
+	li.From = from
+	li.To = to
+	return nil
+}
+
+// SetSampleRate sets rate of log lines to parse, 0 or 1 to parse all lines
+func (li *LogInfo) SetSampleRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("invalid sample rate %v, must be between 0 and 1", rate)
+	}
+	li.SampleRate = rate
+	if rate == 1 {
+		li.SampleRate = 0
+	}
+	return nil
+}
+
+// SetWorkers sets number of workers to parse logs in parallel
+func (li *LogInfo) SetWorkers(workers int) {
+	li.workers = workers
+}
+
 // SetRegexPattern sets Regex patthen
 func (li *LogInfo) SetRegexPattern(regex string) {
 	if regex != "" {
@@ -120,9 +217,370 @@ const (
 	topN   = 25
 )
 
+// sorting options of query patterns
+const (
+	SortByAvg          = "avg"
+	SortByDocsExamined = "docsExamined"
+	SortByKeysExamined = "keysExamined"
+)
+
+// AnalyzeFiles analyzes logs from multiple files, e.g. logs from all members of
+// a replica set or a sharded cluster, and merges them into one report
+func (li *LogInfo) AnalyzeFiles(filenames []string) error {
+	var err error
+	var analyzed int
+	li.reset()
+	for _, filename := range filenames {
+		part := li.clone()
+		if part.host = getHostFromLog(filename); part.host == "" {
+			part.host = getHostFromFilename(filename)
+		}
+		if err = part.AnalyzeFile(filename); err != nil {
+			li.Logger.Errorf(`%v: %v`, filename, err)
+			continue
+		}
+		li.Merge(part)
+		analyzed++
+	}
+	if analyzed == 0 {
+		if err == nil {
+			err = errors.New("no log file analyzed")
+		}
+		return err
+	}
+	li.filename = filenames[0]
+	if len(filenames) > 1 {
+		li.filename = "merged-" + filepath.Base(filenames[0])
+	}
+	return nil
+}
+
+// Merge merges analytic results of another LogInfo
+func (li *LogInfo) Merge(other *LogInfo) {
+	if li.DBVersion == "" {
+		li.DBVersion = other.DBVersion
+	}
+	if li.LogType == "" {
+		li.LogType = other.LogType
+	}
+	hosts := other.Hosts
+	if len(hosts) == 0 && other.host != "" {
+		hosts = []string{other.host}
+	}
+	for _, host := range hosts {
+		if !contains(li.Hosts, host) {
+			li.Hosts = append(li.Hosts, host)
+		}
+	}
+
+	li.Clients = MergeClientInfos(li.Clients, other.Clients)
+	if other.Events != nil {
+		if li.Events == nil {
+			li.Events = NewLogEvents()
+		}
+		li.Events.Merge(other.Events)
+	}
+	if other.Transactions != nil {
+		if li.Transactions == nil {
+			li.Transactions = NewLogTransactions()
+		}
+		li.Transactions.Merge(other.Transactions)
+	}
+
+	opsMap := map[string]int{}
+	for i, op := range li.OpPatterns {
+		opsMap[op.key()] = i
+	}
+	for _, op := range other.OpPatterns {
+		if len(op.Hosts) == 0 && other.host != "" {
+			op.Hosts = []OpHost{{Count: op.Count, Host: other.host, MaxMilli: op.MaxMilli, TotalMilli: op.TotalMilli}}
+		}
+		i, ok := opsMap[op.key()]
+		if !ok {
+			opsMap[op.key()] = len(li.OpPatterns)
+			op.Latency = op.Latency.Clone()
+			op.Timeline = mergeTimeline(nil, op.Timeline)
+			op.Hosts = append([]OpHost(nil), op.Hosts...)
+			op.Apps = append([]OpApp(nil), op.Apps...)
+			li.OpPatterns = append(li.OpPatterns, op)
+			continue
+		}
+		p := &li.OpPatterns[i]
+		if op.MaxMilli > p.MaxMilli {
+			p.MaxMilli = op.MaxMilli
+		}
+		p.Count += op.Count
+		p.TotalMilli += op.TotalMilli
+		p.TotalReslen += op.TotalReslen
+		p.TotalBytesRead += op.TotalBytesRead
+		p.TotalDocsExamined += op.TotalDocsExamined
+		p.TotalKeysExamined += op.TotalKeysExamined
+		p.TotalLockWaitMicros += op.TotalLockWaitMicros
+		p.TotalNReturned += op.TotalNReturned
+		p.TotalNumYields += op.TotalNumYields
+		p.TotalPlanningMicros += op.TotalPlanningMicros
+		p.Index = op.Index
+		if op.QueryHash != "" {
+			p.QueryHash = op.QueryHash
+		}
+		p.Latency.Merge(op.Latency)
+		p.Timeline = mergeTimeline(p.Timeline, op.Timeline)
+		p.Hosts = mergeOpHosts(p.Hosts, op.Hosts)
+		p.Apps = mergeOpApps(p.Apps, op.Apps)
+	}
+	for i := range li.OpPatterns {
+		li.OpPatterns[i].setPercentiles()
+	}
+	sortOpPatterns(li.OpPatterns, li.sortBy)
+
+	histMap := map[string]int{}
+	for i, hist := range li.Histograms {
+		histMap[hist.UTC] = i
+	}
+	for _, hist := range other.Histograms {
+		if hist.UTC == "" && len(hist.Ops) == 0 {
+			continue
+		}
+		i, ok := histMap[hist.UTC]
+		if !ok {
+			histMap[hist.UTC] = len(li.Histograms)
+			li.Histograms = append(li.Histograms, Histogram{UTC: hist.UTC, Ops: map[string]int{}})
+			i = len(li.Histograms) - 1
+		}
+		for op, cnt := range hist.Ops {
+			li.Histograms[i].Ops[op] += cnt
+		}
+	}
+	sort.SliceStable(li.Histograms, func(i, j int) bool {
+		return li.Histograms[i].UTC < li.Histograms[j].UTC
+	})
+
+	li.Timelines = mergeNamespaceTimelines(li.Timelines, other.Timelines)
+
+	li.SlowOps = append(li.SlowOps, other.SlowOps...)
+	sort.SliceStable(li.SlowOps, func(i, j int) bool {
+		return li.SlowOps[i].Milli > li.SlowOps[j].Milli
+	})
+	if len(li.SlowOps) > topN {
+		li.SlowOps = li.SlowOps[:topN]
+	}
+
+	for i, key := range other.sampleKeys {
+		if !contains(li.sampleKeys, key) {
+			li.sampleKeys = append(li.sampleKeys, key)
+			li.logs = append(li.logs, other.logs[i])
+		}
+	}
+}
+
+// mergeOpHosts merges per host breakdowns
+func mergeOpHosts(hosts []OpHost, others []OpHost) []OpHost {
+	for _, other := range others {
+		found := false
+		for i := range hosts {
+			if hosts[i].Host == other.Host {
+				hosts[i].Count += other.Count
+				hosts[i].TotalMilli += other.TotalMilli
+				if other.MaxMilli > hosts[i].MaxMilli {
+					hosts[i].MaxMilli = other.MaxMilli
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			hosts = append(hosts, other)
+		}
+	}
+	return hosts
+}
+
+// key returns the grouping key of an op pattern
+func (op OpPattern) key() string {
+	return op.Command + "." + op.Namespace + "." + op.Filter + "." + op.Scan
+}
+
+// add adds stats of an op to the pattern
+func (op *OpPattern) add(stat LogStats) {
+	if stat.milli > op.MaxMilli {
+		op.MaxMilli = stat.milli
+	}
+	op.Count++
+	op.Index = stat.index
+	op.Namespace = stat.ns
+	if stat.queryHash != "" {
+		op.QueryHash = stat.queryHash
+	}
+	op.Scan = stat.scan
+	op.TotalMilli += int64(stat.milli)
+	op.TotalReslen += int64(stat.reslen)
+	op.TotalBytesRead += stat.bytesRead
+	op.TotalDocsExamined += int64(stat.docsExamined)
+	op.TotalKeysExamined += int64(stat.keysExamined)
+	op.TotalLockWaitMicros += stat.lockWaitMicros
+	op.TotalNReturned += int64(stat.nreturned)
+	op.TotalNumYields += int64(stat.numYields)
+	op.TotalPlanningMicros += stat.planningMicros
+	op.Latency.Add(stat.milli)
+	op.Timeline = addTimePoint(op.Timeline, TimePoint{Count: 1, TotalMilli: int64(stat.milli), UTC: stat.utc})
+	if stat.app != "" {
+		op.Apps = mergeOpApps(op.Apps, []OpApp{{App: stat.app, Count: 1, MaxMilli: stat.milli, TotalMilli: int64(stat.milli)}})
+	}
+}
+
+// DocsExaminedRatio returns documents examined per document returned
+func (op OpPattern) DocsExaminedRatio() float64 {
+	return getExaminedRatio(op.TotalDocsExamined, op.TotalNReturned)
+}
+
+// KeysExaminedRatio returns index keys examined per document returned
+func (op OpPattern) KeysExaminedRatio() float64 {
+	return getExaminedRatio(op.TotalKeysExamined, op.TotalNReturned)
+}
+
+// getExaminedRatio returns examined/returned, and counts as 1 returned if
+// nothing was returned
+func getExaminedRatio(examined int64, returned int64) float64 {
+	if returned == 0 {
+		return float64(examined)
+	}
+	return float64(examined) / float64(returned)
+}
+
+// setPercentiles sets percentiles from the latency sketch, estimations are
+// capped by the max milliseconds
+func (op *OpPattern) setPercentiles() {
+	if op.Latency.Count == 0 {
+		return
+	}
+	for _, p := range []struct {
+		q     float64
+		value *int
+	}{{0.5, &op.P50}, {0.9, &op.P90}, {0.95, &op.P95}, {0.99, &op.P99}} {
+		*p.value = op.Latency.Quantile(p.q)
+		if *p.value > op.MaxMilli {
+			*p.value = op.MaxMilli
+		}
+	}
+}
+
+// sortOpPatterns sorts op patterns in descending order, by examined ratio or
+// by average milliseconds
+func sortOpPatterns(patterns []OpPattern, sortBy string) {
+	sort.SliceStable(patterns, func(i, j int) bool {
+		var x, y float64
+		if sortBy == SortByDocsExamined {
+			x, y = patterns[i].DocsExaminedRatio(), patterns[j].DocsExaminedRatio()
+		} else if sortBy == SortByKeysExamined {
+			x, y = patterns[i].KeysExaminedRatio(), patterns[j].KeysExaminedRatio()
+		}
+		if x != y {
+			return x > y
+		}
+		return float64(patterns[i].TotalMilli)/float64(patterns[i].Count) > float64(patterns[j].TotalMilli)/float64(patterns[j].Count)
+	})
+}
+
+// clone returns a LogInfo with the same settings but no results
+func (li *LogInfo) clone() *LogInfo {
+	return &LogInfo{Collscan: li.Collscan, From: li.From, Logger: li.Logger, Redaction: li.Redaction,
+		SampleRate: li.SampleRate, To: li.To, from: li.from, regex: li.regex, silent: li.silent, sortBy: li.sortBy,
+		to: li.to, verbose: li.verbose, workers: li.workers}
+}
+
+// reset clears results from previous analysis
+func (li *LogInfo) reset() {
+	li.Clients = nil
+	li.DBVersion = ""
+	li.Events = nil
+	li.Histograms = nil
+	li.Hosts = nil
+	li.LogType = ""
+	li.OpPatterns = nil
+	li.SlowOps = nil
+	li.Timelines = nil
+	li.Transactions = nil
+	li.clients = nil
+	li.logs = nil
+	li.sampleKeys = nil
+	li.txnNamespaces = txnOpNamespaces{}
+}
+
+// maxHostScanLines is the number of first lines of a log examined for the host
+const maxHostScanLines = 1000
+
+var (
+	reRotatedLog    = regexp.MustCompile(`\.(\d+|\d{4}-\d{2}-\d{2}T[\d-]+)$`)
+	reStartingHost  = regexp.MustCompile(`MongoDB starting : pid=\d+ port=(\d+) .*host=(\S+)`)
+	startupMessages = []string{`"msg":"MongoDB starting"`, `"msg":"Process Details"`}
+)
+
+// getHostFromLog returns host:port of the startup or process details entry, the latter is also
+// logged after log rotation, of the first lines of a log file
+func getHostFromLog(filename string) string {
+	if strings.HasSuffix(filename, logExt) {
+		return ""
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	reader, err := gox.NewReader(file)
+	if err != nil {
+		return ""
+	}
+	for i := 0; i < maxHostScanLines; i++ {
+		str, err := readLogLine(reader)
+		if err != nil {
+			break
+		}
+		if matches := reStartingHost.FindStringSubmatch(str); len(matches) == 3 {
+			return matches[2] + ":" + matches[1]
+		}
+		for _, msg := range startupMessages {
+			if !strings.Contains(str, msg) {
+				continue
+			}
+			var doc struct {
+				Attr struct {
+					Host string `json:"host"`
+					Port int    `json:"port"`
+				} `json:"attr"`
+			}
+			if json.Unmarshal([]byte(str), &doc) == nil && doc.Attr.Host != "" {
+				return fmt.Sprintf("%v:%d", doc.Attr.Host, doc.Attr.Port)
+			}
+		}
+	}
+	return ""
+}
+
+// getHostFromFilename returns host name from a log file name, e.g. atlas
+// downloaded logs are named as <hostname>-mongodb.gz.  Suffixes of rotated
+// logs are removed and other names are prefixed with the parent directory,
+// e.g. rs0/mongod.log.1 is of host rs0/mongod
+func getHostFromFilename(filename string) string {
+	host := filepath.Base(filename)
+	host = strings.TrimSuffix(host, logExt)
+	host = strings.TrimSuffix(host, ".gz")
+	host = reRotatedLog.ReplaceAllString(host, "")
+	host = strings.TrimSuffix(host, ".log")
+	for _, suffix := range []string{"-mongodb", "-mongos"} {
+		if strings.HasSuffix(host, suffix) {
+			return strings.TrimSuffix(host, suffix)
+		}
+	}
+	if dir := filepath.Base(filepath.Dir(filename)); dir != "." && dir != string(filepath.Separator) {
+		host = dir + "/" + host
+	}
+	return host
+}
+
 // AnalyzeFile analyze logs from a file
 func (li *LogInfo) AnalyzeFile(filename string) error {
 	var err error
+	li.reset()
 	li.filename = filename
 	if strings.HasSuffix(filename, logExt) {
 		var data []byte
@@ -150,7 +608,7 @@ func (li *LogInfo) AnalyzeFile(filename string) error {
 			return err
 		}
 		var lineCounts int
-		if !li.silent {
+		if !li.silent && li.workers <= 1 { // skip counting lines in parallel mode
 			lineCounts, _ = gox.CountLines(reader)
 		}
 		file.Seek(0, 0)
@@ -167,133 +625,223 @@ func (li *LogInfo) AnalyzeFile(filename string) error {
 // Parse parse text or json
 func (li *LogInfo) Parse(reader *bufio.Reader, counts ...int) error {
 	var err error
-	var buf []byte
-	var isPrefix bool
-	var opsMap map[string]OpPattern
-	var stat LogStats
-	opsMap = make(map[string]OpPattern)
 	lineCounts := 0
 	if len(counts) > 0 {
 		lineCounts = counts[0]
 	}
-	index := 0
-	var ts string
-	var hist = Histogram{Ops: map[string]int{}}
 	li.regexp = regexp.MustCompile(li.regex)
+	lp := &logParser{hist: Histogram{Ops: map[string]int{}}, nsTimelines: map[string][]TimePoint{},
+		opsMap: map[string]OpPattern{}}
+	if li.workers > 1 {
+		err = li.parseParallel(reader, lp)
+	} else {
+		err = li.parseSerial(reader, lp, lineCounts)
+	}
+	if err != nil {
+		return err
+	}
+	li.Histograms = append(li.Histograms, lp.hist)
+	if li.Events != nil {
+		li.Events.sort()
+	}
+	if li.Transactions != nil {
+		li.Transactions.sort()
+	}
+	li.OpPatterns = make([]OpPattern, 0, len(lp.opsMap))
+	for _, key := range li.sampleKeys {
+		op := lp.opsMap[key]
+		op.Timeline = mergeTimeline(nil, op.Timeline)
+		op.setPercentiles()
+		li.OpPatterns = append(li.OpPatterns, op)
+	}
+	timelines := []NamespaceTimeline{}
+	for ns, points := range lp.nsTimelines {
+		timelines = append(timelines, NamespaceTimeline{Namespace: ns, Points: points})
+	}
+	li.Timelines = mergeNamespaceTimelines(li.Timelines, timelines)
+	sortOpPatterns(li.OpPatterns, li.sortBy)
+	if !li.silent {
+		fmt.Fprintf(os.Stderr, "\r                         \r")
+	}
+	return nil
+}
+
+// parseSerial parses and aggregates log lines one by one
+func (li *LogInfo) parseSerial(reader *bufio.Reader, lp *logParser, lineCounts int) error {
+	index := 0
+	logType := ""
 	for {
 		if lineCounts > 0 && !li.silent && index%50 == 0 {
 			fmt.Fprintf(os.Stderr, "\r%3d%% \r", (100*index)/lineCounts)
 		}
-		if buf, isPrefix, err = reader.ReadLine(); err != nil { // 0x0A separator = newline
+		str, err := readLogLine(reader)
+		if err != nil { // 0x0A separator = newline
 			break
 		}
 		index++
-		if len(buf) == 0 {
+		if len(str) == 0 {
 			continue
 		}
-		str := string(buf)
-		for isPrefix {
-			var bbuf []byte
-			if bbuf, isPrefix, err = reader.ReadLine(); err != nil {
-				break
-			}
-			str += string(bbuf)
+		if logType == "" { //examine the log logType
+			logType = getLogType(str)
 		}
-		if li.LogType == "" { //examine the log logType
-			if regexp.MustCompile("^{.*}$").MatchString(str) {
-				li.LogType = "logv2"
-				if stat, err = li.ParseLogv2(str); err != nil {
-					continue
-				}
-			} else {
-				li.LogType = "text"
-				if stat, err = li.ParseLog(str); err != nil {
-					continue
-				}
-			}
-		} else if li.LogType == "logv2" || str[0:1] == "{" {
-			if li.DBVersion == "" && strings.Index(str, `"Build Info"`) > 0 {
-				label := `"version":"`
-				idx := strings.Index(str, label)
-				if idx > 0 {
-					li.DBVersion = str[idx+len(label):]
-					idx = strings.Index(li.DBVersion, `"`)
-					li.DBVersion = "v" + li.DBVersion[:idx]
-				}
-			} else if stat, err = li.ParseLogv2(str); err != nil {
-				continue
-			}
-			li.LogType = "logv2"
-		} else {
-			if li.DBVersion == "" && strings.Index(str, " CONTROL ") > 0 {
-				label := "db version"
-				idx := strings.Index(str, label)
-				if idx > 0 {
-					li.DBVersion = str[idx+len(label)+1:]
-				}
-			} else if stat, err = li.ParseLog(str); err != nil {
-				continue
-			}
-		}
-		if stat.op == "" {
-			if li.verbose {
-				fmt.Println(str)
-			}
-			continue
-		} else if stat.op == dollarCmd {
+		if !li.isSampled(index, str) {
 			continue
 		}
-		if stat.utc != ts { //	push
-			fmt.Fprintf(os.Stderr, "\r     %v\r", stat.utc)
-			if ts != "" {
-				li.Histograms = append(li.Histograms, hist)
-			}
-			ts = stat.utc
-			hist = Histogram{UTC: ts, Ops: map[string]int{}}
-		}
-		cnt := hist.Ops[stat.op]
-		cnt++
-		hist.Ops[stat.op] = cnt
-		key := stat.op + "." + stat.ns + "." + stat.filter + "." + stat.scan
-		_, ok := opsMap[key]
-		if stat.op != "insert" && (len(li.SlowOps) < topN || stat.milli > li.SlowOps[topN-1].Milli) {
-			li.SlowOps = append(li.SlowOps, RawLog{Milli: stat.milli, Log: str})
-			sort.Slice(li.SlowOps, func(i, j int) bool {
-				return li.SlowOps[i].Milli > li.SlowOps[j].Milli
-			})
-			if len(li.SlowOps) > topN {
-				li.SlowOps = li.SlowOps[:topN]
-			}
+		li.aggregate(lp, logType, li.parseLine(str, logType))
+	}
+	return nil
+}
+
+// readLogLine reads a line, including lines longer than the buffer
+func readLogLine(reader *bufio.Reader) (string, error) {
+	buf, isPrefix, err := reader.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	str := string(buf)
+	for isPrefix {
+		var bbuf []byte
+		if bbuf, isPrefix, err = reader.ReadLine(); err != nil {
+			break
 		}
+		str += string(bbuf)
+	}
+	return str, nil
+}
 
-		if ok {
-			max := opsMap[key].MaxMilli
-			if stat.milli > max {
-				max = stat.milli
+// getLogType returns log type, logv2 or text, from the first line
+func getLogType(str string) string {
+	if regexp.MustCompile("^{.*}$").MatchString(str) {
+		return "logv2"
+	}
+	return "text"
+}
+
+// logLine stores results of a parsed log line
+type logLine struct {
+	doc       *Logv2 // nil for text logs
+	err       error
+	isControl bool // text logs only
+	stat      LogStats
+	str       string
+	version   string
+}
+
+// logParser stores states of aggregating parsed log lines
+type logParser struct {
+	hist        Histogram
+	nsTimelines map[string][]TimePoint
+	opsMap      map[string]OpPattern
+	ts          string
+}
+
+// parseLine parses a log line without changing LogInfo, and is safe to be called concurrently
+func (li *LogInfo) parseLine(str string, logType string) logLine {
+	line := logLine{str: str}
+	if logType == "logv2" || str[0:1] == "{" {
+		if strings.Index(str, `"Build Info"`) > 0 {
+			label := `"version":"`
+			idx := strings.Index(str, label)
+			if idx > 0 {
+				line.version = str[idx+len(label):]
+				idx = strings.Index(line.version, `"`)
+				line.version = "v" + line.version[:idx]
 			}
-			x := opsMap[key].TotalMilli + int64(stat.milli)
-			y := opsMap[key].Count + 1
-			z := opsMap[key].TotalReslen + int64(stat.reslen)
-			opsMap[key] = OpPattern{Command: opsMap[key].Command, Namespace: stat.ns, Filter: opsMap[key].Filter,
-				MaxMilli: max, TotalMilli: x, Count: y, Scan: stat.scan, Index: stat.index, TotalReslen: z}
+		}
+		var doc Logv2
+		err := json.Unmarshal([]byte(str), &doc)
+		var typeErr *json.UnmarshalTypeError
+		if err != nil && !errors.As(err, &typeErr) {
+			line.err = err
+			return line
+		}
+		line.doc = &doc
+		if strings.LastIndex(str, "durationMillis") < 0 {
+			line.err = errors.New("no durationMillis found")
+		} else if err != nil {
+			line.err = err
 		} else {
-			opsMap[key] = OpPattern{Command: stat.op, Namespace: stat.ns, Filter: stat.filter, TotalMilli: int64(stat.milli),
-				MaxMilli: stat.milli, Count: 1, Scan: stat.scan, Index: stat.index, TotalReslen: int64(stat.reslen)}
-			li.logs = append(li.logs, str) // append a sample
+			line.stat, line.err = li.getLogv2Stats(str, doc)
 		}
+		return line
 	}
-	li.Histograms = append(li.Histograms, hist)
-	li.OpPatterns = make([]OpPattern, 0, len(opsMap))
-	for _, value := range opsMap {
-		li.OpPatterns = append(li.OpPatterns, value)
+	if strings.Index(str, " CONTROL ") > 0 {
+		line.isControl = true
+		label := "db version"
+		idx := strings.Index(str, label)
+		if idx > 0 {
+			line.version = str[idx+len(label)+1:]
+		}
 	}
-	sort.Slice(li.OpPatterns, func(i, j int) bool {
-		return float64(li.OpPatterns[i].TotalMilli)/float64(li.OpPatterns[i].Count) > float64(li.OpPatterns[j].TotalMilli)/float64(li.OpPatterns[j].Count)
-	})
-	if !li.silent {
-		fmt.Fprintf(os.Stderr, "\r                         \r")
+	line.stat, line.err = li.ParseLog(str)
+	return line
+}
+
+// aggregate adds a parsed log line to analytic results in the order of lines
+func (li *LogInfo) aggregate(lp *logParser, logType string, line logLine) {
+	if li.LogType == "" {
+		li.LogType = logType
 	}
-	return nil
+	if line.isControl && li.DBVersion == "" {
+		li.DBVersion = line.version
+		return
+	} else if line.version != "" && li.DBVersion == "" {
+		li.DBVersion = line.version
+	}
+	str := line.str
+	if line.doc != nil {
+		li.addLogv2(*line.doc, str)
+		if line.err == nil {
+			li.LogType = "logv2"
+		}
+	}
+	if line.err != nil {
+		return
+	}
+	stat := line.stat
+	if stat.app == "" && li.clients != nil {
+		stat.app = li.clients[stat.conn].App
+	}
+	if stat.op == "" {
+		if li.verbose {
+			fmt.Println(str)
+		}
+		return
+	} else if stat.op == dollarCmd {
+		return
+	} else if !li.isInTimeWindow(stat.timestamp) {
+		return
+	}
+	if stat.utc != lp.ts { //	push
+		fmt.Fprintf(os.Stderr, "\r     %v\r", stat.utc)
+		if lp.ts != "" {
+			li.Histograms = append(li.Histograms, lp.hist)
+		}
+		lp.ts = stat.utc
+		lp.hist = Histogram{UTC: lp.ts, Ops: map[string]int{}}
+	}
+	lp.hist.Ops[stat.op]++
+	key := stat.op + "." + stat.ns + "." + stat.filter + "." + stat.scan
+	op, ok := lp.opsMap[key]
+	if stat.op != "insert" && (len(li.SlowOps) < topN || stat.milli > li.SlowOps[topN-1].Milli) {
+		li.SlowOps = append(li.SlowOps, RawLog{Milli: stat.milli, Log: str})
+		sort.Slice(li.SlowOps, func(i, j int) bool {
+			return li.SlowOps[i].Milli > li.SlowOps[j].Milli
+		})
+		if len(li.SlowOps) > topN {
+			li.SlowOps = li.SlowOps[:topN]
+		}
+	}
+
+	if !ok {
+		op = OpPattern{Command: stat.op, Filter: stat.filter, Hash: GetQueryShapeHash(GetShapeCommand(stat.op, stat.originatingOp), stat.ns, stat.filter)}
+		li.logs = append(li.logs, str) // append a sample
+		li.sampleKeys = append(li.sampleKeys, key)
+	}
+	op.add(stat)
+	lp.opsMap[key] = op
+	lp.nsTimelines[stat.ns] = addTimePoint(lp.nsTimelines[stat.ns], TimePoint{Count: 1, TotalMilli: int64(stat.milli), UTC: stat.utc})
 }
 
 // OutputBSON writes loginfo bson data
@@ -364,11 +912,22 @@ func (li *LogInfo) OutputBSON() (string, []byte, error) {
 
 	// output TSV file
 	re := regexp.MustCompile(`\r?\n`)
-	lines := []string{fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v", "Row", "Category", "Avg Time", "Max Time", "Count", "Total Time", "Total Reslen", "Namespace", "COLLSCAN", "Index(es) Used", "Query Pattern")}
+	header := []string{"Row", "Category", "Avg Time", "Max Time", "P50", "P90", "P95", "P99", "Count", "Total Time", "Total Reslen",
+		"Keys Examined", "Docs Examined", "Returned", "Keys/Returned", "Docs/Returned", "Yields", "Planning Micros", "Bytes Read",
+		"Lock Wait Micros", "Namespace", "COLLSCAN", "Index(es) Used", "Query Pattern"}
+	lines := []string{strings.Join(header, "\t")}
 	for i, doc := range li.OpPatterns {
 		avg := float64(doc.TotalMilli) / float64(doc.Count)
-		lines = append(lines, fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v", i+1, doc.Command, gox.MilliToTimeString(avg), doc.MaxMilli, doc.Count,
-			doc.TotalMilli, doc.TotalReslen, doc.Namespace, doc.Scan, re.ReplaceAllString(doc.Index, " "), doc.Filter))
+		values := []interface{}{i + 1, doc.Command, gox.MilliToTimeString(avg), doc.MaxMilli, doc.P50, doc.P90, doc.P95, doc.P99,
+			doc.Count, doc.TotalMilli, doc.TotalReslen, doc.TotalKeysExamined, doc.TotalDocsExamined, doc.TotalNReturned,
+			fmt.Sprintf("%.1f", doc.KeysExaminedRatio()), fmt.Sprintf("%.1f", doc.DocsExaminedRatio()), doc.TotalNumYields,
+			doc.TotalPlanningMicros, doc.TotalBytesRead, doc.TotalLockWaitMicros, doc.Namespace, doc.Scan,
+			re.ReplaceAllString(doc.Index, " "), doc.Filter}
+		fields := make([]string, len(values))
+		for j, v := range values {
+			fields[j] = fmt.Sprint(v)
+		}
+		lines = append(lines, strings.Join(fields, "\t"))
 	}
 
 	idx = strings.Index(tsvf, ".tsv")
@@ -488,9 +1047,111 @@ func (li *LogInfo) printLogsSummary() string {
 			output = fmt.Sprintf("|...index:  %v%-128s%v|\n", green, value.Index, tail)
 			buffer.WriteString(output)
 		}
+		if value.TotalKeysExamined+value.TotalDocsExamined+value.TotalNReturned > 0 {
+			str = fmt.Sprintf("keys: %d, docs: %d, returned: %d, keys/returned: %.1f, docs/returned: %.1f",
+				value.TotalKeysExamined, value.TotalDocsExamined, value.TotalNReturned, value.KeysExaminedRatio(), value.DocsExaminedRatio())
+			output = fmt.Sprintf("|...examined: %-126s|\n", str)
+			buffer.WriteString(output)
+		}
+		stats := []string{}
+		if value.TotalNumYields > 0 {
+			stats = append(stats, fmt.Sprintf("yields: %d", value.TotalNumYields))
+		}
+		if value.TotalPlanningMicros > 0 {
+			stats = append(stats, "planning: "+milliToString(float64(value.TotalPlanningMicros)/1000))
+		}
+		if value.TotalBytesRead > 0 {
+			stats = append(stats, "bytes read: "+gox.GetStorageSize(value.TotalBytesRead))
+		}
+		if value.TotalLockWaitMicros > 0 {
+			stats = append(stats, "lock wait: "+milliToString(float64(value.TotalLockWaitMicros)/1000))
+		}
+		if len(stats) > 0 {
+			output = fmt.Sprintf("|...stats:    %-126s|\n", strings.Join(stats, ", "))
+			buffer.WriteString(output)
+		}
+		if value.Latency.Count > 1 {
+			str = fmt.Sprintf("p50: %v, p90: %v, p95: %v, p99: %v", milliToString(float64(value.P50)),
+				milliToString(float64(value.P90)), milliToString(float64(value.P95)), milliToString(float64(value.P99)))
+			output = fmt.Sprintf("|...latency: %-127s|\n", str)
+			buffer.WriteString(output)
+		}
+		if len(value.Hosts) > 1 {
+			for _, host := range value.Hosts {
+				str = fmt.Sprintf("%v, count: %d, avg: %v, max: %v", host.Host, host.Count,
+					milliToString(float64(host.TotalMilli)/float64(host.Count)), milliToString(float64(host.MaxMilli)))
+				output = fmt.Sprintf("|...host:   %-128s|\n", str)
+				buffer.WriteString(output)
+			}
+		}
+		for _, app := range getTopApps(value.Apps, 3) {
+			str = fmt.Sprintf("%v, count: %d, avg: %v, max: %v", app.App, app.Count,
+				milliToString(float64(app.TotalMilli)/float64(app.Count)), milliToString(float64(app.MaxMilli)))
+			output = fmt.Sprintf("|...app:    %-128s|\n", str)
+			buffer.WriteString(output)
+		}
 	}
 	buffer.WriteString("+----------+--------+------+--------+------+---------------------------------+--------------------------------------------------------------+\n")
 	summaries = append(summaries, buffer.String())
 	summaries = append(summaries, fmt.Sprintf(`top %d of %v lines displayed; see HTML report for details.`, count, len(li.OpPatterns)))
+	if len(li.Hosts) > 1 {
+		summaries = append(summaries, fmt.Sprintf(`merged logs from %d hosts: %v`, len(li.Hosts), strings.Join(li.Hosts, ", ")))
+	}
+	if li.From != "" || li.To != "" {
+		from, to := li.From, li.To
+		if from == "" {
+			from = "beginning"
+		}
+		if to == "" {
+			to = "end"
+		}
+		summaries = append(summaries, fmt.Sprintf(`analysis window: from %v to %v`, from, to))
+	}
+	if li.SampleRate > 0 {
+		summaries = append(summaries, fmt.Sprintf(`sampled %.1f%% of log lines, counts are of sampled lines only`, 100*li.SampleRate))
+	}
+	if peaks := getPeakNamespaces(li.Timelines, 5); len(peaks) > 0 {
+		summaries = append(summaries, "peak namespaces:")
+		for _, peak := range peaks {
+			summaries = append(summaries, "  "+peak)
+		}
+	}
+	if apps := li.getApps(); len(apps) > 0 {
+		summaries = append(summaries, "slow ops by application:")
+		for _, app := range getTopApps(apps, 10) {
+			summaries = append(summaries, fmt.Sprintf("  %v, count: %d, total: %v, max: %v", app.App, app.Count,
+				milliToString(float64(app.TotalMilli)), milliToString(float64(app.MaxMilli))))
+		}
+	}
+	if len(li.Clients) > 0 {
+		clients := append([]ClientInfo(nil), li.Clients...)
+		sort.SliceStable(clients, func(i, j int) bool {
+			return clients[i].Connections > clients[j].Connections
+		})
+		summaries = append(summaries, "client drivers:")
+		for i, client := range clients {
+			if i >= 10 {
+				break
+			}
+			summaries = append(summaries, fmt.Sprintf("  app: %v, driver: %v %v, os: %v, connections: %d",
+				client.App, client.Driver, client.DriverVersion, client.OS, client.Connections))
+		}
+	}
+	if str := li.Transactions.String(); str != "" {
+		summaries = append(summaries, strings.TrimSuffix(str, "\n"))
+	}
+	if str := li.Events.String(); str != "" {
+		summaries = append(summaries, strings.TrimSuffix(str, "\n"))
+	}
 	return strings.Join(summaries, "\n")
 }
+
+// milliToString returns a trimmed time string of milliseconds
+func milliToString(milli float64) string {
+	if milli > 0 && milli < 1 {
+		return fmt.Sprintf("%.1fms", milli)
+	} else if milli < 1000 {
+		return fmt.Sprintf("%.0fms", milli)
+	}
+	return strings.TrimSpace(gox.MilliToTimeString(milli))
+}
@@ -4,6 +4,9 @@ package mdb
 
 import (
 	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -33,3 +36,107 @@ func TestLoginfoParse(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestLogInfoMerge(t *testing.T) {
+	logs := []string{
+		`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"red"}},"planSummary":"COLLSCAN","reslen":100,"durationMillis":200}}
+{"t":{"$date":"2021-03-01T10:01:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"blue"}},"planSummary":"COLLSCAN","reslen":100,"durationMillis":400}}`,
+		`{"t":{"$date":"2021-03-01T10:00:30.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn2","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"green"}},"planSummary":"COLLSCAN","reslen":100,"durationMillis":600}}
+{"t":{"$date":"2021-03-01T10:00:31.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn2","msg":"Slow query","attr":{"type":"command","ns":"keyhole.dealers","command":{"find":"dealers","filter":{"state":"NY"}},"planSummary":"IXSCAN { state: 1 }","reslen":100,"durationMillis":100}}`,
+	}
+	merged := NewLogInfo("utest-xxxxxx")
+	merged.SetSilent(true)
+	for i, str := range logs {
+		loginfo := merged.clone()
+		loginfo.host = fmt.Sprintf("host%d", i)
+		if err := loginfo.Parse(bufio.NewReader(strings.NewReader(str))); err != nil {
+			t.Fatal(err)
+		}
+		merged.Merge(loginfo)
+	}
+	if len(merged.Hosts) != 2 {
+		t.Fatal("expected 2 hosts but got", merged.Hosts)
+	}
+	if len(merged.OpPatterns) != 2 {
+		t.Fatal("expected 2 patterns but got", len(merged.OpPatterns))
+	}
+	op := merged.OpPatterns[0]
+	if op.Namespace != "keyhole.cars" || op.Count != 3 || op.TotalMilli != 1200 || op.MaxMilli != 600 || len(op.Hosts) != 2 {
+		t.Fatal("unexpected merged pattern", op)
+	}
+	if op.Hosts[0].Host != "host0" || op.Hosts[0].Count != 2 || op.Hosts[1].Count != 1 {
+		t.Fatal("unexpected hosts breakdown", op.Hosts)
+	}
+	if len(merged.Histograms) != 2 || merged.Histograms[0].Ops["find"] != 3 {
+		t.Fatal("unexpected histograms", merged.Histograms)
+	}
+	if len(merged.SlowOps) != 4 || merged.SlowOps[0].Milli != 600 {
+		t.Fatal("unexpected slow ops", merged.SlowOps)
+	}
+	if op.P50 < 380 || op.P50 > 420 || op.P99 != 600 || op.Latency.Count != 3 {
+		t.Fatal("unexpected percentiles", op.P50, op.P99)
+	}
+	if len(merged.logs) != 2 {
+		t.Fatal("expected 2 samples but got", len(merged.logs))
+	}
+}
+
+func TestGetHostFromFilename(t *testing.T) {
+	if host := getHostFromFilename("/tmp/cluster0-shard-00-01.abcde.mongodb.net-mongodb.gz"); host != "cluster0-shard-00-01.abcde.mongodb.net" {
+		t.Fatal("unexpected host", host)
+	}
+	if host := getHostFromFilename("rs0-mongod.log.gz"); host != "rs0-mongod" {
+		t.Fatal("unexpected host", host)
+	}
+	if a, b := getHostFromFilename("mongod.log.1"), getHostFromFilename("mongod.log.2.gz"); a != "mongod" || a != b {
+		t.Fatal("expected rotated logs of the same host", a, b)
+	}
+	if host := getHostFromFilename("logs/mongod.log.2021-03-01T00-00-00"); host != "logs/mongod" {
+		t.Fatal("unexpected host", host)
+	}
+	if a, b := getHostFromFilename("rs0/mongod.log"), getHostFromFilename("rs1/mongod.log"); a != "rs0/mongod" || b != "rs1/mongod" {
+		t.Fatal("expected logs of different hosts", a, b)
+	}
+	if host := getHostFromFilename("out/host1-log.bson.gz"); host != "out/host1" {
+		t.Fatal("unexpected host", host)
+	}
+}
+
+func TestGetHostFromLog(t *testing.T) {
+	logs := map[string]string{
+		"host1:27018": `{"t":{"$date":"2021-03-01T10:00:00.000+00:00"},"s":"I","c":"CONTROL","id":4615611,"ctx":"initandlisten","msg":"MongoDB starting","attr":{"pid":1234,"port":27018,"dbPath":"/data/db","architecture":"64-bit","host":"host1"}}`,
+		"host2:27017": `{"t":{"$date":"2021-03-01T10:00:00.000+00:00"},"s":"I","c":"CONTROL","id":20721,"ctx":"LogicalSessionCacheRefresh","msg":"Process Details","attr":{"pid":"1234","port":27017,"architecture":"64-bit","host":"host2"}}`,
+		"host3:27019": `2021-03-01T10:00:00.000+0000 I  CONTROL  [initandlisten] MongoDB starting : pid=1234 port=27019 dbpath=/data/db 64-bit host=host3`,
+		"":            `{"t":{"$date":"2021-03-01T10:00:00.000+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted"}`,
+	}
+	dir := t.TempDir()
+	for host, str := range logs {
+		filename := filepath.Join(dir, "mongod.log")
+		if err := os.WriteFile(filename, []byte(str+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if h := getHostFromLog(filename); h != host {
+			t.Fatal("expected", host, "but got", h)
+		}
+	}
+}
+
+func TestSortOpPatterns(t *testing.T) {
+	patterns := []OpPattern{
+		{Filter: "slow", Count: 1, TotalMilli: 500, TotalDocsExamined: 10, TotalNReturned: 10},
+		{Filter: "scan", Count: 1, TotalMilli: 100, TotalDocsExamined: 10000, TotalNReturned: 10},
+		{Filter: "keys", Count: 1, TotalMilli: 200, TotalKeysExamined: 5000, TotalNReturned: 0},
+	}
+	sortOpPatterns(patterns, SortByAvg)
+	if patterns[0].Filter != "slow" || patterns[2].Filter != "scan" {
+		t.Fatal("unexpected order by avg", patterns)
+	}
+	sortOpPatterns(patterns, SortByDocsExamined)
+	if patterns[0].Filter != "scan" || patterns[0].DocsExaminedRatio() != 1000 {
+		t.Fatal("unexpected order by docsExamined", patterns)
+	}
+	sortOpPatterns(patterns, SortByKeysExamined)
+	if patterns[0].Filter != "keys" || patterns[0].KeysExaminedRatio() != 5000 {
+		t.Fatal("unexpected order by keysExamined", patterns)
+	}
+}
@@ -6,24 +6,47 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"regexp"
 	"strings"
 
-	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // Logv2 stores logv2 info
 type Logv2 struct {
 	Attributes struct {
+		AbortCause         string                 `json:"abortCause" bson:"abortCause"`
+		AppName            string                 `json:"appName" bson:"appName"`
 		Command            map[string]interface{} `json:"command" bson:"command"`
+		DocsExamined       int                    `json:"docsExamined" bson:"docsExamined"`
+		ErrName            string                 `json:"errName" bson:"errName"`
+		KeysExamined       int                    `json:"keysExamined" bson:"keysExamined"`
+		Locks              map[string]interface{} `json:"locks" bson:"locks"`
 		Milli              int                    `json:"durationMillis" bson:"durationMillis"`
+		NReturned          int                    `json:"nreturned" bson:"nreturned"`
 		NS                 string                 `json:"ns" bson:"ns"`
+		NumYields          int                    `json:"numYields" bson:"numYields"`
 		OriginatingCommand map[string]interface{} `json:"originatingCommand" bson:"originatingCommand"`
-		PlanSummary        string                 `json:"planSummary" bson:"planSummary"`
-		Reslen             int                    `json:"reslen" bson:"reslen"`
-		Type               string                 `json:"type" bson:"type"`
+		Parameters         struct {
+			LSID      map[string]interface{} `json:"lsid" bson:"lsid"`
+			TxnNumber int64                  `json:"txnNumber" bson:"txnNumber"`
+		} `json:"parameters" bson:"parameters"`
+		PlanningTimeMicros int64  `json:"planningTimeMicros" bson:"planningTimeMicros"`
+		PlanSummary        string `json:"planSummary" bson:"planSummary"`
+		QueryHash          string `json:"queryHash" bson:"queryHash"`
+		Reslen             int    `json:"reslen" bson:"reslen"`
+		Storage            struct {
+			Data struct {
+				BytesRead int64 `json:"bytesRead" bson:"bytesRead"`
+			} `json:"data" bson:"data"`
+		} `json:"storage" bson:"storage"`
+		TerminationCause   string `json:"terminationCause" bson:"terminationCause"`
+		TimeActiveMicros   int64  `json:"timeActiveMicros" bson:"timeActiveMicros"`
+		TimeInactiveMicros int64  `json:"timeInactiveMicros" bson:"timeInactiveMicros"`
+		Type               string `json:"type" bson:"type"`
+		WriteConflicts     int    `json:"writeConflicts" bson:"writeConflicts"`
 	} `json:"attr" bson:"attr"`
 	Component string            `json:"c" bson:"c"`
+	Context   string            `json:"ctx" bson:"ctx"`
 	ID        int               `json:"id" bson:"id"`
 	Message   string            `json:"msg" bson:"msg"`
 	Severity  string            `json:"s" bson:"s"`
@@ -48,15 +71,35 @@ const cmdUpdate = "update"
 // ParseLogv2 - parses text message before v4.4
 func (li *LogInfo) ParseLogv2(str string) (LogStats, error) {
 	var err error
-	var stat = LogStats{}
 	var doc Logv2
 	if strings.LastIndex(str, "durationMillis") < 0 {
-		return stat, errors.New("no durationMillis found")
+		return LogStats{}, errors.New("no durationMillis found")
 	}
 	if err = json.Unmarshal([]byte(str), &doc); err != nil {
-		return stat, err
+		return LogStats{}, err
+	}
+	return li.getLogv2Stats(str, doc)
+}
+
+// addLogv2 adds a decoded logv2 entry to events, transactions and client metadata
+func (li *LogInfo) addLogv2(doc Logv2, str string) {
+	li.addClientMetadata(doc, str)
+	li.addTxnNamespace(doc)
+	if !li.isInTimeWindow(doc.Timestamp["$date"]) {
+		return
 	}
-	// bson.UnmarshalExtJSON([]byte(str), false, &doc)
+	if li.Events == nil {
+		li.Events = NewLogEvents()
+	}
+	li.Events.Add(doc, str)
+	if doc.ID == logIDTransaction {
+		li.addTransaction(doc)
+	}
+}
+
+// getLogv2Stats returns slow op stats of a decoded logv2 entry
+func (li *LogInfo) getLogv2Stats(str string, doc Logv2) (LogStats, error) {
+	var stat = LogStats{}
 	c := doc.Component
 	if c != "COMMAND" && c != "QUERY" && c != "WRITE" {
 		return stat, errors.New("unsupported command")
@@ -87,6 +130,16 @@ func (li *LogInfo) ParseLogv2(str string) (LogStats, error) {
 		}
 	}
 	stat.reslen = doc.Attributes.Reslen
+	stat.docsExamined = doc.Attributes.DocsExamined
+	stat.keysExamined = doc.Attributes.KeysExamined
+	stat.nreturned = doc.Attributes.NReturned
+	stat.numYields = doc.Attributes.NumYields
+	stat.planningMicros = doc.Attributes.PlanningTimeMicros
+	stat.bytesRead = doc.Attributes.Storage.Data.BytesRead
+	stat.lockWaitMicros = getLockWaitMicros(doc.Attributes.Locks)
+	stat.app = doc.Attributes.AppName
+	stat.queryHash = doc.Attributes.QueryHash
+	stat.conn = doc.Context
 
 	if li.Collscan && stat.scan != COLLSCAN {
 		return stat, errors.New("skip, -collscan")
@@ -94,130 +147,83 @@ func (li *LogInfo) ParseLogv2(str string) (LogStats, error) {
 	if doc.Attributes.Command == nil {
 		return stat, errors.New("no command found")
 	}
-	command := doc.Attributes.Command
 	stat.op = doc.Attributes.Type
 	if stat.op == "command" || stat.op == "none" {
-		stat.op = getOp(command)
+		stat.op = getOp(doc.Attributes.Command)
 	}
 	var isGetMore bool
+	var command interface{} = doc.Attributes.Command
 	if stat.op == cmdGetMore {
 		isGetMore = true
 		command = doc.Attributes.OriginatingCommand
-		stat.op = getOp(command)
+		stat.op = getOp(doc.Attributes.OriginatingCommand)
+	}
+	if strings.Contains(str, `"sort":`) || strings.Contains(str, `"$sort":`) { // keeps order of sort keys
+		var ordered struct {
+			Attributes struct {
+				Command            bson.D `bson:"command"`
+				OriginatingCommand bson.D `bson:"originatingCommand"`
+			} `bson:"attr"`
+		}
+		if bson.UnmarshalExtJSON([]byte(str), false, &ordered) == nil {
+			command = ordered.Attributes.Command
+			if isGetMore {
+				command = ordered.Attributes.OriginatingCommand
+			}
+		}
 	}
 	if stat.op == cmdInsert || stat.op == cmdCreateIndexes {
 		stat.filter = "N/A"
-	} else if (stat.op == cmdUpdate || stat.op == cmdRemove || stat.op == cmdDelete) && stat.filter == "" {
-		var query interface{}
-		if command["q"] != nil {
-			query = command["q"]
-		} else if command["query"] != nil {
-			query = command["query"]
+	} else if stat.op == cmdUpdate || stat.op == cmdRemove || stat.op == cmdDelete {
+		query := GetDocField(command, "q")
+		if query == nil {
+			query = GetDocField(command, "query")
 		}
-
-		if query != nil {
-			walker := gox.NewMapWalker(cb)
-			doc := walker.Walk(query.(map[string]interface{}))
-			if buf, err := json.Marshal(doc); err == nil {
-				stat.filter = string(buf)
-			} else {
-				stat.filter = "{}"
-			}
-		} else {
+		if query == nil {
 			if li.verbose {
 				fmt.Println(str)
 			}
 			return stat, errors.New("no filter found")
 		}
+		stat.filter = GetQueryShape(query, GetDocField(command, "sort"), nil)
 	} else if stat.op == cmdAggregate {
-		pipeline, ok := command["pipeline"].([]interface{})
-		if !ok || len(pipeline) == 0 {
+		pipeline := GetDocField(command, "pipeline")
+		if len(getArray(pipeline)) == 0 {
 			return stat, errors.New("pipeline not found")
 		}
-		var stage interface{}
-		for _, v := range pipeline {
-			stage = v
-			break
-		}
-		fmap := stage.(map[string]interface{})
-		if !isRegex(fmap) {
-			walker := gox.NewMapWalker(cb)
-			doc := walker.Walk(fmap)
-			if buf, err := json.Marshal(doc); err == nil {
-				stat.filter = string(buf)
-			} else {
-				stat.filter = "{}"
-			}
-			if !strings.Contains(stat.filter, "$match") && !strings.Contains(stat.filter, "$sort") &&
-				!strings.Contains(stat.filter, "$facet") && !strings.Contains(stat.filter, "$indexStats") {
-				stat.filter = "{}"
+		stat.filter = NormalizePipeline(pipeline)
+	} else if stat.op != "" {
+		var filter interface{}
+		for _, key := range []string{"filter", "query", "q"} {
+			if filter = GetDocField(command, key); filter != nil {
+				break
 			}
-		} else {
-			buf, _ := json.Marshal(fmap)
-			str := string(buf)
-			re := regexp.MustCompile(`{(.*):{"\$regularExpression":{"options":"(\S+)?","pattern":"(\^)?(\S+)"}}}`)
-			stat.filter = re.ReplaceAllString(str, "{$1:/$3.../$2}")
 		}
-	} else {
-		var fmap map[string]interface{}
-		if command["filter"] != nil {
-			fmap = command["filter"].(map[string]interface{})
-		} else if command["query"] != nil {
-			fmap = command["query"].(map[string]interface{})
-		} else if command["q"] != nil {
-			fmap = command["q"].(map[string]interface{})
-		} else {
+		if filter == nil && stat.op != cmdFind && stat.op != cmdCount {
 			return stat, errors.New("no filter found")
 		}
-		if !isRegex(fmap) {
-			walker := gox.NewMapWalker(cb)
-			doc := walker.Walk(fmap)
-			var data []byte
-			if data, err = json.Marshal(doc); err != nil {
-				return stat, err
-			}
-			stat.filter = string(data)
-			if stat.filter == `{"":null}` {
-				stat.filter = "{}"
-			}
-		} else {
-			buf, _ := json.Marshal(fmap)
-			str := string(buf)
-			re := regexp.MustCompile(`{(.*):{"\$regularExpression":{"options":"(\S+)?","pattern":"(\^)?(\S+)"}}}`)
-			stat.filter = re.ReplaceAllString(str, "{$1:/$3.../$2}")
+		stat.filter = GetQueryShape(filter, GetDocField(command, "sort"), GetDocField(command, "projection"))
+		if key, ok := GetDocField(command, "key").(string); ok && stat.op == cmdDistinct {
+			stat.filter += ", key: " + key
 		}
 	}
 	if stat.op == "" {
 		return stat, nil
 	}
-	re := regexp.MustCompile(`\[1(,1)*\]`)
-	stat.filter = re.ReplaceAllString(stat.filter, `[...]`)
-	re = regexp.MustCompile(`\[{\S+}(,{\S+})*\]`) // matches repeated doc {"base64":1,"subType":1}}
-	stat.filter = re.ReplaceAllString(stat.filter, `[...]`)
-	re = regexp.MustCompile(`^{("\$match"|"\$sort"):(\S+)}$`)
-	stat.filter = re.ReplaceAllString(stat.filter, `$2`)
-	re = regexp.MustCompile(`^{("(\$facet")):\S+}$`)
-	stat.filter = re.ReplaceAllString(stat.filter, `{$1:...}`)
-	re = regexp.MustCompile(`{"\$oid":1}`)
-	stat.filter = re.ReplaceAllString(stat.filter, `1`)
 	if isGetMore {
+		stat.originatingOp = stat.op
 		stat.op = cmdGetMore
 	}
-	utc := doc.Timestamp["$date"][:16] + `:00Z`
+	stat.timestamp = doc.Timestamp["$date"]
+	utc := stat.timestamp[:16] + `:00Z`
 	stat.utc = utc
 	return stat, nil
 }
 
-func isRegex(doc map[string]interface{}) bool {
-	if buf, err := json.Marshal(doc); err != nil {
-		return false
-	} else if strings.Contains(string(buf), "$regularExpression") {
-		return true
-	}
-	return false
-}
-
 func getOp(command map[string]interface{}) string {
+	if command["findAndModify"] != nil { // also has an update field
+		return cmdFindAndModify
+	}
 	for _, v := range ops {
 		if command[v] != nil {
 			return v
@@ -226,6 +232,19 @@ func getOp(command map[string]interface{}) string {
 	return ""
 }
 
-func cb(value interface{}) interface{} {
-	return 1
+// getLockWaitMicros sums timeAcquiringMicros of all lock types and modes
+func getLockWaitMicros(locks map[string]interface{}) int64 {
+	var micros int64
+	for _, v := range locks {
+		lock, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if modes, ok := lock["timeAcquiringMicros"].(map[string]interface{}); ok {
+			for _, n := range modes {
+				micros += toInt64(n)
+			}
+		}
+	}
+	return micros
 }
@@ -23,6 +23,21 @@ func TestParseLogv2(t *testing.T) {
 	}
 }
 
+func TestParseLogv2Examined(t *testing.T) {
+	str := `{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"red"}},"planSummary":"IXSCAN { brand: 1 }","keysExamined":1200,"docsExamined":1200,"nreturned":12,"numYields":9,"planningTimeMicros":350,"reslen":3000,"locks":{"Global":{"acquireCount":{"r":10},"timeAcquiringMicros":{"r":20}},"Collection":{"acquireCount":{"r":10},"timeAcquiringMicros":{"r":30,"w":5}}},"storage":{"data":{"bytesRead":65536}},"durationMillis":120}}`
+	loginfo := NewLogInfo("utest-xxxxxx")
+	stat, err := loginfo.ParseLogv2(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.keysExamined != 1200 || stat.docsExamined != 1200 || stat.nreturned != 12 || stat.numYields != 9 {
+		t.Fatal("unexpected examined stats", stat)
+	}
+	if stat.planningMicros != 350 || stat.bytesRead != 65536 || stat.lockWaitMicros != 55 {
+		t.Fatal("unexpected stats", stat.planningMicros, stat.bytesRead, stat.lockWaitMicros)
+	}
+}
+
 func TestInRepeatedDocPattern(t *testing.T) {
 	str := `{"a":[{"$binary":{"base64":1,"subType":1}},{"$binary":{"base64":1,"subType":1}}}],"b":[1,1,1],"c":[1]}`
 	re := regexp.MustCompile(`\[1(,1)*\]`)
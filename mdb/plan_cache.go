@@ -0,0 +1,192 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// candidate plans scored within the ratio of the winning plan are considered unstable
+const closeScoreRatio = 0.01
+
+// commands of which query shapes are of a filter, sort, and projection as createdFromQuery,
+// aggregate and distinct are correlated by queryHash only
+var planCacheCommands = []string{cmdCount, cmdDelete, cmdFind, cmdUpdate}
+
+// PlanCache stores a summary of $planCacheStats of a collection
+type PlanCache struct {
+	Active   int              `bson:"active"`
+	Entries  []PlanCacheEntry `bson:"entries"`
+	Inactive int              `bson:"inactive"`
+	Unstable int              `bson:"unstable"`
+}
+
+// PlanCacheEntry stores a cached plan of a query shape
+type PlanCacheEntry struct {
+	Hash           string    `bson:"hash"` // hash of query shape as a find, the same as of OpPattern
+	Host           string    `bson:"host,omitempty"`
+	IsActive       bool      `bson:"isActive"`
+	Patterns       []string  `bson:"patterns,omitempty"` // hashes of correlated query patterns of logs
+	Plan           string    `bson:"plan"`
+	PlanCacheKey   string    `bson:"planCacheKey"`
+	QueryHash      string    `bson:"queryHash"`
+	Reasons        []string  `bson:"reasons,omitempty"` // why a cached plan is unstable
+	Shape          string    `bson:"shape"`
+	Shard          string    `bson:"shard,omitempty"`
+	TimeOfCreation time.Time `bson:"timeOfCreation"`
+	Works          int64     `bson:"works"`
+}
+
+// GetPlanCacheFromCollection gets cached plans of a collection, requires 4.2+
+func (ix *IndexStats) GetPlanCacheFromCollection(collection *mongo.Collection) (*PlanCache, error) {
+	return ix.GetPlanCacheFromCollectionWithContext(context.Background(), collection)
+}
+
+// GetPlanCacheFromCollectionWithContext gets cached plans of a collection, canceled with the context
+func (ix *IndexStats) GetPlanCacheFromCollectionWithContext(ctx context.Context, collection *mongo.Collection) (*PlanCache, error) {
+	var err error
+	var cur *mongo.Cursor
+	ix.Logger.Debugf(`GetPlanCacheFromCollection from %v.%v`, collection.Database().Name(), collection.Name())
+	if cur, err = collection.Aggregate(ctx, MongoPipeline(`{"$planCacheStats": {}}`)); err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	docs := []bson.D{}
+	for cur.Next(ctx) {
+		var doc bson.D
+		if err = cur.Decode(&doc); err != nil {
+			ix.Logger.Error(err)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return getPlanCache(collection.Database().Name()+"."+collection.Name(), docs), nil
+}
+
+// getPlanCache summarizes $planCacheStats documents of a namespace
+func getPlanCache(ns string, docs []bson.D) *PlanCache {
+	pc := PlanCache{Entries: []PlanCacheEntry{}}
+	plans := map[string]map[string]bool{} // plans by queryHash of all hosts and shards
+	for _, doc := range docs {
+		entry, scores := getPlanCacheEntry(ns, doc)
+		if plans[entry.QueryHash] == nil {
+			plans[entry.QueryHash] = map[string]bool{}
+		}
+		plans[entry.QueryHash][entry.Plan] = true
+		if len(scores) > 1 {
+			sort.Sort(sort.Reverse(sort.Float64Slice(scores)))
+			if scores[0] > 0 && (scores[0]-scores[1])/scores[0] < closeScoreRatio {
+				entry.Reasons = append(entry.Reasons, fmt.Sprintf("candidate plans scored %.4f and %.4f", scores[0], scores[1]))
+			}
+		}
+		pc.Entries = append(pc.Entries, entry)
+	}
+	for i, entry := range pc.Entries {
+		if len(plans[entry.QueryHash]) > 1 {
+			pc.Entries[i].Reasons = append(pc.Entries[i].Reasons,
+				fmt.Sprintf("%d different plans cached among hosts", len(plans[entry.QueryHash])))
+		}
+		if entry.IsActive {
+			pc.Active++
+		} else {
+			pc.Inactive++
+		}
+		if len(pc.Entries[i].Reasons) > 0 {
+			pc.Unstable++
+		}
+	}
+	sort.SliceStable(pc.Entries, func(i, j int) bool {
+		if pc.Entries[i].QueryHash == pc.Entries[j].QueryHash {
+			return pc.Entries[i].Host < pc.Entries[j].Host
+		}
+		return pc.Entries[i].QueryHash < pc.Entries[j].QueryHash
+	})
+	return &pc
+}
+
+// getPlanCacheEntry returns a cached plan and scores of candidate plans from a $planCacheStats document
+func getPlanCacheEntry(ns string, doc bson.D) (PlanCacheEntry, []float64) {
+	m := doc.Map()
+	entry := PlanCacheEntry{}
+	entry.Host, _ = m["host"].(string)
+	entry.IsActive, _ = m["isActive"].(bool)
+	entry.PlanCacheKey, _ = m["planCacheKey"].(string)
+	entry.Shard, _ = m["shard"].(string)
+	entry.Works = toInt64(m["works"])
+	if entry.QueryHash, _ = m["queryHash"].(string); entry.QueryHash == "" {
+		entry.QueryHash, _ = m["planCacheShapeHash"].(string) // renamed in 8.0
+	}
+	if t, ok := m["timeOfCreation"].(primitive.DateTime); ok {
+		entry.TimeOfCreation = t.Time()
+	}
+	if query, ok := m["createdFromQuery"].(bson.D); ok {
+		q := query.Map()
+		entry.Shape = GetQueryShape(q["query"], q["sort"], q["projection"])
+		entry.Hash = GetQueryShapeHash(cmdFind, ns, entry.Shape)
+	}
+	if plan, ok := m["cachedPlan"].(bson.D); ok {
+		if entry.Plan = getPlanString(plan); entry.Plan == "" {
+			entry.Plan = "SBE" // slot based plans are not in stages
+		}
+	}
+	scores := []float64{}
+	if list, ok := m["candidatePlanScores"].(primitive.A); ok {
+		for _, v := range list {
+			if score, ok := v.(float64); ok {
+				scores = append(scores, score)
+			}
+		}
+	}
+	return entry, scores
+}
+
+// Correlate matches cached plans to query patterns of logs by queryHash or by query shape
+func (pc *PlanCache) Correlate(ns string, patterns []OpPattern) {
+	for i, entry := range pc.Entries {
+		pc.Entries[i].Patterns = nil
+		hashes := map[string]bool{}
+		if entry.Shape != "" {
+			for _, command := range planCacheCommands {
+				hashes[GetQueryShapeHash(command, ns, entry.Shape)] = true
+			}
+		}
+		for _, op := range patterns {
+			if op.Namespace != ns {
+				continue
+			}
+			if (op.QueryHash != "" && op.QueryHash == entry.QueryHash) || hashes[op.Hash] {
+				pc.Entries[i].Patterns = append(pc.Entries[i].Patterns, op.Hash)
+			}
+		}
+	}
+}
+
+// String returns a summary of cached plans
+func (pc *PlanCache) String() string {
+	strs := []string{fmt.Sprintf("plan cache: %d active, %d inactive, %d unstable", pc.Active, pc.Inactive, pc.Unstable)}
+	for _, entry := range pc.Entries {
+		if len(entry.Reasons) == 0 {
+			continue
+		}
+		host := ""
+		if entry.Host != "" {
+			host = ", host: " + entry.Host
+		}
+		strs = append(strs, fmt.Sprintf("\t! queryHash: %v, works: %d%v, plan: %v", entry.QueryHash, entry.Works, host, entry.Plan))
+		if entry.Shape != "" {
+			strs = append(strs, "\t  shape: "+entry.Shape)
+		}
+		for _, reason := range entry.Reasons {
+			strs = append(strs, "\t  * "+reason)
+		}
+	}
+	return strings.Join(strs, "\n")
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetPlanCache(t *testing.T) {
+	str := `{"entries": [
+		{"createdFromQuery": {"query": {"color": "Red", "year": {"$gt": 2017}}, "sort": {}, "projection": {}},
+			"queryHash": "8F3C2B1A", "planCacheKey": "A1B2C3D4", "isActive": true, "works": {"$numberLong": "12"},
+			"cachedPlan": {"stage": "FETCH", "inputStage": {"stage": "IXSCAN", "keyPattern": {"color": 1}}},
+			"candidatePlanScores": [1.5003, 1.5002], "host": "host1:27017", "shard": "shard01"},
+		{"createdFromQuery": {"query": {"color": "Red", "year": {"$gt": 2017}}, "sort": {}, "projection": {}},
+			"queryHash": "8F3C2B1A", "planCacheKey": "A1B2C3D4", "isActive": true, "works": {"$numberLong": "15"},
+			"cachedPlan": {"stage": "FETCH", "inputStage": {"stage": "IXSCAN", "keyPattern": {"year": 1}}},
+			"candidatePlanScores": [1.9, 1.2], "host": "host2:27017", "shard": "shard02"},
+		{"createdFromQuery": {"query": {"brand": "BMW"}, "sort": {"year": -1}, "projection": {}},
+			"queryHash": "11AA22BB", "planCacheKey": "33CC44DD", "isActive": false, "works": 500,
+			"cachedPlan": {"stage": "FETCH", "inputStage": {"stage": "IXSCAN", "keyPattern": {"brand": 1}}},
+			"host": "host1:27017", "shard": "shard01"},
+		{"queryHash": "55EE66FF", "planCacheKey": "77AA88BB", "isActive": true, "works": 3,
+			"cachedPlan": {"slots": "$$RESULT=s1", "stages": "[1] scan s1"}, "host": "host1:27017"}]}`
+	var doc struct {
+		Entries []bson.D `bson:"entries"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(str), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	ns := "keyhole.cars"
+	pc := getPlanCache(ns, doc.Entries)
+	if pc.Active != 3 || pc.Inactive != 1 || pc.Unstable != 2 || len(pc.Entries) != 4 {
+		t.Fatal("unexpected plan cache summary", pc.String())
+	}
+	entry := pc.Entries[2]
+	if entry.QueryHash != "8F3C2B1A" || entry.Plan != "FETCH > IXSCAN { color: 1 }" || len(entry.Reasons) != 2 {
+		t.Fatal("expected close scores and different plans among hosts", entry)
+	}
+	if pc.Entries[1].Plan != "SBE" || len(pc.Entries[1].Reasons) != 0 {
+		t.Fatal("unexpected SBE entry", pc.Entries[1])
+	}
+	if pc.Entries[0].IsActive || len(pc.Entries[0].Reasons) != 0 {
+		t.Fatal("expected an inactive entry not unstable", pc.Entries[0])
+	}
+	t.Log(pc.String())
+
+	logs := []string{
+		`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"Blue","year":{"$gt":2019}},"$db":"keyhole"},"planSummary":"IXSCAN { color: 1 }","queryHash":"8F3C2B1A","durationMillis":150}}`,
+		`2021-03-01T10:00:02.000+0000 I COMMAND  [conn8] command keyhole.cars command: find { find: "cars", filter: { brand: "Audi" }, sort: { year: -1 }, $db: "keyhole" } planSummary: IXSCAN { brand: 1 } keysExamined:10 docsExamined:10 numYields:0 nreturned:10 queryHash:11AA22BB planCacheKey:33CC44DD reslen:100 protocol:op_msg 120ms`,
+		`{"t":{"$date":"2021-03-01T10:00:03.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"count":"cars","query":{"color":"Blue","year":{"$gt":2019}},"$db":"keyhole"},"planSummary":"IXSCAN { color: 1 }","durationMillis":150}}`,
+	}
+	patterns := []OpPattern{}
+	for _, log := range logs { // logv2 and legacy text logs
+		li := NewLogInfo("utest-xxxxxx")
+		li.SetSilent(true)
+		if err := li.Parse(bufio.NewReader(strings.NewReader(log))); err != nil {
+			t.Fatal(err)
+		}
+		if len(li.OpPatterns) != 1 || (li.OpPatterns[0].QueryHash == "" && li.OpPatterns[0].Command != cmdCount) {
+			t.Fatal("expected queryHash of", log, li.OpPatterns)
+		}
+		patterns = append(patterns, li.OpPatterns...)
+	}
+	pc.Correlate(ns, patterns)
+	correlated := 0
+	for _, entry := range pc.Entries {
+		correlated += len(entry.Patterns)
+	}
+	if correlated != 5 { // a count without queryHash is correlated by query shape
+		t.Fatal("expected 5 correlated entries", pc.Entries)
+	}
+	pc.Correlate("keyhole.trucks", patterns)
+	if len(pc.Entries[0].Patterns) != 0 {
+		t.Fatal("expected no patterns of other namespaces")
+	}
+}
@@ -27,6 +27,8 @@ import (
 type QueryExplainer struct {
 	ExplainCmd ExplainCommand `bson:"explain"`
 	NameSpace  string
+	QueryShape string // normalized query shape, see GetQueryShape
+	QueryHash  string // hash of the query shape, same as the hash of -loginfo
 	client     *mongo.Client
 	document   bson.D
 	isSharded  bool
@@ -36,11 +38,24 @@ type QueryExplainer struct {
 
 // ExplainCommand stores explain document
 type ExplainCommand struct {
-	Collection string `bson:"find"`
-	Filter     bson.D `bson:"filter"`
-	Sort       bson.D `bson:"sort,omitempty"`
-	Hint       bson.D `bson:"hint,omitempty"`
-	Group      string `bson:"group,omitempty"`
+	Collection string      `bson:"find"`
+	Filter     bson.D      `bson:"filter"`
+	Projection bson.D      `bson:"projection,omitempty"`
+	Sort       bson.D      `bson:"sort,omitempty"`
+	Hint       interface{} `bson:"hint,omitempty"` // index keys or index name
+	Collation  bson.D      `bson:"collation,omitempty"`
+	Group      string      `bson:"group,omitempty"`
+	Command    string      `bson:"command,omitempty"` // find (default), aggregate, count, distinct, update, or delete
+	Key        string      `bson:"key,omitempty"`     // field of distinct
+	Pipeline   bson.A      `bson:"pipeline,omitempty"`
+	Update     interface{} `bson:"u,omitempty"`
+}
+
+// PipelineStageStats stores stats of an aggregation pipeline stage
+type PipelineStageStats struct {
+	Stage             string `json:"stage"`
+	NReturned         int64  `json:"nReturned"`
+	ExecTimeMillisEst int64  `json:"executionTimeMillisEstimate"`
 }
 
 type inputStagesLevel struct {
@@ -65,9 +80,13 @@ type StageStats struct {
 
 // ExplainSummary stores explain summary
 type ExplainSummary struct {
-	ShardName              string       `json:"shardName"`
-	ExecutionStats         StageStats   `json:"executionStats"`
-	AllPlansExecutionStats []StageStats `json:"allPlansExecution"`
+	ShardName              string               `json:"shardName"`
+	ExecutionStats         StageStats           `json:"executionStats"`
+	AllPlansExecutionStats []StageStats         `json:"allPlansExecution"`
+	MultiKeyFields         []string             `json:"multiKeyFields,omitempty"`
+	PipelineStages         []PipelineStageStats `json:"pipelineStages,omitempty"`
+	RejectedPlans          []string             `json:"rejectedPlans"`
+	WinningPlan            string               `json:"winningPlan"`
 }
 
 // IndexScore keeps index score
@@ -93,33 +112,178 @@ func (qe *QueryExplainer) GetFilter() bson.D {
 
 // Explain explains query plans
 func (qe *QueryExplainer) Explain() (ExplainSummary, error) {
-	var err error
-	var command bson.D
-	o := QueryExplainer{}
-	b, _ := bson.Marshal(qe)
-	bson.Unmarshal(b, &o)
-	o.ExplainCmd.Group = "" // remove group from index evaluation
-	b, _ = bson.Marshal(o)
-	bson.Unmarshal(b, &command)
-	db := strings.Split(qe.NameSpace, ".")[0]
-	if err = qe.client.Database(db).RunCommand(context.Background(), command).Decode(&qe.document); err != nil {
-		return ExplainSummary{}, err
+	summary, winStage, err := qe.explain()
+	if err != nil {
+		return summary, err
 	}
-	doc := qe.document.Map()
-	winStage := doc["queryPlanner"].(bson.D).Map()["winningPlan"].(bson.D).Map()["stage"].(string)
 	if winStage == "EOF" {
 		return ExplainSummary{}, errors.New("no data found to be explained")
 	} else if winStage == "COLLSCAN" {
 		return ExplainSummary{}, errors.New("no index selected (COLLSCAN)")
 	}
+	return summary, err
+}
+
+// explain runs explain of all plans execution and returns the summary and the stage of the winning plan
+func (qe *QueryExplainer) explain() (ExplainSummary, string, error) {
+	var err error
+	db := strings.Split(qe.NameSpace, ".")[0]
+	if err = qe.client.Database(db).RunCommand(context.Background(), qe.getExplainCommand()).Decode(&qe.document); err != nil {
+		return ExplainSummary{}, "", err
+	}
+	doc, stages, shardName := getPipelineExplain(qe.document.Map())
+	queryPlanner, ok := doc["queryPlanner"].(bson.D)
+	if !ok {
+		return ExplainSummary{ShardName: shardName, PipelineStages: stages}, "", errors.New("no query plan found to be explained")
+	}
+	winStage, _ := getWinningPlan(queryPlanner)["stage"].(string)
+	summary := qe.GetExplainDetails(doc)
+	summary.PipelineStages = stages
+	if summary.ShardName == "" {
+		summary.ShardName = shardName
+	}
+	return summary, winStage, err
+}
+
+// getExplainCommand returns explain command of all plans execution
+func (qe *QueryExplainer) getExplainCommand() bson.D {
+	return bson.D{{Key: "explain", Value: qe.ExplainCmd.ToCommand()}, {Key: "verbosity", Value: "allPlansExecution"}}
+}
+
+// ToCommand returns the command to be explained
+func (ec ExplainCommand) ToCommand() bson.D {
+	filter := ec.Filter
+	if filter == nil {
+		filter = bson.D{}
+	}
+	var command bson.D
+	switch ec.Command {
+	case cmdAggregate:
+		pipeline := ec.Pipeline
+		if len(pipeline) == 0 {
+			pipeline = bson.A{bson.D{{Key: "$match", Value: filter}}}
+			if len(ec.Sort) > 0 {
+				pipeline = append(pipeline, bson.D{{Key: "$sort", Value: ec.Sort}})
+			}
+		}
+		command = bson.D{{Key: "aggregate", Value: ec.Collection}, {Key: "pipeline", Value: pipeline},
+			{Key: "cursor", Value: bson.D{}}}
+	case cmdCount:
+		command = bson.D{{Key: "count", Value: ec.Collection}, {Key: "query", Value: filter}}
+	case cmdDistinct: // hint is not supported by distinct before 7.1
+		command = bson.D{{Key: "distinct", Value: ec.Collection}, {Key: "key", Value: ec.Key}, {Key: "query", Value: filter}}
+		if len(ec.Collation) > 0 {
+			command = append(command, bson.E{Key: "collation", Value: ec.Collation})
+		}
+		return command
+	case cmdUpdate:
+		update := ec.Update
+		if update == nil {
+			update = bson.D{} // a replacement is as good as any to evaluate query plans
+		}
+		statement := ec.appendOptions(bson.D{{Key: "q", Value: filter}, {Key: "u", Value: update}})
+		return bson.D{{Key: "update", Value: ec.Collection}, {Key: "updates", Value: bson.A{statement}}}
+	case cmdDelete:
+		statement := ec.appendOptions(bson.D{{Key: "q", Value: filter}, {Key: "limit", Value: 0}})
+		return bson.D{{Key: "delete", Value: ec.Collection}, {Key: "deletes", Value: bson.A{statement}}}
+	default:
+		command = bson.D{{Key: "find", Value: ec.Collection}, {Key: "filter", Value: filter}}
+		if len(ec.Projection) > 0 {
+			command = append(command, bson.E{Key: "projection", Value: ec.Projection})
+		}
+		if len(ec.Sort) > 0 {
+			command = append(command, bson.E{Key: "sort", Value: ec.Sort})
+		}
+	}
+	return ec.appendOptions(command)
+}
 
-	return qe.GetExplainDetails(doc), err
+// appendOptions appends hint and collation to a command or a write statement
+func (ec ExplainCommand) appendOptions(command bson.D) bson.D {
+	if ec.Hint != nil {
+		command = append(command, bson.E{Key: "hint", Value: ec.Hint})
+	}
+	if len(ec.Collation) > 0 {
+		command = append(command, bson.E{Key: "collation", Value: ec.Collation})
+	}
+	return command
+}
+
+// getPipelineExplain returns the query explain, stats of pipeline stages, and the shard
+// evaluated from an explain document.  The query of an aggregation is under the $cursor
+// stage unless the whole pipeline is pushed down to the query layer.
+func getPipelineExplain(doc bson.M) (bson.M, []PipelineStageStats, string) {
+	shardName := ""
+	if shards, ok := doc["shards"].(bson.D); ok && doc["queryPlanner"] == nil {
+		maxReturned := int64(-1)
+		for _, shard := range shards {
+			explain, ok := shard.Value.(bson.D)
+			if !ok {
+				continue
+			}
+			if n := getExplainNReturned(explain.Map()); n > maxReturned {
+				maxReturned = n
+				shardName = shard.Key
+				doc = explain.Map()
+			}
+		}
+	}
+	stages := []PipelineStageStats{}
+	pipeline, ok := doc["stages"].(primitive.A)
+	if !ok {
+		return doc, stages, shardName
+	}
+	cursor := doc
+	for _, elem := range pipeline {
+		stage, ok := elem.(bson.D)
+		if !ok || len(stage) == 0 {
+			continue
+		}
+		m := stage.Map()
+		stages = append(stages, PipelineStageStats{Stage: stage[0].Key, NReturned: toInt64(m["nReturned"]),
+			ExecTimeMillisEst: toInt64(m["executionTimeMillisEstimate"])})
+		if explain, ok := stage[0].Value.(bson.D); ok && stage[0].Key == "$cursor" {
+			cursor = explain.Map()
+		}
+	}
+	return cursor, stages, shardName
+}
+
+// getExplainNReturned returns number of documents returned of an explain document
+func getExplainNReturned(doc bson.M) int64 {
+	if stats, ok := doc["executionStats"].(bson.D); ok {
+		return toInt64(stats.Map()["nReturned"])
+	}
+	if stages, ok := doc["stages"].(primitive.A); ok && len(stages) > 0 {
+		if stage, ok := stages[0].(bson.D); ok && len(stage) > 0 {
+			if cursor, ok := stage[0].Value.(bson.D); ok && stage[0].Key == "$cursor" {
+				return getExplainNReturned(cursor.Map())
+			}
+			return toInt64(stage.Map()["nReturned"])
+		}
+	}
+	return 0
+}
+
+// getWinningPlan returns the winning plan, of which the query plan is under queryPlan
+// if executed by the slot based engine (SBE) on 5.0+
+func getWinningPlan(queryPlanner bson.D) bson.M {
+	winningPlan, ok := queryPlanner.Map()["winningPlan"].(bson.D)
+	if !ok {
+		return bson.M{}
+	}
+	if queryPlan, ok := winningPlan.Map()["queryPlan"].(bson.D); ok {
+		return queryPlan.Map()
+	}
+	return winningPlan.Map()
 }
 
 // GetExplainDetails returns summary from a doc
 func (qe *QueryExplainer) GetExplainDetails(doc bson.M) ExplainSummary {
 	summary := ExplainSummary{}
-	winningPlan := doc["queryPlanner"].(bson.D).Map()["winningPlan"].(bson.D).Map()
+	qe.isSharded = false
+	qe.shardUsed = 0
+	winningPlan := getWinningPlan(doc["queryPlanner"].(bson.D))
 	if winningPlan["shards"] != nil {
 		qe.isSharded = true
 	}
@@ -135,7 +299,7 @@ func (qe *QueryExplainer) GetExplainDetails(doc bson.M) ExplainSummary {
 			shardNames = append(shardNames, plansExecution.(bson.D).Map()["shardName"].(string))
 			allPlans := plansExecution.(bson.D).Map()["allPlans"].(primitive.A)
 			for _, plan := range allPlans {
-				rt := plan.(bson.D).Map()["nReturned"].(int32)
+				rt := ToInt32(plan.(bson.D).Map()["nReturned"])
 				if rt > maxReturned {
 					maxReturned = rt
 					qe.shardUsed = i
@@ -149,9 +313,83 @@ func (qe *QueryExplainer) GetExplainDetails(doc bson.M) ExplainSummary {
 		exec := execution.(bson.D)
 		summary.AllPlansExecutionStats = append(summary.AllPlansExecutionStats, qe.getStageStats(exec))
 	}
+	plans := doc["queryPlanner"].(bson.D).Map()
+	if shards, ok := winningPlan["shards"].(primitive.A); ok && qe.shardUsed < len(shards) {
+		if shard, ok := shards[qe.shardUsed].(bson.D); ok {
+			plans = shard.Map()
+		}
+	}
+	multikeys := map[string]bool{}
+	if plan, ok := plans["winningPlan"].(bson.D); ok {
+		summary.WinningPlan = getPlanString(plan)
+		addMultiKeyFields(plan, multikeys)
+	}
+	summary.RejectedPlans = []string{}
+	if rejectedPlans, ok := plans["rejectedPlans"].(primitive.A); ok {
+		for _, plan := range rejectedPlans {
+			if p, ok := plan.(bson.D); ok {
+				summary.RejectedPlans = append(summary.RejectedPlans, getPlanString(p))
+				addMultiKeyFields(p, multikeys)
+			}
+		}
+	}
+	for field := range multikeys {
+		summary.MultiKeyFields = append(summary.MultiKeyFields, field)
+	}
+	sort.Strings(summary.MultiKeyFields)
 	return summary
 }
 
+// addMultiKeyFields adds fields of array values from multiKeyPaths of index scans of a plan
+func addMultiKeyFields(plan bson.D, fields map[string]bool) {
+	m := plan.Map()
+	if queryPlan, ok := m["queryPlan"].(bson.D); ok {
+		m = queryPlan.Map()
+	}
+	if paths, ok := m["multiKeyPaths"].(bson.D); ok {
+		for _, path := range paths {
+			if len(getArray(path.Value)) > 0 {
+				fields[path.Key] = true
+			}
+		}
+	}
+	if inputStage, ok := m["inputStage"].(bson.D); ok {
+		addMultiKeyFields(inputStage, fields)
+	}
+	if inputStages, ok := m["inputStages"].(primitive.A); ok {
+		for _, input := range inputStages {
+			if stage, ok := input.(bson.D); ok {
+				addMultiKeyFields(stage, fields)
+			}
+		}
+	}
+}
+
+// getPlanString returns stages of a plan from the top, e.g. FETCH > IXSCAN { a: 1 }
+func getPlanString(plan bson.D) string {
+	m := plan.Map()
+	if queryPlan, ok := m["queryPlan"].(bson.D); ok {
+		m = queryPlan.Map()
+	}
+	str, _ := m["stage"].(string)
+	if keyPattern, ok := m["keyPattern"].(bson.D); ok {
+		str += " " + getIndexKeyString(keyPattern)
+	}
+	if inputStage, ok := m["inputStage"].(bson.D); ok {
+		return str + " > " + getPlanString(inputStage)
+	}
+	if inputStages, ok := m["inputStages"].(primitive.A); ok {
+		strs := []string{}
+		for _, input := range inputStages {
+			if stage, ok := input.(bson.D); ok {
+				strs = append(strs, getPlanString(stage))
+			}
+		}
+		return str + " > [" + strings.Join(strs, ", ") + "]"
+	}
+	return str
+}
+
 // GetSummary get summary of explain executionStats
 func (qe *QueryExplainer) GetSummary(summary ExplainSummary) string {
 	var buffer bytes.Buffer
@@ -168,8 +406,23 @@ func (qe *QueryExplainer) GetSummary(summary ExplainSummary) string {
 	buffer.WriteString("Query Shape:\n" + gox.Stringify(qshape, "", "  ") + "\n")
 	buffer.WriteString("\n=> Execution Stats\n")
 	buffer.WriteString("=========================================\n")
-	buffer.WriteString("Winning Plan:\n")
+	buffer.WriteString("Winning Plan: " + summary.WinningPlan + "\n")
 	buffer.WriteString(getStageStatsSummaryString(summary.ExecutionStats, 1))
+	if len(summary.RejectedPlans) > 0 {
+		buffer.WriteString("Rejected Plans:\n")
+		for _, plan := range summary.RejectedPlans {
+			buffer.WriteString("  " + plan + "\n")
+		}
+	}
+
+	if len(summary.PipelineStages) > 0 {
+		buffer.WriteString("\n=> Pipeline Stages\n")
+		buffer.WriteString("=========================================\n")
+		for _, stage := range summary.PipelineStages {
+			buffer.WriteString(fmt.Sprintf("%-16s nReturned: %v, executionTimeMillisEstimate: %v\n",
+				stage.Stage, stage.NReturned, stage.ExecTimeMillisEst))
+		}
+	}
 
 	if len(summary.AllPlansExecutionStats) > 0 {
 		buffer.WriteString("\n=> All Plans Execution\n")
@@ -190,13 +443,13 @@ func (qe *QueryExplainer) GetSummary(summary ExplainSummary) string {
 // we can run hint as {"explain": {"find": collectionName, "filter": filter, "sort": sortSpec, "hint": index}}
 func (qe *QueryExplainer) getStageStats(document bson.D) StageStats {
 	execution := document.Map()
-	summary := StageStats{TotalKeysExamined: execution["totalKeysExamined"].(int32),
-		TotalDocsExamined: execution["totalDocsExamined"].(int32),
+	summary := StageStats{TotalKeysExamined: ToInt32(execution["totalKeysExamined"]),
+		TotalDocsExamined: ToInt32(execution["totalDocsExamined"]),
 		InputStages:       []StageStats{}}
 	executionStages := execution["executionStages"].(bson.D).Map()
 	if qe.isSharded {
-		shard := StageStats{TotalKeysExamined: execution["totalKeysExamined"].(int32),
-			TotalDocsExamined: execution["totalDocsExamined"].(int32),
+		shard := StageStats{TotalKeysExamined: ToInt32(execution["totalKeysExamined"]),
+			TotalDocsExamined: ToInt32(execution["totalDocsExamined"]),
 			InputStages:       []StageStats{}}
 		shard.Stage = executionStages["stage"].(string)
 		shards, ok := executionStages["shards"].(primitive.A)
@@ -216,8 +469,8 @@ func (qe *QueryExplainer) getStageStats(document bson.D) StageStats {
 	for _, elem := range inputStagesLevelArray {
 		stages = append(stages, getAllStages(elem.inputStages)...)
 	}
-	advanced := executionStages["advanced"].(int32)
-	works := executionStages["works"].(int32)
+	advanced := getAdvanced(executionStages)
+	works := ToInt32(executionStages["works"])
 	summary.Score = getScore(advanced, works, stages)
 	summary.Stage = executionStages["stage"].(string)
 	if executionStages["filter"] != nil {
@@ -229,7 +482,7 @@ func (qe *QueryExplainer) getStageStats(document bson.D) StageStats {
 	}
 	summary.Advanced = advanced
 	summary.Works = works
-	summary.ExecTimeMillisEst = executionStages["executionTimeMillisEstimate"].(int32)
+	summary.ExecTimeMillisEst = ToInt32(executionStages["executionTimeMillisEstimate"])
 	for _, elem := range inputStagesLevelArray {
 		stage := StageStats{Level: elem.level}
 		for _, input := range elem.inputStages {
@@ -253,9 +506,9 @@ func (qe *QueryExplainer) getStageStats(document bson.D) StageStats {
 				b, _ = json.Marshal(v)
 				stage.Filter = gox.NewOrderedMap(string(b))
 			}
-			stage.Advanced = inputStage["advanced"].(int32)
-			stage.Works = inputStage["works"].(int32)
-			stage.ExecTimeMillisEst = inputStage["executionTimeMillisEstimate"].(int32)
+			stage.Advanced = getAdvanced(inputStage)
+			stage.Works = ToInt32(inputStage["works"])
+			stage.ExecTimeMillisEst = ToInt32(inputStage["executionTimeMillisEstimate"])
 			summary.InputStages = append(summary.InputStages, stage)
 		}
 	}
@@ -269,6 +522,9 @@ func (qe *QueryExplainer) GetIndexesScores(keys []string) []IndexScore {
 	var indexes []string
 	scores := []IndexScore{}
 	ctx := context.Background()
+	if qe.ExplainCmd.Command == cmdDistinct { // distinct doesn't support hint
+		return scores
+	}
 	pos := strings.Index(qe.NameSpace, ".")
 	db := qe.NameSpace[:pos]
 	coll := qe.NameSpace[pos+1:]
@@ -320,24 +576,22 @@ func (qe *QueryExplainer) GetIndexesScores(keys []string) []IndexScore {
 	}
 	// Execute explain on all indexes
 	for _, index := range indexes {
-		bson.UnmarshalExtJSON([]byte(index), true, &qe.ExplainCmd.Hint)
-		o := QueryExplainer{}
-		b, _ := bson.Marshal(qe)
-		bson.Unmarshal(b, &o)
-		o.ExplainCmd.Group = "" // remove group from index evaluation
-		b, _ = bson.Marshal(o)
-		var cmd bson.D
-		bson.Unmarshal(b, &cmd)
-		filter := cmd.Map()["explain"].(bson.D).Map()["hint"].(bson.D)
-		if len(filter) == 0 || keyMap[filter[0].Key] == "" {
+		var hint bson.D
+		bson.UnmarshalExtJSON([]byte(index), true, &hint)
+		if len(hint) == 0 || keyMap[hint[0].Key] == "" {
 			continue
 		}
+		qe.ExplainCmd.Hint = hint
 		var document = bson.D{}
-		if err = collection.Database().RunCommand(ctx, cmd).Decode(&document); err != nil {
+		if err = collection.Database().RunCommand(ctx, qe.getExplainCommand()).Decode(&document); err != nil {
 			fmt.Println(err.Error())
 			continue
 		}
-		summary := qe.GetExplainDetails(document.Map())
+		doc, _, _ := getPipelineExplain(document.Map())
+		if doc["queryPlanner"] == nil {
+			continue
+		}
+		summary := qe.GetExplainDetails(doc)
 		stages := []string{}
 		for _, elem := range summary.ExecutionStats.InputStages {
 			stages = append(stages, elem.Stage)
@@ -366,19 +620,45 @@ func (qe *QueryExplainer) ReadQueryShape(buffer []byte) error {
 	var doc bson.D
 	var ns string
 	explainCmd := ExplainCommand{}
+	if isLogv2Line(buffer) {
+		return qe.readLogv2QueryShape(buffer)
+	}
 	if err = bson.UnmarshalExtJSON(buffer, true, &doc); err == nil {
 		if doc.Map()["filter"] != nil {
 			explainCmd.Filter = doc.Map()["filter"].(bson.D)
 		}
+		if doc.Map()["projection"] != nil {
+			explainCmd.Projection = doc.Map()["projection"].(bson.D)
+		}
 		if doc.Map()["sort"] != nil {
 			explainCmd.Sort = doc.Map()["sort"].(bson.D)
 		}
-		if doc.Map()["hint"] != nil {
-			explainCmd.Hint = doc.Map()["hint"].(bson.D)
+		explainCmd.Hint = doc.Map()["hint"]
+		if collation, ok := doc.Map()["collation"].(bson.D); ok {
+			explainCmd.Collation = collation
+		}
+		if command, ok := doc.Map()["command"].(string); ok {
+			explainCmd.Command = command
+		}
+		if pipeline, ok := doc.Map()["pipeline"].(bson.A); ok {
+			explainCmd.Command = cmdAggregate
+			explainCmd.Pipeline = pipeline
+			explainCmd.setPipelineFilter()
+		}
+		if key, ok := doc.Map()["key"].(string); ok {
+			explainCmd.Command = cmdDistinct
+			explainCmd.Key = key
+		}
+		if doc.Map()["u"] != nil {
+			explainCmd.Command = cmdUpdate
+			explainCmd.Update = doc.Map()["u"]
 		}
 		ns = doc.Map()["ns"].(string)
 		pos := strings.Index(ns, ".")
 		explainCmd.Collection = ns[pos+1:]
+		qe.ExplainCmd = explainCmd
+		qe.NameSpace = ns
+		qe.setQueryShape()
 		return err
 	}
 	err = nil
@@ -397,6 +677,9 @@ func (qe *QueryExplainer) ReadQueryShape(buffer []byte) error {
 	if filter == "" {
 		filter = ml.Get(`"query":`)
 	}
+	if filter == "" {
+		filter = ml.Get(`"q":`)
+	}
 	// if group != "" {
 	// 	d := bson.M{}
 	// 	bson.UnmarshalExtJSON([]byte(group), true, &d)
@@ -421,16 +704,153 @@ func (qe *QueryExplainer) ReadQueryShape(buffer []byte) error {
 		sort = ml.Get(`"$sort":`)
 	}
 	bson.UnmarshalExtJSON([]byte(sort), true, &(explainCmd.Sort))
+	if projection := ml.Get(`"projection":`); projection != "" {
+		bson.UnmarshalExtJSON([]byte(projection), true, &(explainCmd.Projection))
+	}
 	xs := string(buffer)
 	i := strings.Index(xs, "] ")
-	ns = strings.Split(xs[i+2:], " ")[1]
+	fields := strings.Split(xs[i+2:], " ")
+	ns = fields[1]
+	if fields[0] == cmdUpdate {
+		explainCmd.Command = cmdUpdate
+		var update bson.M
+		if json.Unmarshal([]byte(ml.Get(`"u":`)), &update) == nil {
+			explainCmd.Update = update
+		}
+	} else if fields[0] == cmdRemove {
+		explainCmd.Command = cmdDelete
+	} else if m := regexp.MustCompile(`command: (aggregate|count|distinct) `).FindStringSubmatch(xs); m != nil {
+		explainCmd.Command = m[1]
+		if m = regexp.MustCompile(`key: "([^"]+)"`).FindStringSubmatch(xs); m != nil {
+			explainCmd.Key = m[1]
+		}
+	}
 	pos := strings.Index(ns, ".")
 	explainCmd.Collection = ns[pos+1:]
 	qe.ExplainCmd = explainCmd
 	qe.NameSpace = ns
+	qe.setQueryShape()
+	return err
+}
+
+// isLogv2Line returns true if a line is a logv2 (4.4+) JSON log entry
+func isLogv2Line(buffer []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(buffer), []byte("{")) && bytes.Contains(buffer, []byte(`"attr":`))
+}
+
+// readLogv2QueryShape reads the command of a logv2 slow op, and the originating command of a getMore
+func (qe *QueryExplainer) readLogv2QueryShape(buffer []byte) error {
+	var err error
+	var doc Logv2
+	if err = json.Unmarshal(buffer, &doc); err != nil {
+		return err
+	}
+	// read commands again as bson.D to keep orders of sort keys and pipeline stages
+	var ordered struct {
+		Attributes struct {
+			Command            bson.D `bson:"command"`
+			OriginatingCommand bson.D `bson:"originatingCommand"`
+		} `bson:"attr"`
+	}
+	if err = bson.UnmarshalExtJSON(buffer, false, &ordered); err != nil {
+		return err
+	}
+	if doc.Attributes.Command == nil || doc.Attributes.NS == "" {
+		return errors.New("no command found")
+	}
+	op := doc.Attributes.Type
+	command := ordered.Attributes.Command
+	if op == "command" || op == "none" {
+		op = getOp(doc.Attributes.Command)
+	}
+	if op == cmdGetMore {
+		command = ordered.Attributes.OriginatingCommand
+	}
+	// plans of findAndModify are evaluated as of find
+	op = GetShapeCommand(op, getOp(doc.Attributes.OriginatingCommand))
+	explainCmd := ExplainCommand{Command: op}
+	switch op {
+	case cmdFind:
+		explainCmd.Projection, _ = GetDocField(command, "projection").(bson.D)
+		explainCmd.Sort, _ = GetDocField(command, "sort").(bson.D)
+		for _, key := range []string{"filter", "query"} {
+			if filter, ok := GetDocField(command, key).(bson.D); ok {
+				explainCmd.Filter = filter
+				break
+			}
+		}
+	case cmdAggregate:
+		explainCmd.Pipeline, _ = GetDocField(command, "pipeline").(bson.A)
+		explainCmd.setPipelineFilter()
+	case cmdCount, cmdDistinct:
+		explainCmd.Filter, _ = GetDocField(command, "query").(bson.D)
+		explainCmd.Key, _ = GetDocField(command, "key").(string)
+	case cmdUpdate, cmdDelete:
+		statement := command
+		for _, key := range []string{"updates", "deletes"} {
+			if statements, ok := GetDocField(command, key).(bson.A); ok && len(statements) > 0 {
+				statement, _ = statements[0].(bson.D)
+			}
+		}
+		explainCmd.Filter, _ = GetDocField(statement, "q").(bson.D)
+		explainCmd.Update = GetDocField(statement, "u")
+		command = statement
+	default:
+		return fmt.Errorf("%v is not supported", op)
+	}
+	explainCmd.Hint = GetDocField(command, "hint")
+	explainCmd.Collation, _ = GetDocField(command, "collation").(bson.D)
+	pos := strings.Index(doc.Attributes.NS, ".")
+	explainCmd.Collection = doc.Attributes.NS[pos+1:]
+	qe.ExplainCmd = explainCmd
+	qe.NameSpace = doc.Attributes.NS
+	qe.setQueryShape()
 	return err
 }
 
+// setQueryShape sets query shape and hash using the same normalizer and command names as -loginfo
+func (qe *QueryExplainer) setQueryShape() {
+	ec := qe.ExplainCmd
+	command := cmdFind
+	if ec.Command != "" {
+		command = GetShapeCommand(ec.Command, "")
+	}
+	switch command {
+	case cmdAggregate:
+		pipeline := ec.Pipeline
+		if len(pipeline) == 0 {
+			pipeline = ec.ToCommand().Map()["pipeline"].(bson.A)
+		}
+		qe.QueryShape = NormalizePipeline(pipeline)
+	case cmdUpdate, cmdDelete:
+		qe.QueryShape = GetQueryShape(ec.Filter, nil, nil)
+	default:
+		qe.QueryShape = GetQueryShape(ec.Filter, ec.Sort, ec.Projection)
+		if command == cmdDistinct {
+			qe.QueryShape += ", key: " + ec.Key
+		}
+	}
+	qe.QueryHash = GetQueryShapeHash(command, qe.NameSpace, qe.QueryShape)
+}
+
+// setPipelineFilter sets filter and sort from the leading $match and $sort stages of a pipeline
+func (ec *ExplainCommand) setPipelineFilter() {
+	for i, elem := range ec.Pipeline {
+		stage, ok := elem.(bson.D)
+		if !ok || len(stage) == 0 {
+			return
+		}
+		if stage[0].Key == "$match" && i == 0 {
+			ec.Filter, _ = stage[0].Value.(bson.D)
+		} else if stage[0].Key == "$sort" {
+			ec.Sort, _ = stage[0].Value.(bson.D)
+			return
+		} else {
+			return
+		}
+	}
+}
+
 func getStageStatsSummaryString(stat StageStats, level int) string {
 	var buffer bytes.Buffer
 	if stat.Stage == "SHARD_MERGE" {
@@ -490,6 +910,14 @@ func getInputStagesLevel(inputStage bson.D, level int) []inputStagesLevel {
 	return inputStagesLevelArray
 }
 
+// getAdvanced returns advanced of a stage, which is advances if executed by SBE
+func getAdvanced(stage bson.M) int32 {
+	if stage["advances"] != nil {
+		return ToInt32(stage["advances"])
+	}
+	return ToInt32(stage["advanced"])
+}
+
 func getAllStages(inputStages []bson.D) []string {
 	stages := []string{}
 	for _, input := range inputStages {
@@ -507,6 +935,9 @@ func getAllStages(inputStages []bson.D) []string {
 // by default noFetchBonus, noSortBonus, noIxisectBonus = epsilon
 // epsilon = std::min(1.0 / static_cast<double>(10 * workUnits), 1e-4);
 func getScore(advacned int32, works int32, stages []string) float64 {
+	if works == 0 { // no works reported by SBE stages
+		return 0
+	}
 	produtivity := float64(advacned) / float64(works)
 	epsilon := math.Min(1/float64(works), .0001)
 	noFetchBonus := epsilon
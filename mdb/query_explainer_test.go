@@ -3,9 +3,11 @@
 package mdb
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -42,3 +44,124 @@ func TestGetExplainSummaryShard(t *testing.T) {
 	bson.Unmarshal(data, &v)
 	t.Log(qa.GetExplainDetails(v["explain"].(bson.M)))
 }
+
+func TestExplainCommandToCommand(t *testing.T) {
+	qe := NewQueryExplainer(nil)
+	str := `{"ns": "keyhole.cars", "pipeline": [{"$match": {"color": "Red"}}, {"$sort": {"year": -1}}, {"$group": {"_id": "$brand"}}]}`
+	if err := qe.ReadQueryShape([]byte(str)); err != nil {
+		t.Fatal(err)
+	}
+	if qe.ExplainCmd.Command != cmdAggregate || len(qe.ExplainCmd.Filter) != 1 || len(qe.ExplainCmd.Sort) != 1 {
+		t.Fatal("unexpected explain command", qe.ExplainCmd)
+	}
+	cmd := qe.getExplainCommand().Map()
+	if cmd["verbosity"] != "allPlansExecution" || cmd["explain"].(bson.D)[0].Key != "aggregate" {
+		t.Fatal("unexpected command", cmd)
+	}
+
+	li := NewLogInfo("utest-xxxxxx")
+	li.SetSilent(true)
+	log := `{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"aggregate":"cars","pipeline":[{"$match":{"color":"Blue"}},{"$sort":{"year":-1}},{"$group":{"_id":"$brand"}}],"cursor":{},"$db":"keyhole"},"planSummary":"COLLSCAN","durationMillis":150}}`
+	if err := li.Parse(bufio.NewReader(strings.NewReader(log))); err != nil {
+		t.Fatal(err)
+	}
+	if len(li.OpPatterns) != 1 || li.OpPatterns[0].Hash != qe.QueryHash {
+		t.Fatal("expected the same hash as of -loginfo", qe.QueryHash, li.OpPatterns)
+	}
+
+	commands := map[string]string{cmdCount: "count", cmdDelete: "delete", cmdDistinct: "distinct", cmdUpdate: "update", "": "find"}
+	for command, key := range commands {
+		ec := ExplainCommand{Collection: "cars", Command: command, Filter: bson.D{{Key: "color", Value: "Red"}}}
+		if cmd := ec.ToCommand(); cmd[0].Key != key || cmd[0].Value != "cars" {
+			t.Fatal("unexpected command", cmd)
+		}
+	}
+}
+
+func TestGetPipelineExplain(t *testing.T) {
+	str := `{"shards": {
+		"shard01": {"stages": [{"$cursor": {"queryPlanner": {"winningPlan": {"queryPlan": {"stage": "IXSCAN"}}}, "executionStats": {"nReturned": 1}}}]},
+		"shard02": {"stages": [
+			{"$cursor": {"queryPlanner": {"winningPlan": {"stage": "FETCH"}}, "executionStats": {"nReturned": 5}}, "nReturned": 5, "executionTimeMillisEstimate": 3},
+			{"$group": {"_id": "$brand"}, "nReturned": 2, "executionTimeMillisEstimate": 4}]}}}`
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(str), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	cursor, stages, shardName := getPipelineExplain(doc.Map())
+	if shardName != "shard02" || len(stages) != 2 || stages[1].Stage != "$group" || stages[1].ExecTimeMillisEst != 4 {
+		t.Fatal("unexpected pipeline stages", shardName, stages)
+	}
+	if getWinningPlan(cursor["queryPlanner"].(bson.D))["stage"] != "FETCH" {
+		t.Fatal("unexpected winning plan", cursor)
+	}
+	shard01 := doc.Map()["shards"].(bson.D)[0].Value.(bson.D).Map()["stages"].(bson.A)[0].(bson.D)[0].Value.(bson.D)
+	if getWinningPlan(shard01.Map()["queryPlanner"].(bson.D))["stage"] != "IXSCAN" {
+		t.Fatal("expected query plan of SBE")
+	}
+}
+
+func TestReadLogv2QueryShape(t *testing.T) {
+	logs := []string{
+		`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"Red","year":{"$gt":2017}},"sort":{"year":-1,"brand":1},"projection":{"_id":0},"hint":"color_1","collation":{"locale":"fr"},"$db":"keyhole"},"planSummary":"IXSCAN { color: 1 }","durationMillis":150}}`,
+		`{"t":{"$date":"2021-03-01T10:00:02.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"getMore":1234,"collection":"cars","$db":"keyhole"},"originatingCommand":{"aggregate":"cars","pipeline":[{"$match":{"brand":"BMW"}},{"$sort":{"year":1}}],"cursor":{},"$db":"keyhole"},"durationMillis":120}}`,
+		`{"t":{"$date":"2021-03-01T10:00:03.000+00:00"},"s":"I","c":"WRITE","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"update","ns":"keyhole.cars","command":{"q":{"brand":"BMW"},"u":{"$set":{"sold":true}},"hint":{"brand":1}},"planSummary":"COLLSCAN","durationMillis":110}}`,
+	}
+	qe := NewQueryExplainer(nil)
+	if err := qe.ReadQueryShape([]byte(logs[0])); err != nil {
+		t.Fatal(err)
+	}
+	ec := qe.ExplainCmd
+	if qe.NameSpace != "keyhole.cars" || ec.Command != cmdFind || len(ec.Filter) != 2 || ec.Sort[0].Key != "year" ||
+		ec.Hint != "color_1" || ec.Collation[0].Value != "fr" || len(ec.Projection) != 1 {
+		t.Fatal("unexpected find command", ec)
+	}
+	cmd := ec.ToCommand().Map()
+	if cmd["hint"] != "color_1" || cmd["collation"] == nil {
+		t.Fatal("expected hint and collation", cmd)
+	}
+
+	if err := qe.ReadQueryShape([]byte(logs[1])); err != nil {
+		t.Fatal(err)
+	}
+	if ec = qe.ExplainCmd; ec.Command != cmdAggregate || len(ec.Pipeline) != 2 || ec.Filter[0].Key != "brand" {
+		t.Fatal("expected originating aggregate command", ec)
+	}
+
+	if err := qe.ReadQueryShape([]byte(logs[2])); err != nil {
+		t.Fatal(err)
+	}
+	if ec = qe.ExplainCmd; ec.Command != cmdUpdate || ec.Filter[0].Key != "brand" || ec.Update == nil || ec.Hint == nil {
+		t.Fatal("unexpected update command", ec)
+	}
+	statement := ec.ToCommand().Map()["updates"].(bson.A)[0].(bson.D).Map()
+	if statement["hint"] == nil || statement["u"] == nil {
+		t.Fatal("unexpected update statement", statement)
+	}
+}
+
+func TestReadQueryShapeHashOfLogInfo(t *testing.T) {
+	logs := []string{
+		`{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"WRITE","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"remove","ns":"keyhole.cars","command":{"q":{"brand":"BMW"},"limit":0},"planSummary":"COLLSCAN","durationMillis":110}}`,
+		`{"t":{"$date":"2021-03-01T10:00:02.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"getMore":1234,"collection":"cars","$db":"keyhole"},"originatingCommand":{"find":"cars","filter":{"color":"Red"},"sort":{"year":-1},"$db":"keyhole"},"planSummary":"COLLSCAN","durationMillis":120}}`,
+		`{"t":{"$date":"2021-03-01T10:00:03.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"findAndModify":"cars","query":{"brand":"BMW","year":2020},"sort":{"year":1},"update":{"$set":{"sold":true}},"$db":"keyhole"},"planSummary":"COLLSCAN","durationMillis":130}}`,
+	}
+	for _, str := range logs {
+		li := NewLogInfo("utest-xxxxxx")
+		li.SetSilent(true)
+		if err := li.Parse(bufio.NewReader(strings.NewReader(str))); err != nil {
+			t.Fatal(err)
+		}
+		if len(li.OpPatterns) != 1 {
+			t.Fatal("expected a query pattern of", str)
+		}
+		qe := NewQueryExplainer(nil)
+		if err := qe.ReadQueryShape([]byte(str)); err != nil {
+			t.Fatal(err)
+		}
+		if qe.QueryHash != li.OpPatterns[0].Hash {
+			t.Fatalf("expected the same hash of %v %v but got %v and %v", li.OpPatterns[0].Command,
+				li.OpPatterns[0].Filter, li.OpPatterns[0].Hash, qe.QueryHash)
+		}
+	}
+}
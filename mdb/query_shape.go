@@ -0,0 +1,499 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A query shape is a canonical string of a query with literals replaced by 1.
+// Keys of filters are sorted, $and/$or/$nor branches are sorted and deduped,
+// $in/$nin/$all lists are shown as [...], and regular expressions as /^.../i.
+// Sort specs keep their order and directions, and aggregation pipelines keep
+// stages in order with structural values, e.g. $lookup from and as.
+
+// extended JSON wrappers of literal values
+var extJSONTypes = map[string]bool{"$binary": true, "$code": true, "$date": true, "$maxKey": true, "$minKey": true,
+	"$numberDecimal": true, "$numberDouble": true, "$numberInt": true, "$numberLong": true, "$oid": true,
+	"$regularExpression": true, "$symbol": true, "$timestamp": true, "$uuid": true}
+
+// fields of stages whose string values are names or paths
+var structuralFields = map[string]bool{"as": true, "coll": true, "connectFromField": true, "connectToField": true,
+	"db": true, "depthField": true, "foreignField": true, "from": true, "includeArrayIndex": true, "into": true,
+	"localField": true, "on": true, "path": true, "preserveNullAndEmptyArrays": true}
+
+// stages whose values are kept as they are
+var literalStages = map[string]bool{"$collStats": true, "$count": true, "$indexStats": true, "$out": true,
+	"$unset": true, "$unwind": true}
+
+// keyValue is a key and a value of a document
+type keyValue struct {
+	key   string
+	value interface{}
+}
+
+// GetQueryShape returns the shape of a filter, sort, and projection of a query
+func GetQueryShape(filter interface{}, sort interface{}, projection interface{}) string {
+	shape := NormalizeFilter(filter)
+	if s := NormalizeSort(sort); s != "{}" {
+		shape += ", sort: " + s
+	}
+	if p := NormalizeProjection(projection); p != "{}" {
+		shape += ", projection: " + p
+	}
+	return shape
+}
+
+// GetShapeCommand returns the command name a query shape is hashed by, a getMore
+// is of its originating command, remove is delete, and findAndModify is find
+func GetShapeCommand(command string, originatingCommand string) string {
+	if strings.EqualFold(command, cmdGetMore) && originatingCommand != "" {
+		command = originatingCommand
+	}
+	switch strings.ToLower(command) {
+	case cmdFindAndModify, "query":
+		return cmdFind
+	case cmdRemove:
+		return cmdDelete
+	}
+	return command
+}
+
+// GetQueryShapeHash returns the hash of a query shape of a command on a namespace
+func GetQueryShapeHash(command string, ns string, shape string) string {
+	sum := sha256.Sum256([]byte(command + "\x00" + ns + "\x00" + shape))
+	return strings.ToUpper(hex.EncodeToString(sum[:8]))
+}
+
+// NormalizeFilter returns the shape of a query filter
+func NormalizeFilter(filter interface{}) string {
+	var buf strings.Builder
+	writeFilter(&buf, filter)
+	return buf.String()
+}
+
+// NormalizeSort returns the shape of a sort spec, order and directions are kept
+func NormalizeSort(spec interface{}) string {
+	var buf strings.Builder
+	buf.WriteString("{")
+	for i, kv := range getKeyValues(spec, false) {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		writeKey(&buf, kv.key)
+		if n, ok := toNumber(kv.value); ok {
+			buf.WriteString(formatNumber(n))
+		} else if isDocument(kv.value) { // e.g. {$meta: "textScore"}
+			writeJSON(&buf, kv.value)
+		} else {
+			writeLiteral(&buf, kv.value)
+		}
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// NormalizeProjection returns the shape of a projection, inclusions and
+// exclusions are kept and expressions are normalized
+func NormalizeProjection(projection interface{}) string {
+	var buf strings.Builder
+	buf.WriteString("{")
+	for i, kv := range getKeyValues(projection, true) {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		writeKey(&buf, kv.key)
+		if b, ok := kv.value.(bool); ok {
+			buf.WriteString(fmt.Sprintf("%v", b))
+		} else if n, ok := toNumber(kv.value); ok && (n == 0 || n == 1) {
+			buf.WriteString(formatNumber(n))
+		} else {
+			writeExpression(&buf, kv.value)
+		}
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// NormalizePipeline returns the shape of an aggregation pipeline
+func NormalizePipeline(pipeline interface{}) string {
+	var buf strings.Builder
+	writePipeline(&buf, pipeline)
+	return buf.String()
+}
+
+// GetDocField returns value of a field from a bson.D, bson.M or a map
+func GetDocField(doc interface{}, key string) interface{} {
+	switch d := doc.(type) {
+	case bson.D:
+		for _, e := range d {
+			if e.Key == key {
+				return e.Value
+			}
+		}
+	case bson.M:
+		return d[key]
+	case map[string]interface{}:
+		return d[key]
+	}
+	return nil
+}
+
+func writeFilter(buf *strings.Builder, filter interface{}) {
+	buf.WriteString("{")
+	for i, kv := range getKeyValues(filter, true) {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		writeKey(buf, kv.key)
+		switch kv.key {
+		case "$and", "$nor", "$or":
+			branches := []string{}
+			for _, branch := range getArray(kv.value) {
+				branches = append(branches, NormalizeFilter(branch))
+			}
+			sort.Strings(branches)
+			buf.WriteString("[")
+			for j, branch := range branches {
+				if j > 0 && branch == branches[j-1] {
+					continue
+				} else if j > 0 {
+					buf.WriteString(",")
+				}
+				buf.WriteString(branch)
+			}
+			buf.WriteString("]")
+		case "$expr":
+			writeExpression(buf, kv.value)
+		default:
+			writePredicate(buf, kv.value)
+		}
+	}
+	buf.WriteString("}")
+}
+
+// writePredicate writes the shape of a value of a field in a filter
+func writePredicate(buf *strings.Builder, value interface{}) {
+	if isArray(value) {
+		buf.WriteString("[...]")
+		return
+	} else if !isDocument(value) || isLiteralDocument(value) {
+		writeLiteral(buf, value)
+		return
+	}
+	kvs := getKeyValues(value, true)
+	if len(kvs) == 0 || !strings.HasPrefix(kvs[0].key, "$") { // equality of an embedded document
+		writeFilter(buf, value)
+		return
+	}
+	var options string
+	for _, kv := range kvs {
+		if kv.key == "$options" {
+			options, _ = kv.value.(string)
+		}
+	}
+	buf.WriteString("{")
+	n := 0
+	for _, kv := range kvs {
+		if kv.key == "$options" {
+			continue
+		}
+		if n > 0 {
+			buf.WriteString(",")
+		}
+		n++
+		writeKey(buf, kv.key)
+		switch kv.key {
+		case "$all", "$in", "$nin":
+			buf.WriteString("[...]")
+		case "$elemMatch":
+			if ekvs := getKeyValues(kv.value, true); len(ekvs) > 0 && strings.HasPrefix(ekvs[0].key, "$") &&
+				ekvs[0].key != "$and" && ekvs[0].key != "$or" && ekvs[0].key != "$nor" && ekvs[0].key != "$expr" {
+				writePredicate(buf, kv.value)
+			} else {
+				writeFilter(buf, kv.value)
+			}
+		case "$not":
+			writePredicate(buf, kv.value)
+		case "$regex":
+			if s, ok := kv.value.(string); ok {
+				buf.WriteString(getRegexShape(s, options))
+			} else {
+				writeLiteral(buf, kv.value)
+			}
+		default:
+			if isDocument(kv.value) && !isLiteralDocument(kv.value) {
+				writeFilter(buf, kv.value)
+			} else if isArray(kv.value) {
+				buf.WriteString("[...]")
+			} else {
+				writeLiteral(buf, kv.value)
+			}
+		}
+	}
+	buf.WriteString("}")
+}
+
+// writeExpression writes the shape of an aggregation expression, field paths
+// and operators are kept and literals are replaced
+func writeExpression(buf *strings.Builder, value interface{}) {
+	if isArray(value) {
+		buf.WriteString("[")
+		for i, v := range getArray(value) {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeExpression(buf, v)
+		}
+		buf.WriteString("]")
+		return
+	} else if s, ok := value.(string); ok && strings.HasPrefix(s, "$") {
+		writeJSON(buf, s)
+		return
+	} else if !isDocument(value) || isLiteralDocument(value) {
+		writeLiteral(buf, value)
+		return
+	}
+	buf.WriteString("{")
+	for i, kv := range getKeyValues(value, true) {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		writeKey(buf, kv.key)
+		if s, ok := kv.value.(string); ok && structuralFields[kv.key] {
+			writeJSON(buf, s)
+		} else {
+			writeExpression(buf, kv.value)
+		}
+	}
+	buf.WriteString("}")
+}
+
+func writePipeline(buf *strings.Builder, pipeline interface{}) {
+	buf.WriteString("[")
+	for i, stage := range getArray(pipeline) {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("{")
+		for j, kv := range getKeyValues(stage, false) {
+			if j > 0 {
+				buf.WriteString(",")
+			}
+			writeKey(buf, kv.key)
+			writeStage(buf, kv.key, kv.value)
+		}
+		buf.WriteString("}")
+	}
+	buf.WriteString("]")
+}
+
+// writeStage writes the shape of an aggregation stage
+func writeStage(buf *strings.Builder, name string, value interface{}) {
+	switch name {
+	case "$match":
+		writeFilter(buf, value)
+	case "$sort":
+		buf.WriteString(NormalizeSort(value))
+	case "$project":
+		buf.WriteString(NormalizeProjection(value))
+	case "$limit", "$skip":
+		buf.WriteString("1")
+	case "$facet":
+		buf.WriteString("{")
+		for i, kv := range getKeyValues(value, true) {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeKey(buf, kv.key)
+			writePipeline(buf, kv.value)
+		}
+		buf.WriteString("}")
+	case "$geoNear", "$graphLookup", "$lookup", "$merge", "$unionWith":
+		if s, ok := value.(string); ok { // {$unionWith: "coll"}
+			writeJSON(buf, s)
+			return
+		}
+		buf.WriteString("{")
+		for i, kv := range getKeyValues(value, true) {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeKey(buf, kv.key)
+			if kv.key == "pipeline" {
+				writePipeline(buf, kv.value)
+			} else if kv.key == "query" || kv.key == "restrictSearchWithMatch" {
+				writeFilter(buf, kv.value)
+			} else if s, ok := kv.value.(string); ok && structuralFields[kv.key] {
+				writeJSON(buf, s)
+			} else if isArray(kv.value) && structuralFields[kv.key] {
+				writeJSON(buf, getArray(kv.value))
+			} else {
+				writeExpression(buf, kv.value)
+			}
+		}
+		buf.WriteString("}")
+	default:
+		if literalStages[name] {
+			writeJSON(buf, value)
+		} else {
+			writeExpression(buf, value)
+		}
+	}
+}
+
+// writeLiteral writes a literal value, regular expressions are shown as /^.../
+func writeLiteral(buf *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case primitive.Regex:
+		buf.WriteString(getRegexShape(v.Pattern, v.Options))
+		return
+	}
+	if isDocument(value) {
+		kvs := getKeyValues(value, false)
+		if len(kvs) == 1 && kvs[0].key == "$regularExpression" {
+			pattern, _ := GetDocField(kvs[0].value, "pattern").(string)
+			options, _ := GetDocField(kvs[0].value, "options").(string)
+			buf.WriteString(getRegexShape(pattern, options))
+			return
+		}
+	}
+	buf.WriteString("1")
+}
+
+// getRegexShape returns /^.../options if a regex is anchored, otherwise /.../options
+func getRegexShape(pattern string, options string) string {
+	if strings.HasPrefix(pattern, "^") {
+		return "/^.../" + options
+	}
+	return "/.../" + options
+}
+
+func writeKey(buf *strings.Builder, key string) {
+	writeJSON(buf, key)
+	buf.WriteString(":")
+}
+
+func writeJSON(buf *strings.Builder, value interface{}) {
+	data, err := json.Marshal(toJSONValue(value))
+	if err != nil {
+		buf.WriteString("1")
+		return
+	}
+	buf.WriteString(string(data))
+}
+
+// toJSONValue converts bson documents to json marshalable values
+func toJSONValue(value interface{}) interface{} {
+	if isArray(value) {
+		arr := []interface{}{}
+		for _, v := range getArray(value) {
+			arr = append(arr, toJSONValue(v))
+		}
+		return arr
+	} else if isDocument(value) {
+		doc := map[string]interface{}{}
+		for _, kv := range getKeyValues(value, false) {
+			doc[kv.key] = toJSONValue(kv.value)
+		}
+		return doc
+	}
+	return value
+}
+
+// getKeyValues returns keys and values of a document, keys of maps are always
+// sorted and keys of bson.D are sorted if sorted is true
+func getKeyValues(doc interface{}, sorted bool) []keyValue {
+	kvs := []keyValue{}
+	switch d := doc.(type) {
+	case bson.D:
+		for _, e := range d {
+			kvs = append(kvs, keyValue{e.Key, e.Value})
+		}
+	case bson.M:
+		for k, v := range d {
+			kvs = append(kvs, keyValue{k, v})
+		}
+		sorted = true
+	case map[string]interface{}:
+		for k, v := range d {
+			kvs = append(kvs, keyValue{k, v})
+		}
+		sorted = true
+	}
+	if sorted {
+		sort.SliceStable(kvs, func(i, j int) bool { return kvs[i].key < kvs[j].key })
+	}
+	return kvs
+}
+
+func getArray(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case bson.A:
+		return v
+	case []bson.D:
+		arr := []interface{}{}
+		for _, doc := range v {
+			arr = append(arr, doc)
+		}
+		return arr
+	}
+	return nil
+}
+
+func isArray(value interface{}) bool {
+	switch value.(type) {
+	case []interface{}, bson.A, []bson.D:
+		return true
+	}
+	return false
+}
+
+func isDocument(value interface{}) bool {
+	switch value.(type) {
+	case bson.D, bson.M, map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+// isLiteralDocument returns true if a document is an extended JSON literal, e.g. {"$oid": "..."}
+func isLiteralDocument(value interface{}) bool {
+	kvs := getKeyValues(value, false)
+	return len(kvs) > 0 && extJSONTypes[kvs[0].key]
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) {
+		return fmt.Sprintf("%d", int64(n))
+	}
+	return fmt.Sprintf("%v", n)
+}
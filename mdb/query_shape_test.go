@@ -0,0 +1,130 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func getQueryShapeDoc(t *testing.T, str string) map[string]interface{} {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(str), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestNormalizeFilter(t *testing.T) {
+	tests := map[string]string{
+		`{"b":1,"a":{"$in":[1,2,3]}}`:                                       `{"a":{"$in":[...]},"b":1}`,
+		`{"a":{"$in":[1]},"b":"x"}`:                                         `{"a":{"$in":[...]},"b":1}`,
+		`{"$or":[{"b":2},{"a":{"$gt":1}},{"b":3}]}`:                         `{"$or":[{"a":{"$gt":1}},{"b":1}]}`,
+		`{"user":{"$oid":"59154269cfe1f2d40943d7f5"},"os":"iOS"}`:           `{"os":1,"user":1}`,
+		`{"a":{"$elemMatch":{"k":"x","v":{"$gte":1}}}}`:                     `{"a":{"$elemMatch":{"k":1,"v":{"$gte":1}}}}`,
+		`{"a":{"$elemMatch":{"$gt":1,"$lt":9}}}`:                            `{"a":{"$elemMatch":{"$gt":1,"$lt":1}}}`,
+		`{"name":{"$regex":"^abc","$options":"i"}}`:                         `{"name":{"$regex":/^.../i}}`,
+		`{"name":{"$regularExpression":{"pattern":"abc","options":""}}}`:    `{"name":/.../}`,
+		`{"a":{"x":1,"y":"z"},"b":[1,2]}`:                                   `{"a":{"x":1,"y":1},"b":[...]}`,
+		`{"$expr":{"$gt":["$qty","$limit"]}}`:                               `{"$expr":{"$gt":["$qty","$limit"]}}`,
+		`{}`:                                                                `{}`,
+		`{"$and":[{"a":1},{"$or":[{"c":1},{"b":{"$exists":true}}]}]}`:       `{"$and":[{"$or":[{"b":{"$exists":1}},{"c":1}]},{"a":1}]}`,
+		`{"$and":[{"$or":[{"b":{"$exists":true}},{"c":9}]},{"a":"hello"}]}`: `{"$and":[{"$or":[{"b":{"$exists":1}},{"c":1}]},{"a":1}]}`,
+	}
+	for filter, expected := range tests {
+		if shape := NormalizeFilter(getQueryShapeDoc(t, filter)); shape != expected {
+			t.Fatalf("%v: expected %v but got %v", filter, expected, shape)
+		}
+	}
+}
+
+func TestNormalizeFilterBSON(t *testing.T) {
+	var doc bson.D
+	str := `{"user":{"$oid":"59154269cfe1f2d40943d7f5"},"ts":{"$date":"2021-03-01T10:00:00Z"},"name":{"$regex":"^abc","$options":"i"},"a":{"$in":[1,2]}}`
+	if err := bson.UnmarshalExtJSON([]byte(str), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	expected := NormalizeFilter(getQueryShapeDoc(t, str))
+	if shape := NormalizeFilter(doc); shape != expected {
+		t.Fatalf("expected %v but got %v", expected, shape)
+	}
+}
+
+func TestNormalizeSort(t *testing.T) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(`{"updated":-1,"_id":1,"score":{"$meta":"textScore"}}`), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if shape := NormalizeSort(doc); shape != `{"updated":-1,"_id":1,"score":{"$meta":"textScore"}}` {
+		t.Fatal(shape)
+	}
+	if shape := GetQueryShape(bson.D{{Key: "a", Value: 1}}, doc[:2], bson.D{{Key: "b", Value: 1}, {Key: "_id", Value: 0}}); shape != `{"a":1}, sort: {"updated":-1,"_id":1}, projection: {"_id":0,"b":1}` {
+		t.Fatal(shape)
+	}
+}
+
+func TestNormalizePipeline(t *testing.T) {
+	str := `{"pipeline":[{"$match":{"status":"A","qty":{"$in":[1,2]}}},
+		{"$lookup":{"from":"inventory","localField":"item","foreignField":"sku","as":"docs","pipeline":[{"$match":{"warehouse":"X"}},{"$limit":5}]}},
+		{"$unwind":"$docs"},{"$group":{"_id":"$item","total":{"$sum":"$qty"},"n":{"$sum":1}}},
+		{"$facet":{"count":[{"$count":"n"}],"top":[{"$sort":{"total":-1}},{"$limit":10}]}},
+		{"$unionWith":{"coll":"archive","pipeline":[{"$match":{"year":2020}}]}},{"$skip":20}]}`
+	expected := `[{"$match":{"qty":{"$in":[...]},"status":1}},` +
+		`{"$lookup":{"as":"docs","foreignField":"sku","from":"inventory","localField":"item","pipeline":[{"$match":{"warehouse":1}},{"$limit":1}]}},` +
+		`{"$unwind":"$docs"},{"$group":{"_id":"$item","n":{"$sum":1},"total":{"$sum":"$qty"}}},` +
+		`{"$facet":{"count":[{"$count":"n"}],"top":[{"$sort":{"total":-1}},{"$limit":1}]}},` +
+		`{"$unionWith":{"coll":"archive","pipeline":[{"$match":{"year":1}}]}},{"$skip":1}]`
+	doc := getQueryShapeDoc(t, str)
+	if shape := NormalizePipeline(doc["pipeline"]); shape != expected {
+		t.Fatalf("expected\n%v\nbut got\n%v", expected, shape)
+	}
+}
+
+func TestQueryShapeHash(t *testing.T) {
+	str := `{"t":{"$date":"2021-03-01T10:00:01.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"find":"cars","filter":{"color":"red","year":{"$in":[2019,2020]}},"sort":{"year":-1,"brand":1}},"planSummary":"COLLSCAN","durationMillis":200}}`
+	loginfo := NewLogInfo("utest-xxxxxx")
+	stat, err := loginfo.ParseLogv2(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.filter != `{"color":1,"year":{"$in":[...]}}, sort: {"year":-1,"brand":1}` {
+		t.Fatal(stat.filter)
+	}
+	qe := NewQueryExplainer(nil)
+	doc := `{"ns":"keyhole.cars","filter":{"year":{"$in":[2018]},"color":"blue"},"sort":{"year":-1,"brand":1}}`
+	if err = qe.ReadQueryShape([]byte(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if qe.QueryShape != stat.filter {
+		t.Fatalf("expected %v but got %v", stat.filter, qe.QueryShape)
+	}
+	if qe.QueryHash != GetQueryShapeHash(stat.op, stat.ns, stat.filter) {
+		t.Fatal("query hashes differ")
+	}
+	if GetQueryShapeHash("find", "keyhole.dealers", stat.filter) == qe.QueryHash {
+		t.Fatal("expected different hashes of different namespaces")
+	}
+}
+
+func TestGetShapeCommand(t *testing.T) {
+	for _, cmd := range [][]string{{"remove", "", "delete"}, {"findandmodify", "", "find"}, {"findAndModify", "", "find"},
+		{"getMore", "aggregate", "aggregate"}, {"getMore", "", "getMore"}, {"count", "", "count"}} {
+		if command := GetShapeCommand(cmd[0], cmd[1]); command != cmd[2] {
+			t.Fatalf("expected %v of %v but got %v", cmd[2], cmd[0], command)
+		}
+	}
+	str := `{"t":{"$date":"2021-03-01T10:00:02.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn7","msg":"Slow query","attr":{"type":"command","ns":"keyhole.cars","command":{"getMore":1234,"collection":"cars","$db":"keyhole"},"originatingCommand":{"find":"cars","filter":{"brand":"BMW"},"$db":"keyhole"},"planSummary":"COLLSCAN","durationMillis":120}}`
+	li := NewLogInfo("utest-xxxxxx")
+	li.SetSilent(true)
+	if err := li.Parse(bufio.NewReader(strings.NewReader(str))); err != nil {
+		t.Fatal(err)
+	}
+	if len(li.OpPatterns) != 1 || li.OpPatterns[0].Command != cmdGetMore ||
+		li.OpPatterns[0].Hash != GetQueryShapeHash(cmdFind, "keyhole.cars", `{"brand":1}`) {
+		t.Fatal("expected getMore hashed as its originating find", li.OpPatterns)
+	}
+}
@@ -40,7 +40,11 @@ type OplogStats struct {
 
 // GetReplSetGetStatus returns MongoDB build information
 func GetReplSetGetStatus(client *mongo.Client) (ReplSetGetStatus, error) {
-	ctx := context.Background()
+	return GetReplSetGetStatusWithContext(context.Background(), client)
+}
+
+// GetReplSetGetStatusWithContext returns replica set status within a context
+func GetReplSetGetStatusWithContext(ctx context.Context, client *mongo.Client) (ReplSetGetStatus, error) {
 	var replSetGetStatus ReplSetGetStatus
 	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}).Decode(&replSetGetStatus)
 	return replSetGetStatus, err
@@ -48,12 +52,19 @@ func GetReplSetGetStatus(client *mongo.Client) (ReplSetGetStatus, error) {
 
 // GetOplogStats returns oplog stats
 func GetOplogStats(client *mongo.Client) (OplogStats, error) {
+	return GetOplogStatsWithContext(context.Background(), client)
+}
+
+// GetOplogStatsWithContext returns oplog stats within a context
+func GetOplogStatsWithContext(ctx context.Context, client *mongo.Client) (OplogStats, error) {
 	var err error
 	var cur *mongo.Cursor
-	ctx := context.Background()
 	oplog := OplogStats{}
 	db := client.Database("local")
-	db.RunCommand(ctx, bson.D{{Key: "collStats", Value: "oplog.rs"}}).Decode(&oplog)
+	if stats, err := GetCollStats(ctx, client, "local", "oplog.rs"); err == nil {
+		data, _ := bson.Marshal(stats)
+		bson.Unmarshal(data, &oplog)
+	}
 
 	c := db.Collection("oplog.rs")
 	opts := options.Find()
@@ -66,7 +66,11 @@ type ServerStatus struct {
 
 // GetServerStatus returns MongoDB build information
 func GetServerStatus(client *mongo.Client) (ServerStatus, error) {
-	ctx := context.Background()
+	return GetServerStatusWithContext(context.Background(), client)
+}
+
+// GetServerStatusWithContext returns server status within a context
+func GetServerStatusWithContext(ctx context.Context, client *mongo.Client) (ServerStatus, error) {
 	var serverStatus ServerStatus
 	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).Decode(&serverStatus)
 	return serverStatus, err
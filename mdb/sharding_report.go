@@ -0,0 +1,559 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	shardingExt       = "-sharding"
+	hotShardRatio     = 1.5  // ops of a shard over its fair share
+	lowSelectivity    = 0.01 // distinct values of the shard key prefix in samples
+	monotonicRatio    = 0.95 // ordered pairs of shard key values by _id
+	monotonicSamples  = 100
+	skewedDataPct     = 50
+	balancedChunksPct = 20
+)
+
+// ShardingReport stores balance and chunk distribution analysis of a sharded cluster
+type ShardingReport struct {
+	Balancer    BalancerStatus      `bson:"balancer" json:"balancer"`
+	Collections []ShardedCollection `bson:"collections" json:"collections"`
+	HotShards   []string            `bson:"hotShards" json:"hotShards"`
+	Host        string              `bson:"host" json:"host"`
+	Reshard     []string            `bson:"reshard" json:"reshard"` // namespaces recommended to reshard
+	Shards      []ShardLoad         `bson:"shards" json:"shards"`
+	Time        time.Time           `bson:"time" json:"time"`
+
+	Logger  *gox.Logger `bson:"-" json:"-"`
+	verbose bool
+	version string
+}
+
+// BalancerStatus stores balancer settings and activity of config.actionlog and config.changelog
+type BalancerStatus struct {
+	ActiveWindow    bson.M         `bson:"activeWindow,omitempty" json:"activeWindow,omitempty"`
+	ChunksMoved     int64          `bson:"chunksMoved" json:"chunksMoved"` // of balancer rounds
+	InRound         bool           `bson:"inBalancerRound" json:"inBalancerRound"`
+	LastRound       time.Time      `bson:"lastRound" json:"lastRound"`
+	Migrations      int64          `bson:"migrations" json:"migrations"` // of changelog
+	MigrationErrors int64          `bson:"migrationErrors" json:"migrationErrors"`
+	MigrationsByNS  map[string]int `bson:"migrationsByNS" json:"migrationsByNS"`
+	Mode            string         `bson:"mode" json:"mode"`
+	RoundErrors     int64          `bson:"roundErrors" json:"roundErrors"`
+	Rounds          int64          `bson:"rounds" json:"rounds"`
+	Since           time.Time      `bson:"since" json:"since"` // the oldest of logs
+}
+
+// ShardedCollection stores distribution and shard key analysis of a sharded collection
+type ShardedCollection struct {
+	Cardinality  []CardinalityCount `bson:"cardinality" json:"cardinality"`
+	ChunkSkewPct float64            `bson:"chunkSkewPct" json:"chunkSkewPct"`
+	DataSkewPct  float64            `bson:"dataSkewPct" json:"dataSkewPct"`
+	Distribution []ShardLoad        `bson:"distribution" json:"distribution"`
+	HotShard     string             `bson:"hotShard,omitempty" json:"hotShard,omitempty"`
+	Jumbo        int64              `bson:"jumbo" json:"jumbo"`
+	Key          bson.D             `bson:"key" json:"key"`
+	KeyString    string             `bson:"keyString" json:"keyString"`
+	Monotonic    string             `bson:"monotonic,omitempty" json:"monotonic,omitempty"` // reason if monotonic
+	NS           string             `bson:"ns" json:"ns"`
+	Reasons      []string           `bson:"reasons" json:"reasons"` // reasons to reshard
+	SampledCount int64              `bson:"sampledCount" json:"sampledCount"`
+	Selectivity  float64            `bson:"selectivity" json:"selectivity"` // of the shard key prefix
+	Unique       bool               `bson:"unique" json:"unique"`
+}
+
+// configCollection stores a document of config.collections
+type configCollection struct {
+	ID        string      `bson:"_id"`
+	Key       bson.D      `bson:"key"`
+	Timestamp interface{} `bson:"timestamp"` // since 5.0, chunks reference collections by uuid
+	Unique    bool        `bson:"unique"`
+	UUID      interface{} `bson:"uuid"`
+}
+
+// ShardLoad stores data, chunks, and ops of a shard
+type ShardLoad struct {
+	Chunks   int64   `bson:"chunks" json:"chunks"`
+	DataPct  float64 `bson:"dataPct" json:"dataPct"`
+	DataSize int64   `bson:"dataSize" json:"dataSize"`
+	Jumbo    int64   `bson:"jumbo" json:"jumbo"`
+	Ops      int64   `bson:"ops" json:"ops"` // reads and writes of latencyStats
+	OpsPct   float64 `bson:"opsPct" json:"opsPct"`
+	Shard    string  `bson:"shard" json:"shard"`
+}
+
+// NewShardingReport returns ShardingReport
+func NewShardingReport(version string) *ShardingReport {
+	return &ShardingReport{Logger: gox.GetLogger(version), version: version}
+}
+
+// SetVerbose sets verbosity
+func (r *ShardingReport) SetVerbose(verbose bool) {
+	r.verbose = verbose
+}
+
+// Analyze collects and analyzes balancer activity and distribution of sharded collections
+func (r *ShardingReport) Analyze(client *mongo.Client) error {
+	var err error
+	var shards []Shard
+	var status ServerStatus
+	ctx := context.Background()
+	if status, err = GetServerStatus(client); err != nil {
+		return err
+	}
+	if GetClusterType(status) != Sharded || status.Process != "mongos" {
+		return errors.New("a mongos connection of a sharded cluster is required")
+	}
+	r.Host, r.Time = status.Host, status.LocalTime
+	if shards, err = GetShards(client); err != nil {
+		return err
+	}
+	shardIDs := []string{}
+	for _, shard := range shards {
+		shardIDs = append(shardIDs, shard.ID)
+	}
+	sort.Strings(shardIDs)
+	if r.Balancer, err = getBalancerStatus(ctx, client); err != nil {
+		r.Logger.Warnf(`balancer status: %v`, err)
+	}
+
+	var cur *mongo.Cursor
+	filter := bson.D{{Key: "dropped", Value: bson.M{"$ne": true}}, {Key: "_id", Value: bson.M{"$not": primitive.Regex{Pattern: "^config\\."}}}}
+	if cur, err = client.Database("config").Collection("collections").Find(ctx, filter); err != nil {
+		return err
+	}
+	var docs []configCollection
+	if err = cur.All(ctx, &docs); err != nil {
+		return err
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+	r.Collections = []ShardedCollection{}
+	for _, doc := range docs {
+		ns := doc.ID
+		r.Logger.Infof(`analyzing %v`, ns)
+		coll := ShardedCollection{Key: doc.Key, KeyString: getIndexKeyString(doc.Key), NS: ns, Unique: doc.Unique}
+		if coll.Distribution, err = getChunkDistribution(ctx, client, doc, shardIDs); err != nil {
+			r.Logger.Warnf(`chunks of %v: %v`, ns, err)
+		}
+		dbName, collName := SplitNamespace(ns)
+		if stats, err := GetCollStats(ctx, client, dbName, collName); err != nil {
+			r.Logger.Warnf(`$collStats of %v: %v`, ns, err)
+		} else {
+			var s struct {
+				ShardStats []ShardCollStats `bson:"shardStats"`
+			}
+			data, _ := bson.Marshal(stats)
+			bson.Unmarshal(data, &s)
+			for i, load := range coll.Distribution {
+				for _, ss := range s.ShardStats {
+					if ss.Shard == load.Shard {
+						coll.Distribution[i].DataSize = ss.Size
+						coll.Distribution[i].Ops = ss.LatencyStats.Reads.Ops + ss.LatencyStats.Writes.Ops
+					}
+				}
+			}
+		}
+		if len(coll.Key) > 0 {
+			card := NewCardinality(client)
+			card.SetVerbose(r.verbose)
+			if summary, err := card.GetCardinalityArray(dbName, collName, []string{coll.Key[0].Key}); err != nil {
+				r.Logger.Warnf(`cardinality of %v: %v`, ns, err)
+			} else {
+				coll.Cardinality, coll.SampledCount = summary.List, summary.SampledCount
+			}
+			if !isHashedKey(coll.Key) {
+				ids, values := sampleShardKeyValues(ctx, client.Database(dbName).Collection(collName), coll.Key[0].Key)
+				coll.Monotonic = getMonotonicReason(coll.Key[0].Key, ids, values)
+			}
+		}
+		analyzeShardedCollection(&coll)
+		r.Collections = append(r.Collections, coll)
+	}
+	r.summarize(shardIDs)
+	return nil
+}
+
+// getBalancerStatus returns balancer settings and activity
+func getBalancerStatus(ctx context.Context, client *mongo.Client) (BalancerStatus, error) {
+	var err error
+	var cur *mongo.Cursor
+	status := BalancerStatus{MigrationsByNS: map[string]int{}}
+	var doc bson.M
+	if err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "balancerStatus", Value: 1}}).Decode(&doc); err == nil {
+		status.Mode, status.InRound = fmt.Sprint(doc["mode"]), doc["inBalancerRound"] == true
+	}
+	var settings bson.M
+	if client.Database("config").Collection("settings").FindOne(ctx, bson.D{{Key: "_id", Value: "balancer"}}).Decode(&settings) == nil {
+		status.ActiveWindow, _ = settings["activeWindow"].(bson.M)
+		if settings["stopped"] == true && status.Mode == "" {
+			status.Mode = "off"
+		}
+	}
+	if cur, err = client.Database("config").Collection("actionlog").Find(ctx, bson.D{{Key: "what", Value: "balancer.round"}}); err != nil {
+		return status, err
+	}
+	var rounds []bson.M
+	if err = cur.All(ctx, &rounds); err != nil {
+		return status, err
+	}
+	var changes []bson.M
+	filter := bson.D{{Key: "what", Value: bson.M{"$in": bson.A{"moveChunk.commit", "moveChunk.error"}}}}
+	opts := options.Find().SetProjection(bson.D{{Key: "what", Value: 1}, {Key: "ns", Value: 1}, {Key: "time", Value: 1}})
+	if cur, err = client.Database("config").Collection("changelog").Find(ctx, filter, opts); err != nil {
+		return status, err
+	}
+	if err = cur.All(ctx, &changes); err != nil {
+		return status, err
+	}
+	summarizeBalancerLogs(&status, rounds, changes)
+	return status, nil
+}
+
+// summarizeBalancerLogs counts balancer rounds of actionlog and migrations of changelog
+func summarizeBalancerLogs(status *BalancerStatus, rounds []bson.M, changes []bson.M) {
+	since := func(doc bson.M) {
+		if t, ok := doc["time"].(primitive.DateTime); ok {
+			if tm := t.Time().UTC(); status.Since.IsZero() || tm.Before(status.Since) {
+				status.Since = tm
+			}
+		}
+	}
+	for _, doc := range rounds {
+		since(doc)
+		status.Rounds++
+		if t, ok := doc["time"].(primitive.DateTime); ok && t.Time().After(status.LastRound) {
+			status.LastRound = t.Time().UTC()
+		}
+		details, _ := doc["details"].(bson.M)
+		if details["errorOccured"] == true || details["errorOccurred"] == true {
+			status.RoundErrors++
+		}
+		status.ChunksMoved += toInt64(details["chunksMoved"])
+	}
+	for _, doc := range changes {
+		since(doc)
+		if doc["what"] == "moveChunk.error" {
+			status.MigrationErrors++
+			continue
+		}
+		status.Migrations++
+		status.MigrationsByNS[fmt.Sprint(doc["ns"])]++
+	}
+}
+
+// getChunkDistribution returns numbers of chunks and jumbo chunks of all shards
+func getChunkDistribution(ctx context.Context, client *mongo.Client, doc configCollection, shardIDs []string) ([]ShardLoad, error) {
+	var err error
+	var cur *mongo.Cursor
+	match := bson.D{{Key: "ns", Value: doc.ID}}
+	if doc.UUID != nil && doc.Timestamp != nil {
+		match = bson.D{{Key: "uuid", Value: doc.UUID}}
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$shard"}, {Key: "chunks", Value: bson.M{"$sum": 1}},
+			{Key: "jumbo", Value: bson.M{"$sum": bson.M{"$cond": bson.A{"$jumbo", 1, 0}}}}}}}}
+	loads := []ShardLoad{}
+	for _, id := range shardIDs {
+		loads = append(loads, ShardLoad{Shard: id})
+	}
+	if cur, err = client.Database("config").Collection("chunks").Aggregate(ctx, pipeline); err != nil {
+		return loads, err
+	}
+	var counts []bson.M
+	if err = cur.All(ctx, &counts); err != nil {
+		return loads, err
+	}
+	for _, count := range counts {
+		for i := range loads {
+			if loads[i].Shard == count["_id"] {
+				loads[i].Chunks, loads[i].Jumbo = toInt64(count["chunks"]), toInt64(count["jumbo"])
+			}
+		}
+	}
+	return loads, nil
+}
+
+// sampleShardKeyValues returns _id and values of a field of the latest documents by _id
+func sampleShardKeyValues(ctx context.Context, collection *mongo.Collection, field string) ([]interface{}, []interface{}) {
+	ids, values := []interface{}{}, []interface{}{}
+	projection := bson.D{{Key: "_id", Value: 1}}
+	if field != "_id" {
+		projection = append(projection, bson.E{Key: field, Value: 1})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(monotonicSamples).SetProjection(projection)
+	cur, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return ids, values
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var doc bson.D
+		if cur.Decode(&doc) != nil {
+			continue
+		}
+		var v interface{} = doc
+		for _, key := range strings.Split(field, ".") {
+			if d, ok := v.(bson.D); ok {
+				v = d.Map()[key]
+			} else {
+				v = nil
+			}
+		}
+		ids, values = append(ids, doc.Map()["_id"]), append(values, v)
+	}
+	return ids, values
+}
+
+// getMonotonicReason returns why values of a shard key prefix are monotonic, values are of
+// documents in descending order of _id.  Only an _id of ObjectId is monotonic by its type, values
+// of other fields, e.g. an ObjectId of a foreign key or a birth date, must increase with _id.
+func getMonotonicReason(field string, ids []interface{}, values []interface{}) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if _, ok := values[0].(primitive.ObjectID); ok && field == "_id" {
+		return "ObjectId increases with time"
+	}
+	if len(values) < 2 {
+		return ""
+	}
+	if _, ok := ids[0].(primitive.ObjectID); !ok { // _id is not in insertion order
+		return ""
+	}
+	ordered, distinct := 0, 0
+	for i := 1; i < len(values); i++ {
+		a, b := getOrderedValue(values[i-1]), getOrderedValue(values[i])
+		if a >= b {
+			ordered++
+		}
+		if a != b {
+			distinct++
+		}
+	}
+	if distinct > 0 && float64(ordered)/float64(len(values)-1) >= monotonicRatio {
+		switch values[0].(type) {
+		case primitive.ObjectID:
+			return "ObjectId increases with insertion order"
+		case primitive.DateTime:
+			return "date increases with insertion order"
+		case primitive.Timestamp:
+			return "timestamp increases with insertion order"
+		}
+		return "values increase with insertion order"
+	}
+	return ""
+}
+
+// getOrderedValue returns a number of a value to compare, seconds of an ObjectId, milliseconds of
+// a date, or the number
+func getOrderedValue(value interface{}) float64 {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return float64(v.Timestamp().Unix())
+	case primitive.DateTime:
+		return float64(v)
+	case primitive.Timestamp:
+		return float64(v.T) + float64(v.I)/(1<<32)
+	}
+	return toFloat64(value)
+}
+
+// isHashedKey returns true if the prefix of a shard key is hashed
+func isHashedKey(key bson.D) bool {
+	return len(key) > 0 && fmt.Sprint(key[0].Value) == "hashed"
+}
+
+// analyzeShardedCollection computes skews and hot shard and recommends resharding
+func analyzeShardedCollection(coll *ShardedCollection) {
+	chunks, sizes, ops := []float64{}, []float64{}, []float64{}
+	var totalData, totalOps int64
+	for _, load := range coll.Distribution {
+		chunks = append(chunks, float64(load.Chunks))
+		sizes = append(sizes, float64(load.DataSize))
+		ops = append(ops, float64(load.Ops))
+		totalData += load.DataSize
+		totalOps += load.Ops
+		coll.Jumbo += load.Jumbo
+	}
+	coll.ChunkSkewPct, coll.DataSkewPct = getSkewPct(chunks), getSkewPct(sizes)
+	for i, load := range coll.Distribution {
+		if totalData > 0 {
+			coll.Distribution[i].DataPct = 100 * float64(load.DataSize) / float64(totalData)
+		}
+		if totalOps > 0 {
+			coll.Distribution[i].OpsPct = 100 * float64(load.Ops) / float64(totalOps)
+		}
+	}
+	coll.HotShard = getHotShard(coll.Distribution)
+	if coll.SampledCount > 0 && len(coll.Cardinality) > 0 {
+		coll.Selectivity = float64(coll.Cardinality[0].Count) / float64(coll.SampledCount)
+	}
+
+	coll.Reasons = []string{}
+	if coll.Monotonic != "" {
+		coll.Reasons = append(coll.Reasons, fmt.Sprintf("monotonic shard key, %v, inserts go to one shard", coll.Monotonic))
+	}
+	if coll.SampledCount >= monotonicSamples && coll.Selectivity > 0 && coll.Selectivity < lowSelectivity {
+		coll.Reasons = append(coll.Reasons, fmt.Sprintf("low cardinality of %v, %d distinct values of %d samples",
+			coll.Key[0].Key, coll.Cardinality[0].Count, coll.SampledCount))
+	}
+	if coll.DataSkewPct > skewedDataPct {
+		reason := fmt.Sprintf("data skewed by %.0f%% of the average", coll.DataSkewPct)
+		if coll.ChunkSkewPct <= balancedChunksPct {
+			reason += fmt.Sprintf(" while chunks are balanced within %.0f%%", coll.ChunkSkewPct)
+		}
+		if coll.Jumbo > 0 {
+			reason += fmt.Sprintf(", %d jumbo chunks", coll.Jumbo)
+		}
+		coll.Reasons = append(coll.Reasons, reason)
+	}
+	if coll.HotShard != "" {
+		coll.Reasons = append(coll.Reasons, fmt.Sprintf("hot shard %v", coll.HotShard))
+	}
+}
+
+// getSkewPct returns the difference of the max and the min in percentage of the average
+func getSkewPct(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var sum float64
+	min, max := values[0], values[0]
+	for _, v := range values {
+		sum += v
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if sum == 0 {
+		return 0
+	}
+	return 100 * (max - min) / (sum / float64(len(values)))
+}
+
+// getHotShard returns the shard of the most ops if more than its fair share
+func getHotShard(loads []ShardLoad) string {
+	if len(loads) < 2 {
+		return ""
+	}
+	hot := loads[0]
+	for _, load := range loads {
+		if load.OpsPct > hot.OpsPct {
+			hot = load
+		}
+	}
+	if hot.Ops > 0 && hot.OpsPct > hotShardRatio*100/float64(len(loads)) {
+		return hot.Shard
+	}
+	return ""
+}
+
+// summarize sums loads of shards of all collections and lists reshard candidates
+func (r *ShardingReport) summarize(shardIDs []string) {
+	r.Shards = []ShardLoad{}
+	for _, id := range shardIDs {
+		r.Shards = append(r.Shards, ShardLoad{Shard: id})
+	}
+	r.Reshard = []string{}
+	var totalData, totalOps int64
+	for _, coll := range r.Collections {
+		if len(coll.Reasons) > 0 {
+			r.Reshard = append(r.Reshard, coll.NS)
+		}
+		for _, load := range coll.Distribution {
+			for i := range r.Shards {
+				if r.Shards[i].Shard == load.Shard {
+					r.Shards[i].Chunks += load.Chunks
+					r.Shards[i].DataSize += load.DataSize
+					r.Shards[i].Jumbo += load.Jumbo
+					r.Shards[i].Ops += load.Ops
+				}
+			}
+			totalData += load.DataSize
+			totalOps += load.Ops
+		}
+	}
+	for i := range r.Shards {
+		if totalData > 0 {
+			r.Shards[i].DataPct = 100 * float64(r.Shards[i].DataSize) / float64(totalData)
+		}
+		if totalOps > 0 {
+			r.Shards[i].OpsPct = 100 * float64(r.Shards[i].Ops) / float64(totalOps)
+		}
+	}
+	r.HotShards = []string{}
+	for _, load := range r.Shards {
+		if load.Ops > 0 && load.OpsPct > hotShardRatio*100/float64(len(r.Shards)) {
+			r.HotShards = append(r.HotShards, load.Shard)
+		}
+	}
+}
+
+// String returns a summary of the sharding report
+func (r *ShardingReport) String() string {
+	var buffer bytes.Buffer
+	b := r.Balancer
+	buffer.WriteString(fmt.Sprintf("Balancer: mode %v, in round %v", b.Mode, b.InRound))
+	if len(b.ActiveWindow) > 0 {
+		buffer.WriteString(fmt.Sprintf(", active window %v-%v", b.ActiveWindow["start"], b.ActiveWindow["stop"]))
+	}
+	buffer.WriteString(fmt.Sprintf("\n  since %v: %d rounds (%d errors), %d chunks moved, %d migrations (%d errors)\n",
+		b.Since.Format(time.RFC3339), b.Rounds, b.RoundErrors, b.ChunksMoved, b.Migrations, b.MigrationErrors))
+	buffer.WriteString("\nShards:\n")
+	for _, load := range r.Shards {
+		buffer.WriteString(fmt.Sprintf("  %v: %d chunks (%d jumbo), data %v (%.1f%%), ops %d (%.1f%%)\n", load.Shard,
+			load.Chunks, load.Jumbo, gox.GetStorageSize(load.DataSize), load.DataPct, load.Ops, load.OpsPct))
+	}
+	if len(r.HotShards) > 0 {
+		buffer.WriteString(fmt.Sprintf("  hot shards: %v\n", strings.Join(r.HotShards, ", ")))
+	}
+	buffer.WriteString("\nSharded collections:\n")
+	for _, coll := range r.Collections {
+		buffer.WriteString(fmt.Sprintf("  %v %v, chunk skew %.0f%%, data skew %.0f%%, selectivity %.4f\n",
+			coll.NS, coll.KeyString, coll.ChunkSkewPct, coll.DataSkewPct, coll.Selectivity))
+		for _, reason := range coll.Reasons {
+			buffer.WriteString(fmt.Sprintf("    * %v\n", reason))
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("\nReshard candidates (%d): %v\n", len(r.Reshard), strings.Join(r.Reshard, ", ")))
+	return buffer.String()
+}
+
+// OutputBSON writes the report to a gzipped BSON file and an HTML file
+func (r *ShardingReport) OutputBSON() ([]string, error) {
+	var err error
+	var data []byte
+	if data, err = bson.Marshal(r); err != nil {
+		return nil, err
+	}
+	os.Mkdir(outdir, 0755)
+	basename := strings.ReplaceAll(r.Host, ":", "_")
+	ofile := fmt.Sprintf(`%v/%v%v.bson.gz`, outdir, basename, shardingExt)
+	if err = gox.OutputGzipped(data, ofile); err != nil {
+		return nil, err
+	}
+	htmlGen := NewHTMLGenerator(r.version)
+	var hfile string
+	if hfile, err = htmlGen.GenerateShardingHTML(r); err != nil {
+		return []string{ofile}, err
+	}
+	return []string{ofile, hfile}, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetSkewPct(t *testing.T) {
+	if pct := getSkewPct([]float64{100, 100, 100}); pct != 0 {
+		t.Fatal("expected no skew", pct)
+	}
+	if pct := getSkewPct([]float64{300, 100, 200}); pct != 100 {
+		t.Fatal("expected 100% skew", pct)
+	}
+	if pct := getSkewPct([]float64{0, 0}); pct != 0 {
+		t.Fatal("expected no skew of empty shards", pct)
+	}
+}
+
+func TestGetMonotonicReason(t *testing.T) {
+	now := time.Now()
+	ids, values := []interface{}{}, []interface{}{}
+	for i := 0; i < 10; i++ {
+		ids = append(ids, primitive.NewObjectIDFromTimestamp(now.Add(-time.Duration(i)*time.Second)))
+		values = append(values, int32(100-i))
+	}
+	if reason := getMonotonicReason("seq", ids, values); reason != "values increase with insertion order" {
+		t.Fatal("expected monotonic values", reason)
+	}
+	values[3], values[5], values[7] = int32(1), int32(200), int32(3)
+	if reason := getMonotonicReason("seq", ids, values); reason != "" {
+		t.Fatal("expected random values", reason)
+	}
+	if reason := getMonotonicReason("seq", []interface{}{"a", "b"}, []interface{}{int32(2), int32(1)}); reason != "" {
+		t.Fatal("expected unknown insertion order", reason)
+	}
+	if reason := getMonotonicReason("_id", ids, ids[:1]); reason != "ObjectId increases with time" {
+		t.Fatal("expected monotonic _id", reason)
+	}
+
+	// foreign keys and dates not of insertion order are not monotonic by types
+	customerIDs, birthDates, createdAt := []interface{}{}, []interface{}{}, []interface{}{}
+	for i := 0; i < 10; i++ {
+		offset := time.Duration((i*7)%10) * time.Hour
+		customerIDs = append(customerIDs, primitive.NewObjectIDFromTimestamp(now.Add(-offset)))
+		birthDates = append(birthDates, primitive.NewDateTimeFromTime(now.Add(-offset*24)))
+		createdAt = append(createdAt, primitive.NewDateTimeFromTime(now.Add(-time.Duration(i)*time.Minute)))
+	}
+	if reason := getMonotonicReason("customerId", ids, customerIDs); reason != "" {
+		t.Fatal("expected foreign key not monotonic", reason)
+	}
+	if reason := getMonotonicReason("birthDate", ids, birthDates); reason != "" {
+		t.Fatal("expected birth date not monotonic", reason)
+	}
+	if reason := getMonotonicReason("createdAt", ids, createdAt); reason != "date increases with insertion order" {
+		t.Fatal("expected monotonic date", reason)
+	}
+	if !isHashedKey(bson.D{{Key: "email", Value: "hashed"}}) || isHashedKey(bson.D{{Key: "email", Value: 1}}) {
+		t.Fatal("unexpected hashed key detection")
+	}
+}
+
+func TestAnalyzeShardedCollection(t *testing.T) {
+	coll := ShardedCollection{NS: "keyhole.cars", Key: bson.D{{Key: "color", Value: 1}},
+		Cardinality: []CardinalityCount{{Field: "color", Count: 5}}, SampledCount: 1000,
+		Distribution: []ShardLoad{
+			{Shard: "shard0", Chunks: 10, DataSize: 9000, Jumbo: 2, Ops: 900},
+			{Shard: "shard1", Chunks: 10, DataSize: 1000, Ops: 100}}}
+	analyzeShardedCollection(&coll)
+	if coll.ChunkSkewPct != 0 || coll.DataSkewPct != 160 || coll.Jumbo != 2 || coll.Distribution[0].OpsPct != 90 {
+		t.Fatal("unexpected skews", coll.ChunkSkewPct, coll.DataSkewPct, coll.Jumbo, coll.Distribution)
+	}
+	if coll.HotShard != "shard0" || coll.Selectivity != 0.005 || len(coll.Reasons) != 3 {
+		t.Fatal("expected hot shard, low cardinality, and data skew", coll.HotShard, coll.Selectivity, coll.Reasons)
+	}
+
+	balanced := ShardedCollection{NS: "keyhole.users", Key: bson.D{{Key: "email", Value: "hashed"}},
+		Cardinality: []CardinalityCount{{Field: "email", Count: 1000}}, SampledCount: 1000,
+		Distribution: []ShardLoad{{Shard: "shard0", Chunks: 4, DataSize: 1000, Ops: 50}, {Shard: "shard1", Chunks: 4, DataSize: 1100, Ops: 60}}}
+	analyzeShardedCollection(&balanced)
+	if len(balanced.Reasons) != 0 || balanced.HotShard != "" {
+		t.Fatal("expected no reasons to reshard", balanced.Reasons)
+	}
+
+	r := NewShardingReport("utest-xxxxxx")
+	r.Collections = []ShardedCollection{coll, balanced}
+	r.summarize([]string{"shard0", "shard1"})
+	if len(r.Reshard) != 1 || r.Reshard[0] != "keyhole.cars" || r.Shards[0].Chunks != 14 ||
+		len(r.HotShards) != 1 || r.HotShards[0] != "shard0" {
+		t.Fatal("unexpected summary", r.Reshard, r.Shards, r.HotShards)
+	}
+	if !strings.Contains(r.String(), "Reshard candidates (1): keyhole.cars") {
+		t.Fatal("unexpected report", r.String())
+	}
+	templ, err := NewHTMLGenerator("utest-xxxxxx").GetShardingTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = templ.Execute(&buf, r); err != nil || !strings.Contains(buf.String(), "hot shard shard0") {
+		t.Fatal("unexpected HTML", err)
+	}
+}
+
+func TestSummarizeBalancerLogs(t *testing.T) {
+	str := `{"rounds": [
+			{"what": "balancer.round", "time": {"$date": "2021-03-01T10:00:00Z"}, "details": {"chunksMoved": 2, "errorOccured": false}},
+			{"what": "balancer.round", "time": {"$date": "2021-03-01T10:10:00Z"}, "details": {"chunksMoved": 0, "errorOccured": true}}],
+		"changes": [
+			{"what": "moveChunk.commit", "ns": "keyhole.cars", "time": {"$date": "2021-03-01T09:00:00Z"}},
+			{"what": "moveChunk.commit", "ns": "keyhole.cars", "time": {"$date": "2021-03-01T10:00:00Z"}},
+			{"what": "moveChunk.error", "ns": "keyhole.users", "time": {"$date": "2021-03-01T10:05:00Z"}}]}`
+	var doc struct {
+		Changes []bson.M `bson:"changes"`
+		Rounds  []bson.M `bson:"rounds"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(str), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	status := BalancerStatus{MigrationsByNS: map[string]int{}}
+	summarizeBalancerLogs(&status, doc.Rounds, doc.Changes)
+	if status.Rounds != 2 || status.RoundErrors != 1 || status.ChunksMoved != 2 ||
+		status.LastRound.Format(time.RFC3339) != "2021-03-01T10:10:00Z" {
+		t.Fatal("unexpected balancer rounds", status)
+	}
+	if status.Migrations != 2 || status.MigrationErrors != 1 || status.MigrationsByNS["keyhole.cars"] != 2 ||
+		status.Since.Format(time.RFC3339) != "2021-03-01T09:00:00Z" {
+		t.Fatal("unexpected migrations", status)
+	}
+}
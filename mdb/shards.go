@@ -26,7 +26,11 @@ type Shard struct {
 
 // GetShards return all shards from listShards command
 func GetShards(client *mongo.Client) ([]Shard, error) {
-	ctx := context.Background()
+	return GetShardsWithContext(context.Background(), client)
+}
+
+// GetShardsWithContext returns shards within a context
+func GetShardsWithContext(ctx context.Context, client *mongo.Client) ([]Shard, error) {
 	var shardsInfo struct {
 		Shards []Shard
 	}
@@ -0,0 +1,380 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	trendExt       = "-trend"
+	topNGrowing    = 10
+	secondsPerDay  = 86400
+	noForecastDays = -1 // not growing or capacity unknown
+)
+
+// StatsTrend computes growth of databases and collections from snapshots of -allinfo
+type StatsTrend struct {
+	Cache       *CapacityForecast `json:"cache,omitempty"` // indexes to fill WiredTiger cache
+	Collections []GrowthTrend     `json:"collections"`
+	Databases   []GrowthTrend     `json:"databases"`
+	Disk        *CapacityForecast `json:"disk,omitempty"`
+	Fastest     []GrowthTrend     `json:"fastest"` // fastest growing collections by data size
+	From        time.Time         `json:"from"`
+	Host        string            `json:"host"`
+	Snapshots   []string          `json:"snapshots"`
+	To          time.Time         `json:"to"`
+
+	Logger  *gox.Logger `json:"-"`
+	verbose bool
+	version string
+}
+
+// GrowthTrend stores growth of a database or a collection
+type GrowthTrend struct {
+	Count       SizeGrowth `json:"count"`
+	DataSize    SizeGrowth `json:"dataSize"`
+	IndexSize   SizeGrowth `json:"indexSize"`
+	Name        string     `json:"name"`
+	StorageSize SizeGrowth `json:"storageSize"`
+}
+
+// SizeGrowth stores growth of a metric from the first to the last snapshot
+type SizeGrowth struct {
+	First  int64   `json:"first"`
+	Last   int64   `json:"last"`
+	PerDay float64 `json:"perDay"` // slope of the linear fit of all snapshots
+	Pct    float64 `json:"pct"`    // growth percentage of the period
+}
+
+// CapacityForecast stores days until a capacity is exhausted at the current growth rate
+type CapacityForecast struct {
+	Capacity int64   `json:"capacity"`
+	DaysLeft float64 `json:"daysLeft"` // -1 if not growing or capacity unknown
+	PerDay   float64 `json:"perDay"`
+	Used     int64   `json:"used"`
+}
+
+// statsSnapshot stores a -stats.bson.gz file with collections
+type statsSnapshot struct {
+	filename string
+	stats    ClusterStats
+	time     time.Time
+}
+
+// NewStatsTrend returns StatsTrend
+func NewStatsTrend(version string) *StatsTrend {
+	return &StatsTrend{Logger: gox.GetLogger(version), version: version}
+}
+
+// SetVerbose sets verbosity
+func (t *StatsTrend) SetVerbose(verbose bool) {
+	t.verbose = verbose
+}
+
+// AnalyzeFiles computes trends from -stats.bson.gz files of the same cluster
+func (t *StatsTrend) AnalyzeFiles(filenames []string) error {
+	snapshots := []statsSnapshot{}
+	for _, filename := range filenames {
+		snapshot, err := readStatsSnapshot(filename)
+		if err != nil {
+			return fmt.Errorf("%v: %v", filename, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return t.analyze(snapshots)
+}
+
+// readStatsSnapshot reads cluster stats and collections following it from a -stats.bson.gz file
+func readStatsSnapshot(filename string) (statsSnapshot, error) {
+	var err error
+	snapshot := statsSnapshot{filename: filename}
+	if !strings.HasSuffix(filename, "-stats.bson.gz") {
+		return snapshot, errors.New("unsupported file type")
+	}
+	if snapshot.stats, err = ReadClusterStatsFile(filename); err != nil {
+		return snapshot, err
+	}
+	snapshot.time = snapshot.stats.ServerStatus.LocalTime
+	return snapshot, nil
+}
+
+// analyze computes growth and forecasts from snapshots
+func (t *StatsTrend) analyze(snapshots []statsSnapshot) error {
+	if len(snapshots) < 2 {
+		return errors.New("at least 2 snapshots are required")
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].time.Before(snapshots[j].time) })
+	first, last := snapshots[0], snapshots[len(snapshots)-1]
+	if !last.time.After(first.time) {
+		return errors.New("snapshots must be taken at different times")
+	}
+	t.Host = last.stats.HostInfo.System.Hostname
+	t.From, t.To = first.time, last.time
+	t.Snapshots = []string{}
+	for _, s := range snapshots {
+		t.Snapshots = append(t.Snapshots, s.filename)
+		if s.stats.HostInfo.System.Hostname != t.Host {
+			t.Logger.Warnf(`%v is of host %v, not %v`, s.filename, s.stats.HostInfo.System.Hostname, t.Host)
+		}
+	}
+
+	days := []float64{}
+	for _, s := range snapshots {
+		days = append(days, s.time.Sub(first.time).Seconds()/secondsPerDay)
+	}
+	dbSeries := map[string][]growthPoint{}
+	collSeries := map[string][]growthPoint{}
+	totals := make([]growthPoint, len(snapshots))
+	for i, s := range snapshots {
+		for _, db := range *s.stats.Databases {
+			p := growthPoint{Count: db.Stats.Objects, DataSize: db.Stats.DataSize, IndexSize: db.Stats.IndexSize,
+				StorageSize: db.Stats.StorageSize}
+			dbSeries[db.Name] = appendGrowthPoint(dbSeries[db.Name], i, p, len(snapshots))
+			totals[i].add(p)
+			for _, coll := range db.Collections {
+				p := growthPoint{Count: coll.Stats.Count, DataSize: coll.Stats.Size, IndexSize: coll.Stats.TotalIndexSize,
+					StorageSize: coll.Stats.StorageSize}
+				collSeries[coll.NS] = appendGrowthPoint(collSeries[coll.NS], i, p, len(snapshots))
+			}
+		}
+	}
+	t.Databases = getGrowthTrends(dbSeries, days)
+	t.Collections = getGrowthTrends(collSeries, days)
+	t.Fastest = []GrowthTrend{}
+	fastest := append([]GrowthTrend{}, t.Collections...)
+	sort.SliceStable(fastest, func(i, j int) bool { return fastest[i].DataSize.PerDay > fastest[j].DataSize.PerDay })
+	for _, trend := range fastest {
+		if len(t.Fastest) == topNGrowing || trend.DataSize.PerDay <= 0 {
+			break
+		}
+		t.Fastest = append(t.Fastest, trend)
+	}
+
+	total := getGrowthTrend("", totals, days)
+	if capacity, used := getDiskUsage(last.stats); capacity > 0 {
+		perDay := total.StorageSize.PerDay + total.IndexSize.PerDay
+		t.Disk = &CapacityForecast{Capacity: capacity, Used: used, PerDay: perDay,
+			DaysLeft: getDaysLeft(capacity, used, perDay)}
+	}
+	if capacity := getCacheCapacity(last.stats); capacity > 0 {
+		t.Cache = &CapacityForecast{Capacity: capacity, Used: total.IndexSize.Last, PerDay: total.IndexSize.PerDay,
+			DaysLeft: getDaysLeft(capacity, total.IndexSize.Last, total.IndexSize.PerDay)}
+	}
+	return nil
+}
+
+// growthPoint stores sizes of a snapshot
+type growthPoint struct {
+	Count       int64
+	DataSize    int64
+	IndexSize   int64
+	StorageSize int64
+
+	exists bool
+}
+
+func (p *growthPoint) add(other growthPoint) {
+	p.Count += other.Count
+	p.DataSize += other.DataSize
+	p.IndexSize += other.IndexSize
+	p.StorageSize += other.StorageSize
+	p.exists = true
+}
+
+// appendGrowthPoint sets a point of the i-th snapshot of a series
+func appendGrowthPoint(series []growthPoint, i int, p growthPoint, n int) []growthPoint {
+	if series == nil {
+		series = make([]growthPoint, n)
+	}
+	series[i].add(p)
+	return series
+}
+
+// getGrowthTrends returns trends of series sorted by names
+func getGrowthTrends(series map[string][]growthPoint, days []float64) []GrowthTrend {
+	trends := []GrowthTrend{}
+	for name, points := range series {
+		trends = append(trends, getGrowthTrend(name, points, days))
+	}
+	sort.Slice(trends, func(i, j int) bool { return trends[i].Name < trends[j].Name })
+	return trends
+}
+
+// getGrowthTrend returns growth of snapshots where a database or a collection exists
+func getGrowthTrend(name string, points []growthPoint, days []float64) GrowthTrend {
+	x := []float64{}
+	values := [4][]float64{}
+	for i, p := range points {
+		if !p.exists {
+			continue
+		}
+		x = append(x, days[i])
+		for j, v := range []int64{p.Count, p.DataSize, p.IndexSize, p.StorageSize} {
+			values[j] = append(values[j], float64(v))
+		}
+	}
+	growths := [4]SizeGrowth{}
+	for j := range values {
+		growths[j] = getSizeGrowth(x, values[j])
+	}
+	return GrowthTrend{Name: name, Count: growths[0], DataSize: growths[1], IndexSize: growths[2], StorageSize: growths[3]}
+}
+
+// getSizeGrowth returns growth of values with a least squares slope per day
+func getSizeGrowth(x []float64, y []float64) SizeGrowth {
+	growth := SizeGrowth{}
+	if len(y) == 0 {
+		return growth
+	}
+	growth.First, growth.Last = int64(y[0]), int64(y[len(y)-1])
+	if growth.First > 0 {
+		growth.Pct = 100 * float64(growth.Last-growth.First) / float64(growth.First)
+	}
+	if len(x) < 2 {
+		return growth
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	n := float64(len(x))
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+		sumXY += x[i] * y[i]
+		sumXX += x[i] * x[i]
+	}
+	if d := n*sumXX - sumX*sumX; d != 0 {
+		growth.PerDay = (n*sumXY - sumX*sumY) / d
+	}
+	return growth
+}
+
+// getDaysLeft returns days until used reaches capacity
+func getDaysLeft(capacity int64, used int64, perDay float64) float64 {
+	if perDay <= 0 {
+		return noForecastDays
+	}
+	return math.Max(0, float64(capacity-used)/perDay)
+}
+
+// getDiskUsage returns file system total and used sizes of dbStats, summed of shards
+func getDiskUsage(stats ClusterStats) (int64, int64) {
+	var total, used int64
+	for _, db := range *stats.Databases {
+		if db.Stats.FsTotalSize > 0 { // all databases are on the same file system
+			return db.Stats.FsTotalSize, db.Stats.FsUsedSize
+		}
+		for _, v := range db.Stats.Raw {
+			var shard bson.M
+			switch doc := v.(type) {
+			case bson.M:
+				shard = doc
+			case bson.D:
+				shard = doc.Map()
+			}
+			total += toInt64(shard["fsTotalSize"])
+			used += toInt64(shard["fsUsedSize"])
+		}
+		if total > 0 {
+			return total, used
+		}
+	}
+	return total, used
+}
+
+// getCacheCapacity returns WiredTiger cache size, summed of primaries of shards
+func getCacheCapacity(stats ClusterStats) int64 {
+	if size := toInt64(stats.ServerStatus.WiredTiger.Cache["maximum bytes configured"]); size > 0 {
+		return size
+	}
+	var total int64
+	for _, shard := range stats.Shards {
+		for _, server := range shard.Servers {
+			if server.ServerStatus.Repl.IsMaster {
+				total += toInt64(server.ServerStatus.WiredTiger.Cache["maximum bytes configured"])
+			}
+		}
+	}
+	return total
+}
+
+// String returns a summary of trends
+func (t *StatsTrend) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Trend of %v, %d snapshots from %v to %v\n", t.Host, len(t.Snapshots),
+		t.From.Format(time.RFC3339), t.To.Format(time.RFC3339)))
+	forecasts := []struct {
+		name     string
+		forecast *CapacityForecast
+	}{{"Disk", t.Disk}, {"WiredTiger cache by indexes", t.Cache}}
+	for _, f := range forecasts {
+		if f.forecast == nil {
+			buffer.WriteString(fmt.Sprintf("%v: capacity unknown\n", f.name))
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("%v: %v of %v used, %v per day, %v\n", f.name, gox.GetStorageSize(f.forecast.Used),
+			gox.GetStorageSize(f.forecast.Capacity), gox.GetStorageSize(int64(f.forecast.PerDay)), getDaysLeftString(f.forecast.DaysLeft)))
+	}
+	buffer.WriteString("\nDatabases:\n")
+	for _, trend := range t.Databases {
+		buffer.WriteString(getGrowthTrendString(trend))
+	}
+	buffer.WriteString(fmt.Sprintf("\nFastest growing collections (%d):\n", len(t.Fastest)))
+	for _, trend := range t.Fastest {
+		buffer.WriteString(getGrowthTrendString(trend))
+	}
+	if t.verbose {
+		buffer.WriteString("\nCollections:\n")
+		for _, trend := range t.Collections {
+			buffer.WriteString(getGrowthTrendString(trend))
+		}
+	}
+	return buffer.String()
+}
+
+// getGrowthTrendString returns growth of a database or a collection in a line
+func getGrowthTrendString(trend GrowthTrend) string {
+	return fmt.Sprintf("  %v: data %v (%+.1f%%, %v/day), storage %v (%+.1f%%), indexes %v (%+.1f%%), docs %d (%+.1f%%)\n",
+		trend.Name, gox.GetStorageSize(trend.DataSize.Last), trend.DataSize.Pct, gox.GetStorageSize(int64(trend.DataSize.PerDay)),
+		gox.GetStorageSize(trend.StorageSize.Last), trend.StorageSize.Pct, gox.GetStorageSize(trend.IndexSize.Last),
+		trend.IndexSize.Pct, trend.Count.Last, trend.Count.Pct)
+}
+
+// getDaysLeftString returns days left of a forecast
+func getDaysLeftString(days float64) string {
+	if days < 0 {
+		return "not growing"
+	}
+	return fmt.Sprintf("exhausted in %.0f days", days)
+}
+
+// OutputJSON writes trends to a JSON file and an HTML file
+func (t *StatsTrend) OutputJSON() ([]string, error) {
+	var err error
+	var data []byte
+	if data, err = json.MarshalIndent(t, "", "  "); err != nil {
+		return nil, err
+	}
+	os.Mkdir(outdir, 0755)
+	basename := strings.ReplaceAll(t.Host, ":", "_")
+	ofile := fmt.Sprintf("%v/%v%v.json", outdir, basename, trendExt)
+	if err = os.WriteFile(ofile, data, 0644); err != nil {
+		return nil, err
+	}
+	htmlGen := NewHTMLGenerator(t.version)
+	var hfile string
+	if hfile, err = htmlGen.GenerateTrendHTML(t); err != nil {
+		return []string{ofile}, err
+	}
+	return []string{ofile, hfile}, err
+}
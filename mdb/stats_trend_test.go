@@ -0,0 +1,109 @@
+// Copyright 2021 Kuei-chun Chen. All rights reserved.
+
+package mdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetSizeGrowth(t *testing.T) {
+	growth := getSizeGrowth([]float64{0, 7, 14, 21}, []float64{100, 170, 240, 310})
+	if growth.First != 100 || growth.Last != 310 || math.Abs(growth.PerDay-10) > 1e-9 || growth.Pct != 210 {
+		t.Fatal("unexpected growth", growth)
+	}
+	if days := getDaysLeft(1000, 310, growth.PerDay); math.Abs(days-69) > 1e-9 {
+		t.Fatal("expected 69 days, but", days)
+	}
+	if days := getDaysLeft(1000, 310, 0); days != noForecastDays {
+		t.Fatal("expected no forecast, but", days)
+	}
+}
+
+func TestStatsTrendAnalyzeFiles(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	filenames := []string{}
+	for week := 2; week >= 0; week-- { // out of order
+		filename := filepath.Join(dir, fmt.Sprintf("keyhole.%d-stats.bson.gz", week))
+		writeStatsSnapshot(t, filename, start.Add(time.Duration(week)*7*24*time.Hour), int64(week))
+		filenames = append(filenames, filename)
+	}
+	trend := NewStatsTrend("utest-xxxxxx")
+	if err := trend.AnalyzeFiles(filenames[:1]); err == nil {
+		t.Fatal("expected error of a single snapshot")
+	}
+	if err := trend.AnalyzeFiles(filenames); err != nil {
+		t.Fatal(err)
+	}
+	if !trend.From.Equal(start) || len(trend.Databases) != 1 || len(trend.Collections) != 2 {
+		t.Fatal("unexpected trend", trend.From, trend.Databases, trend.Collections)
+	}
+	if len(trend.Fastest) != 1 || trend.Fastest[0].Name != "keyhole.cars" ||
+		math.Abs(trend.Fastest[0].DataSize.PerDay-1024) > 1e-6 || trend.Fastest[0].Count.Last != 300 {
+		t.Fatal("unexpected fastest growing collections", trend.Fastest)
+	}
+	// storage and index sizes of the database grow 2048 + 512 bytes a day
+	if trend.Disk == nil || math.Abs(trend.Disk.PerDay-2560) > 1e-6 || math.Abs(trend.Disk.DaysLeft-400) > 1e-6 {
+		t.Fatal("unexpected disk forecast", trend.Disk)
+	}
+	if trend.Cache == nil || trend.Cache.Used != 1024*1024+512*14 || math.Abs(trend.Cache.PerDay-512) > 1e-6 {
+		t.Fatal("unexpected cache forecast", trend.Cache)
+	}
+	templ, err := NewHTMLGenerator("utest-xxxxxx").GetTrendTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	if err = templ.Execute(&buffer, trend); err != nil {
+		t.Fatal(err)
+	}
+	t.Log(trend.String())
+}
+
+// writeStatsSnapshot writes a -stats.bson.gz file of a cluster stats followed by collections
+func writeStatsSnapshot(t *testing.T, filename string, localTime time.Time, week int64) {
+	days := 7 * week
+	var stats ClusterStats
+	stats.HostInfo.System.Hostname = "keyhole.local:27017"
+	stats.ServerStatus.LocalTime = localTime
+	stats.ServerStatus.WiredTiger.Cache = bson.M{"maximum bytes configured": int64(1024 * 1024 * 1024)}
+	db := Database{Name: "keyhole"}
+	db.Stats.DataSize = 1024 * 1024 * (1 + days)
+	db.Stats.StorageSize = 1024*1024 + 2048*days
+	db.Stats.IndexSize = 1024*1024 + 512*days
+	db.Stats.FsTotalSize = 1024 * 1024 * 1024
+	db.Stats.FsUsedSize = 1024*1024*1024 - 1024000
+	stats.Databases = &[]Database{db}
+	cars := Collection{Name: "cars", NS: "keyhole.cars"}
+	cars.Stats.Count = 100 + 100*week
+	cars.Stats.Size = 1024 * (1 + days)
+	cars.Stats.TotalIndexSize = 1024*1024 + 512*days
+	dealers := Collection{Name: "dealers", NS: "keyhole.dealers"}
+	dealers.Stats.Count = 10
+	dealers.Stats.Size = 4096
+
+	var buffer bytes.Buffer
+	for _, doc := range []interface{}{stats, cars, dealers} {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buffer.Write(data)
+	}
+	var zbuf bytes.Buffer
+	gz := gzip.NewWriter(&zbuf)
+	gz.Write(buffer.Bytes())
+	gz.Close()
+	if err := os.WriteFile(filename, zbuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
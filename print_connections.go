@@ -37,9 +37,15 @@ type Logv2Network struct {
 func PrintConnections(cfg *Config) error {
 	var err error
 	if cfg.Filename != "" {
-		if err = PrintConnectionsFromFile(cfg.Filename); err != nil {
+		var churn *ConnectionChurn
+		if churn, err = GetConnectionChurnFromFile(cfg.Filename, cfg.ShortLivedSeconds); err != nil {
 			return err
 		}
+		var ofile string
+		if ofile, err = churn.OutputJSON(); err != nil {
+			return err
+		}
+		fmt.Println("json data written to", ofile)
 	}
 	if cfg.URI != "" {
 		if err = PrintConnectionsFromURI(cfg.URI); err != nil {
@@ -51,14 +57,21 @@ func PrintConnections(cfg *Config) error {
 
 // PrintConnectionsFromFile print all connection info from a log file
 func PrintConnectionsFromFile(filename string) error {
+	_, err := GetConnectionChurnFromFile(filename, defaultShortLivedSeconds)
+	return err
+}
+
+// GetConnectionChurnFromFile prints all connection info and returns connection churn from a log file
+func GetConnectionChurnFromFile(filename string, shortLivedSeconds int) (*ConnectionChurn, error) {
 	var err error
 	var reader *bufio.Reader
 	if reader, err = gox.NewFileReader(filename); err != nil {
-		return err
+		return nil, err
 	}
 	accepted := 0
 	ended := 0
-	connMap := map[string][2]int{}
+	churn := NewConnectionChurn(filename, shortLivedSeconds)
+	clients := []mdb.ClientInfo{}
 	for {
 		var data []byte
 		if data, _, err = reader.ReadLine(); err != nil { // 0x0A separator = newline
@@ -68,34 +81,35 @@ func PrintConnectionsFromFile(filename string) error {
 		if !strings.Contains(str, `"c":"NETWORK"`) {
 			continue
 		}
+		if conn, remote, client, cerr := mdb.ParseClientMetadata(str); cerr == nil {
+			fmt.Printf(" - conn ID: %-10s client: %-40s app: %v, driver: %v %v, os: %v\n", conn, remote,
+				client.App, client.Driver, client.DriverVersion, client.OS)
+			clients = mdb.MergeClientInfos(clients, []mdb.ClientInfo{client})
+			continue
+		}
 		var doc Logv2Network
 		if err = json.Unmarshal(data, &doc); err != nil || doc.Component != "NETWORK" {
 			continue
 		}
-		strs := strings.Split(doc.Attributes.Remote, ":")
-		if len(connMap[strs[0]]) == 0 {
-			connMap[strs[0]] = [2]int{0, 0}
-		}
-		nums := connMap[strs[0]]
 		if doc.Message == "Connection accepted" {
 			accepted++
 			fmt.Printf(" - conn ID: %-10d desc: conn%-10d client: %-40s\n", doc.Attributes.ConnectionID, doc.Attributes.ConnectionID, doc.Attributes.Remote)
-			nums[0]++
-			connMap[strs[0]] = nums
 		} else if doc.Message == "Connection ended" {
 			ended++
-			nums[1]++
-			connMap[strs[0]] = nums
 		}
+		churn.Add(doc)
 	}
+	churn.Finalize()
 	fmt.Println()
 	fmt.Println("Summary:")
 	fmt.Println(" - connections accepted", accepted)
 	fmt.Println(" - connections ended", ended)
-	for k, v := range connMap {
-		fmt.Printf(" - %v had %v accepted, %v ended\n", k, v[0], v[1])
+	churn.Print()
+	for _, client := range clients {
+		fmt.Printf(" - app: %v, driver: %v %v, os: %v had %v connections\n", client.App, client.Driver,
+			client.DriverVersion, client.OS, client.Connections)
 	}
-	return nil
+	return churn, nil
 }
 
 // PrintConnectionsFromURI print all connection info from all mongod